@@ -0,0 +1,180 @@
+package base
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+	toolkits "my2sql/toolkits"
+)
+
+// BinlogFileList file 模式下要解析的 binlog 文件列表。
+//
+// 可以来自 mysql-bin.index/relay-log.index(-binlog-index)，按 index 文件中的顺序解析；
+// 也可以是逗号分隔的文件名或者通配符(-binlog-files)，按 binlog 序号排序后解析。
+// 文件名不要求连续，PURGE BINARY LOGS 之后、从备份恢复的 binlog 中间缺失文件时也可以正常解析。
+type BinlogFileList struct {
+	indexFile string   // index 文件，为空表示 -binlog-files
+	patterns  []string // -binlog-files 中的文件名或者通配符
+
+	Files []string // 按解析顺序排列的 binlog 文件路径
+}
+
+// NewBinlogFileList indexFile 和 files 只能指定一个
+func NewBinlogFileList(indexFile string, files string) (*BinlogFileList, error) {
+	this := &BinlogFileList{indexFile: indexFile}
+	if indexFile == "" {
+		this.patterns = CommaSeparatedListToArray(files)
+	}
+
+	if err := this.Reload(); err != nil {
+		return nil, err
+	}
+	if len(this.Files) == 0 {
+		return nil, errors.Errorf("no binlog file found in %s%s", indexFile, files)
+	}
+	return this, nil
+}
+
+// Reload 重新读取 index 文件或者匹配通配符，-follow 模式下用来发现新生成的 binlog 文件
+func (this *BinlogFileList) Reload() error {
+	var (
+		files []string
+		err   error
+	)
+	if this.indexFile != "" {
+		files, err = ReadBinlogIndexFile(this.indexFile)
+	} else {
+		files, err = GlobBinlogFiles(this.patterns)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 只检查新增加的文件
+	start := len(this.Files) - 1
+	if start < 0 {
+		start = 0
+	}
+	CheckBinlogFilesGap(files, start)
+	this.Files = files
+	return nil
+}
+
+// IndexOf 返回 binlog 在列表中的下标，按文件名匹配，不存在返回 -1
+func (this *BinlogFileList) IndexOf(binlog string) int {
+	name := GetBinlogName(binlog)
+	for i, file := range this.Files {
+		if GetBinlogName(file) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Next 返回 binlog 的下一个文件，没有返回空
+func (this *BinlogFileList) Next(binlog string) string {
+	i := this.IndexOf(binlog)
+	if i < 0 || i+1 >= len(this.Files) {
+		return ""
+	}
+	return this.Files[i+1]
+}
+
+// ReadBinlogIndexFile 读取 mysql-bin.index/relay-log.index 。
+// 其中的路径一般是相对 datadir 的(./mysql-bin.000001)，找不到时到 index 文件所在目录下查找。
+func ReadBinlogIndexFile(indexFile string) ([]string, error) {
+	fh, err := os.Open(indexFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer fh.Close()
+
+	var (
+		files []string
+		dir   string = filepath.Dir(indexFile)
+	)
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		file := line
+		if !filepath.IsAbs(file) || !toolkits.IsFile(file) {
+			file = filepath.Join(dir, filepath.Base(line))
+		}
+		if !toolkits.IsFile(file) {
+			log.Warnf("%s in %s not exists, skip it", line, indexFile)
+			continue
+		}
+		files = append(files, file)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Annotatef(err, "fail to read %s", indexFile)
+	}
+	return files, nil
+}
+
+// GlobBinlogFiles 展开文件名和通配符，去重后按 binlog 序号排序
+func GlobBinlogFiles(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid binlog file pattern %s", pattern)
+		}
+		if len(matches) == 0 {
+			log.Warnf("no binlog file matches %s", pattern)
+		}
+		for _, file := range matches {
+			if toolkits.IsFile(file) && !toolkits.ContainsString(files, file) {
+				files = append(files, file)
+			}
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		iBase, iSeq, _ := SplitBinlogName(files[i])
+		jBase, jSeq, _ := SplitBinlogName(files[j])
+		if iBase != jBase {
+			return iBase < jBase
+		}
+		return iSeq < jSeq
+	})
+	return files, nil
+}
+
+// SplitBinlogName 把 mysql-bin.000004(.gz) 拆分为 mysql-bin 和 4
+func SplitBinlogName(binlog string) (string, int, bool) {
+	name := GetBinlogName(binlog)
+	i := strings.LastIndexByte(name, '.')
+	if i < 0 {
+		return name, 0, false
+	}
+	seq, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return name, 0, false
+	}
+	return name[:i], seq, true
+}
+
+// CheckBinlogFilesGap 从 files[start] 开始检查相邻的 binlog 序号是否连续，不连续时报告缺失的文件
+func CheckBinlogFilesGap(files []string, start int) {
+	for i := start + 1; i < len(files); i++ {
+		preBase, preSeq, preOk := SplitBinlogName(files[i-1])
+		curBase, curSeq, curOk := SplitBinlogName(files[i])
+		if !preOk || !curOk || preBase != curBase {
+			continue
+		}
+		if curSeq != preSeq+1 {
+			log.Warnf("binlog files are not continuous, %d files missing between %s and %s",
+				curSeq-preSeq-1, GetBinlogName(files[i-1]), GetBinlogName(files[i]))
+		}
+	}
+}
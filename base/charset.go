@@ -0,0 +1,205 @@
+package base
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"my2sql/sqltypes"
+)
+
+// 字符串列的字符集
+//
+// binlog 中字符串列的值是列字符集的原始字节。生成 sql 时转换为 -sql-charset(执行 sql 时连接使用的字符集)，
+// 不能无损转换的值(字节不合法、目标字符集没有对应的字符、不支持的字符集)用 _latin1 X'..' 形式的字符集引导符输出，
+// 由 mysql 执行时转换。
+
+const (
+	C_charsetUtf8mb4 = "utf8mb4"
+	C_charsetUtf8    = "utf8"
+	C_charsetBinary  = "binary"
+)
+
+var (
+	GOptsValidSqlCharset []string = []string{C_charsetUtf8mb4, C_charsetUtf8}
+
+	// 字符集对应的编码，不在这里的字符集(utf8 系列、binary 、latin1 之外)只能用字符集引导符输出。
+	// mysql 的 latin1 是 cp1252 加上 5 个控制字符，见 DecodeLatin1
+	charsetEncodings map[string]encoding.Encoding = map[string]encoding.Encoding{
+		"latin2":   charmap.ISO8859_2,
+		"latin5":   charmap.ISO8859_9,
+		"latin7":   charmap.ISO8859_13,
+		"greek":    charmap.ISO8859_7,
+		"hebrew":   charmap.ISO8859_8,
+		"cp1250":   charmap.Windows1250,
+		"cp1251":   charmap.Windows1251,
+		"cp1256":   charmap.Windows1256,
+		"cp1257":   charmap.Windows1257,
+		"cp850":    charmap.CodePage850,
+		"cp852":    charmap.CodePage852,
+		"cp866":    charmap.CodePage866,
+		"koi8r":    charmap.KOI8R,
+		"koi8u":    charmap.KOI8U,
+		"macroman": charmap.Macintosh,
+		"tis620":   charmap.Windows874,
+		"gbk":      simplifiedchinese.GBK,
+		"gb2312":   simplifiedchinese.GBK,
+		"gb18030":  simplifiedchinese.GB18030,
+		"big5":     traditionalchinese.Big5,
+		"sjis":     japanese.ShiftJIS,
+		"cp932":    japanese.ShiftJIS,
+		"ujis":     japanese.EUCJP,
+		"eucjpms":  japanese.EUCJP,
+		"euckr":    korean.EUCKR,
+		"ucs2":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+		"utf16":    unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+		"utf16le":  unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+		"utf32":    utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM),
+	}
+
+	// 校对规则编号对应的字符集，用于 binlog_row_metadata=FULL 的 TABLE_MAP_EVENT ，按编号排序
+	collationCharsets []struct {
+		from, to uint64
+		charset  string
+	} = []struct {
+		from, to uint64
+		charset  string
+	}{
+		{1, 1, "big5"}, {2, 2, "latin2"}, {3, 3, "dec8"}, {4, 4, "cp850"}, {5, 5, "latin1"}, {6, 6, "hp8"},
+		{7, 7, "koi8r"}, {8, 8, "latin1"}, {9, 9, "latin2"}, {10, 10, "swe7"}, {11, 11, "ascii"}, {12, 12, "ujis"},
+		{13, 13, "sjis"}, {14, 14, "cp1251"}, {15, 15, "latin1"}, {16, 16, "hebrew"}, {18, 18, "tis620"},
+		{19, 19, "euckr"}, {20, 20, "latin7"}, {21, 21, "latin2"}, {22, 22, "koi8u"}, {23, 23, "cp1251"},
+		{24, 24, "gb2312"}, {25, 25, "greek"}, {26, 26, "cp1250"}, {27, 27, "latin2"}, {28, 28, "gbk"},
+		{29, 29, "cp1257"}, {30, 30, "latin5"}, {31, 31, "latin1"}, {32, 32, "armscii8"}, {33, 33, "utf8"},
+		{34, 34, "cp1250"}, {35, 35, "ucs2"}, {36, 36, "cp866"}, {37, 37, "keybcs2"}, {38, 38, "macce"},
+		{39, 39, "macroman"}, {40, 40, "cp852"}, {41, 42, "latin7"}, {43, 43, "macce"}, {44, 44, "cp1250"},
+		{45, 46, "utf8mb4"}, {47, 49, "latin1"}, {50, 52, "cp1251"}, {53, 53, "macroman"}, {54, 55, "utf16"},
+		{56, 56, "utf16le"}, {57, 57, "cp1256"}, {58, 59, "cp1257"}, {60, 61, "utf32"}, {62, 62, "utf16le"},
+		{63, 63, C_charsetBinary}, {64, 64, "armscii8"}, {65, 65, "ascii"}, {66, 66, "cp1250"}, {67, 67, "cp1256"},
+		{68, 68, "cp866"}, {69, 69, "dec8"}, {70, 70, "greek"}, {71, 71, "hebrew"}, {72, 72, "hp8"},
+		{73, 73, "keybcs2"}, {74, 74, "koi8r"}, {75, 75, "koi8u"}, {76, 76, "utf8"}, {77, 77, "latin2"},
+		{78, 78, "latin5"}, {79, 79, "latin7"}, {80, 80, "cp850"}, {81, 81, "cp852"}, {82, 82, "swe7"},
+		{83, 83, "utf8"}, {84, 84, "big5"}, {85, 85, "euckr"}, {86, 86, "gb2312"}, {87, 87, "gbk"},
+		{88, 88, "sjis"}, {89, 89, "tis620"}, {90, 90, "ucs2"}, {91, 91, "ujis"}, {92, 93, "geostd8"},
+		{94, 94, "latin1"}, {95, 96, "cp932"}, {97, 98, "eucjpms"}, {99, 99, "cp1250"}, {101, 124, "utf16"},
+		{128, 151, "ucs2"}, {159, 159, "ucs2"}, {160, 183, "utf32"}, {192, 215, "utf8"}, {223, 223, "utf8"},
+		{224, 247, "utf8mb4"}, {248, 250, "gb18030"}, {255, 323, "utf8mb4"},
+	}
+)
+
+// GetCharsetOfCollationId 校对规则编号对应的字符集，未知的编号返回空
+func GetCharsetOfCollationId(id uint64) string {
+	for _, cc := range collationCharsets {
+		if id < cc.from {
+			break
+		}
+		if id <= cc.to {
+			return cc.charset
+		}
+	}
+	return ""
+}
+
+// NormalizeCharset 小写的字符集名，utf8mb3 是 utf8 的别名(mysql 8.0.30 之后显示为 utf8mb3)
+func NormalizeCharset(charset string) string {
+	charset = strings.ToLower(charset)
+	if charset == "utf8mb3" {
+		return C_charsetUtf8
+	}
+	return charset
+}
+
+// GetColumnCharset 字符串列的字符集，binary/varbinary 列是 binary ，未知时返回空
+func GetColumnCharset(fi FieldInfo) string {
+	if fi.Charset != "" {
+		return NormalizeCharset(fi.Charset)
+	}
+	if tp := strings.ToLower(fi.FieldType); tp == "binary" || tp == "varbinary" {
+		return C_charsetBinary
+	}
+	return ""
+}
+
+// ConvertStringCharset 把字符集为 charset(小写)的字符串列值 val 转换为 sqlCharset 的字符串，
+// 不能无损转换时返回 sqltypes.CharsetString ，输出为 _charset X'..' 。charset 未知(为空)时不转换
+func ConvertStringCharset(val interface{}, charset string, sqlCharset string) interface{} {
+	str, ok := val.(string)
+	if !ok || charset == "" {
+		return val
+	}
+	switch charset {
+	case C_charsetUtf8mb4, C_charsetUtf8, "ascii":
+		if IsValidInSqlCharset(str, sqlCharset) {
+			return val
+		}
+	case C_charsetBinary:
+	default:
+		if utf8Str, ok := TranscodeCharsetToUtf8(str, charset); ok && IsValidInSqlCharset(utf8Str, sqlCharset) {
+			return utf8Str
+		}
+	}
+	return sqltypes.MakeCharsetString(charset, []byte(str))
+}
+
+// TranscodeCharsetToUtf8 把字符集为 charset(小写)的 str 转换为 utf8 ，不支持的字符集或者有不合法的字节时返回 false
+func TranscodeCharsetToUtf8(str string, charset string) (string, bool) {
+	if charset == "latin1" {
+		return DecodeLatin1(str), true
+	}
+	enc, ok := charsetEncodings[charset]
+	if !ok {
+		return "", false
+	}
+	return TranscodeToUtf8(str, enc)
+}
+
+// DecodeLatin1 mysql 的 latin1 实际是 cp1252 ，cp1252 中没有定义的 0x81、0x8D、0x8F、0x90、0x9D
+// 在 mysql 中转换为同样编号的 unicode 控制字符，所以所有字节都能转换
+func DecodeLatin1(str string) string {
+	var buf strings.Builder
+	buf.Grow(len(str))
+	for i := 0; i < len(str); i++ {
+		r := charmap.Windows1252.DecodeByte(str[i])
+		if r == utf8.RuneError {
+			r = rune(str[i])
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// TranscodeToUtf8 用 enc 把 str 解码为 utf8 ，再编码回去和 str 不一致时(有不合法的字节)返回 false
+func TranscodeToUtf8(str string, enc encoding.Encoding) (string, bool) {
+	utf8Str, err := enc.NewDecoder().String(str)
+	if err != nil {
+		return "", false
+	}
+	back, err := enc.NewEncoder().String(utf8Str)
+	if err != nil || back != str {
+		return "", false
+	}
+	return utf8Str, true
+}
+
+// IsValidInSqlCharset utf8 字符串 str 能否用 sqlCharset 输出，utf8(utf8mb3) 不支持 4 字节的字符
+func IsValidInSqlCharset(str string, sqlCharset string) bool {
+	if !utf8.ValidString(str) {
+		return false
+	}
+	if sqlCharset == C_charsetUtf8mb4 {
+		return true
+	}
+	for _, r := range str {
+		if r > 0xFFFF {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package base
+
+import (
+	"bytes"
+	"testing"
+
+	"my2sql/sqltypes"
+)
+
+// encodeSqlValue 列值输出到 sql 中的形式
+func encodeSqlValue(t *testing.T, val interface{}) string {
+	v, err := sqltypes.BuildValue(val)
+	if err != nil {
+		t.Fatalf("%#v: %v", val, err)
+	}
+	var buf bytes.Buffer
+	v.EncodeSql(&buf)
+	return buf.String()
+}
+
+func TestConvertStringCharset(t *testing.T) {
+	cases := []struct {
+		name       string
+		val        string
+		charset    string
+		sqlCharset string
+		want       string
+	}{
+		{"latin1", "caf\xe9 \x80", "latin1", C_charsetUtf8mb4, "'café €'"},
+		// cp1252 没有定义，mysql 的 latin1 转换为同样编号的控制字符
+		{"latin1 0x81", "\x81", "latin1", C_charsetUtf8mb4, "'\u0081'"},
+		{"latin1 0x8D", "a\x8db", "latin1", C_charsetUtf8mb4, "'a\u008db'"},
+		{"latin1 0x8F", "\x8f", "latin1", C_charsetUtf8, "'\u008f'"},
+		{"latin1 0x90", "\x90", "latin1", C_charsetUtf8mb4, "'\u0090'"},
+		{"latin1 0x9D", "\x9d", "latin1", C_charsetUtf8mb4, "'\u009d'"},
+		{"gbk", "\xd6\xd0\xce\xc4'", "gbk", C_charsetUtf8mb4, "'中文\\''"},
+		{"gbk to utf8", "\xd6\xd0\xce\xc4", "gbk", C_charsetUtf8, "'中文'"},
+		{"invalid gbk", "\xd6", "gbk", C_charsetUtf8mb4, "_gbk X'd6'"},
+		{"gb18030 4 bytes char to utf8", "\x95\x32\x82\x36", "gb18030", C_charsetUtf8, "_gb18030 X'95328236'"},
+		{"gb18030 4 bytes char to utf8mb4", "\x95\x32\x82\x36", "gb18030", C_charsetUtf8mb4, "'\U00020000'"},
+		{"utf8mb4", "中文", C_charsetUtf8mb4, C_charsetUtf8mb4, "'中文'"},
+		{"invalid utf8mb4", "a\xff\xfe", C_charsetUtf8mb4, C_charsetUtf8mb4, "_utf8mb4 X'61fffe'"},
+		{"utf8mb4 emoji to utf8", "\U0001F600", C_charsetUtf8mb4, C_charsetUtf8, "_utf8mb4 X'f09f9880'"},
+		{"binary", "a\x00", C_charsetBinary, C_charsetUtf8mb4, "_binary X'6100'"},
+		{"unsupported charset", "\xa4", "dec8", C_charsetUtf8mb4, "_dec8 X'a4'"},
+		{"unknown charset", "abc", "", C_charsetUtf8mb4, "'abc'"},
+	}
+	for _, c := range cases {
+		got := encodeSqlValue(t, ConvertStringCharset(c.val, c.charset, c.sqlCharset))
+		if got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	// 不是字符串的值不转换
+	if got := ConvertStringCharset(int64(1), "gbk", C_charsetUtf8mb4); got != int64(1) {
+		t.Errorf("got %#v for int64", got)
+	}
+}
+
+func TestDecodeLatin1(t *testing.T) {
+	var all []byte
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
+	}
+	runes := []rune(DecodeLatin1(string(all)))
+	if len(runes) != 256 {
+		t.Fatalf("got %d runes, want 256", len(runes))
+	}
+	// 0x80-0x9F 之外和 iso-8859-1 一样，0x80-0x9F 中 cp1252 没有定义的 5 个字节为同样编号的控制字符
+	special := map[int]rune{0x80: '€', 0x8A: 'Š', 0x99: '™', 0x9F: 'Ÿ', 0x81: 0x81, 0x8D: 0x8D, 0x8F: 0x8F, 0x90: 0x90, 0x9D: 0x9D}
+	for i, r := range runes {
+		want, ok := special[i]
+		if !ok {
+			if i >= 0x80 && i < 0xA0 {
+				if r < 0x100 {
+					t.Errorf("byte %#x: got %U, want a cp1252 character", i, r)
+				}
+				continue
+			}
+			want = rune(i)
+		}
+		if r != want {
+			t.Errorf("byte %#x: got %U, want %U", i, r, want)
+		}
+	}
+}
+
+func TestIsValidInSqlCharset(t *testing.T) {
+	cases := []struct {
+		str        string
+		sqlCharset string
+		want       bool
+	}{
+		{"abc", C_charsetUtf8, true},
+		{"中文", C_charsetUtf8, true},
+		{"\U0001F600", C_charsetUtf8, false},
+		{"\U0001F600", C_charsetUtf8mb4, true},
+		{"a\xff", C_charsetUtf8mb4, false},
+		{"a\xff", C_charsetUtf8, false},
+	}
+	for _, c := range cases {
+		if got := IsValidInSqlCharset(c.str, c.sqlCharset); got != c.want {
+			t.Errorf("%q %s: got %v, want %v", c.str, c.sqlCharset, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package base
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+	constvar "my2sql/constvar"
+)
+
+// Checkpoint 最后一个已完整输出的事务，-resume 时从这里继续解析。
+//
+// Files 记录了该事务输出完成时各个 sql 文件的大小，之后写入的内容属于未完成的事务，
+// -resume 时会把文件截断到这个大小，保证既不重复也不丢失。
+type Checkpoint struct {
+	Binlog    string           `json:"binlog"`
+	Pos       uint32           `json:"position"`
+	GtidSet   string           `json:"gtid_set"`
+	Timestamp uint32           `json:"timestamp"`
+	Files     map[string]int64 `json:"files"`
+}
+
+// ReadCheckpointFile 读取 checkpoint 文件
+func ReadCheckpointFile(file string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cp := &Checkpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Annotatef(err, "invalid checkpoint file %s", file)
+	}
+	if cp.Binlog == "" {
+		return nil, errors.Errorf("invalid checkpoint file %s, binlog is empty", file)
+	}
+	if cp.Files == nil {
+		cp.Files = map[string]int64{}
+	}
+	return cp, nil
+}
+
+// WriteCheckpointFile 先写临时文件再 rename ，保证 checkpoint 文件总是完整的
+func (this *Checkpoint) WriteCheckpointFile(file string) error {
+	data, err := json.MarshalIndent(this, "", constvar.JSON_INDENT_SPACE)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	tmpFile := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	fh, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = fh.Write(data); err != nil {
+		fh.Close()
+		return errors.Trace(err)
+	}
+	if err = fh.Sync(); err != nil {
+		fh.Close()
+		return errors.Trace(err)
+	}
+	if err = fh.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmpFile, file))
+}
+
+// TrxPosTracker 在解析线程中跟踪当前事务的 GTID 和事务边界，以及已经完整解析的事务的 GTID 集合
+type TrxPosTracker struct {
+	gtidSet     mysql.GTIDSet
+	currentGtid string
+	inTrx       bool
+}
+
+// NewTrxPosTracker 初始的 GTID 集合：-resume 时取 checkpoint 中的集合，否则为 -start-gtid 之前的集合。
+// 这样 repl 模式下可以直接用这个集合 StartSyncGTID 。
+func NewTrxPosTracker(cfg *ConfCmd) *TrxPosTracker {
+	var (
+		gset mysql.GTIDSet
+		err  error
+	)
+	if cfg.ResumeCheckpoint != nil && cfg.ResumeCheckpoint.GtidSet != "" {
+		gset, err = mysql.ParseGTIDSet(cfg.MysqlType, cfg.ResumeCheckpoint.GtidSet)
+	} else if cfg.GtidFilter != nil && cfg.StartGtid != "" {
+		gset, err = cfg.GtidFilter.GetSyncGtidSet()
+	}
+	if err != nil {
+		log.Fatalf("fail to init gtid set of checkpoint %v", err)
+	}
+	if gset == nil {
+		gset, _ = mysql.ParseGTIDSet(cfg.MysqlType, "")
+	}
+	return &TrxPosTracker{gtidSet: gset}
+}
+
+// ObserveEvent 记录 GTID 事件中的 GTID ，返回事件是否结束了一个事务：COMMIT/XID ，或者事务之外单独的语句(DDL 隐式提交)。
+// 需要在事件被过滤之前调用，被 -include-gtids/-exclude-gtids 等过滤的事务同样是事务边界。
+func (this *TrxPosTracker) ObserveEvent(ev *replication.BinlogEvent) bool {
+	if gtid, ok := GetGtidOfEvent(ev); ok {
+		this.currentGtid = gtid
+	}
+
+	switch ev.Header.EventType {
+	case replication.MARIADB_GTID_EVENT:
+		// mariadb 没有 BEGIN ，DDL 等单独的语句有 FL_STANDALONE 标记
+		this.inTrx = !ev.Event.(*replication.MariadbGTIDEvent).IsStandalone()
+	case replication.QUERY_EVENT:
+		query := strings.ToUpper(strings.TrimSpace(string(ev.Event.(*replication.QueryEvent).Query)))
+		if query == "BEGIN" || strings.HasPrefix(query, "XA START") {
+			this.inTrx = true
+		} else if query == "COMMIT" || query == "ROLLBACK" || !this.inTrx {
+			this.inTrx = false
+			return true
+		}
+	case replication.XID_EVENT, replication.XA_PREPARE_LOG_EVENT:
+		this.inTrx = false
+		return true
+	}
+	return false
+}
+
+// GetGtidOfEvent 返回 GTID 事件中的 GTID ，ANONYMOUS_GTID_EVENT 返回空，不是 GTID 事件返回 false
+func GetGtidOfEvent(ev *replication.BinlogEvent) (string, bool) {
+	switch ev.Header.EventType {
+	case replication.GTID_EVENT:
+		gtidEvent := ev.Event.(*replication.GTIDEvent)
+		return GetMysqlGtidStr(gtidEvent.SID, gtidEvent.GNO), true
+	case replication.ANONYMOUS_GTID_EVENT:
+		return "", true
+	case replication.MARIADB_GTID_EVENT:
+		gtidEvent := ev.Event.(*replication.MariadbGTIDEvent)
+		return gtidEvent.GTID.String(), true
+	}
+	return "", false
+}
+
+// CommitTrx 当前事务解析完成，生成 checkpoint
+func (this *TrxPosTracker) CommitTrx(binlog string, pos uint32, timestamp uint32) *Checkpoint {
+	if this.currentGtid != "" {
+		if err := this.gtidSet.Update(this.currentGtid); err != nil {
+			log.Errorf("fail to add gtid %s into checkpoint %v", this.currentGtid, err)
+		}
+		this.currentGtid = ""
+	}
+	return &Checkpoint{
+		Binlog:    binlog,
+		Pos:       pos,
+		GtidSet:   this.gtidSet.String(),
+		Timestamp: timestamp,
+	}
+}
+
+// NeedTrxEndEvent 是否需要在事务结束时发送事务结束事件：跟踪 checkpoint 或者合并多行 insert
+func NeedTrxEndEvent(cfg *ConfCmd) bool {
+	return cfg.TrxTracker != nil || cfg.InsertRows > 1
+}
+
+// NewTrxEndEvent 事务结束事件，和 rows 事件一起按 EventIdx 顺序经过生成 sql 的线程，
+// 输出合并中的 insert ；设置了 -checkpoint-file 时带上 checkpoint ，写文件线程 flush 之后才真正写入 checkpoint 文件。
+func NewTrxEndEvent(cfg *ConfCmd, idx uint64, binlog string, pos uint32, timestamp uint32) *MyBinEvent {
+	ev := &MyBinEvent{
+		MyPos:     mysql.Position{Name: binlog, Pos: pos},
+		EventIdx:  idx,
+		Timestamp: timestamp,
+		TrxStatus: C_trxCommit,
+		TrxEnd:    true,
+	}
+	if cfg.TrxTracker != nil {
+		ev.Checkpoint = cfg.TrxTracker.CommitTrx(binlog, pos, timestamp)
+	}
+	return ev
+}
+
+// OpenSqlResultFile 打开 sql 结果文件。
+// 如果文件在 checkpoint 中，说明是 -resume 之前已经写过的文件，截断到 checkpoint 中的大小后追加写入。
+func OpenSqlResultFile(name string, fileSizes map[string]int64) (*os.File, error) {
+	size, ok := fileSizes[name]
+	if !ok {
+		fileSizes[name] = 0
+		return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	}
+
+	fh, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = fh.Truncate(size); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	if _, err = fh.Seek(size, os.SEEK_SET); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	log.Infof("resume writing %s from offset %d", name, size)
+	return fh, nil
+}
+
+// FlushSqlFilesAndWriteCheckpoint 先把 sql 写入文件，再写 checkpoint 文件
+func FlushSqlFilesAndWriteCheckpoint(file string, cp *Checkpoint, bufFHs map[string]*bufio.Writer) {
+	for fn, bufFH := range bufFHs {
+		if err := bufFH.Flush(); err != nil {
+			log.Errorf("fail to flush %s, checkpoint is not updated %v", fn, err)
+			return
+		}
+	}
+	if err := cp.WriteCheckpointFile(file); err != nil {
+		log.Errorf("fail to write checkpoint file %s %v", file, err)
+	}
+}
@@ -0,0 +1,81 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func newQueryEvent(query string) *replication.BinlogEvent {
+	return &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.QUERY_EVENT},
+		Event:  &replication.QueryEvent{Query: []byte(query)},
+	}
+}
+
+func TestTrxPosTrackerObserveEvent(t *testing.T) {
+	xid := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.XID_EVENT}}
+	rows := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.WRITE_ROWS_EVENTv2}}
+
+	cases := []struct {
+		name   string
+		flavor string
+		events []*replication.BinlogEvent
+		ends   []bool
+		gtid   string
+	}{
+		{
+			name:   "mysql transaction and ddl",
+			flavor: mysql.MySQLFlavor,
+			events: []*replication.BinlogEvent{
+				newGtidEvent(t, mysql.MySQLFlavor, testUuidA+":1"), newQueryEvent("BEGIN"), rows, xid,
+				newGtidEvent(t, mysql.MySQLFlavor, testUuidA+":2"), newQueryEvent("alter table t add c int"),
+				newGtidEvent(t, mysql.MySQLFlavor, testUuidA+":3"), newQueryEvent("BEGIN"), rows, newQueryEvent("COMMIT"),
+			},
+			ends: []bool{false, false, false, true, false, true, false, false, false, true},
+			gtid: testUuidA + ":1-3",
+		},
+		{
+			name:   "anonymous transaction and rollback",
+			flavor: mysql.MySQLFlavor,
+			events: []*replication.BinlogEvent{
+				newGtidEvent(t, mysql.MySQLFlavor, ""), newQueryEvent("BEGIN"), newQueryEvent("insert into t values (1)"), newQueryEvent("ROLLBACK"),
+				newQueryEvent("drop table t"),
+			},
+			ends: []bool{false, false, false, true, true},
+			gtid: "",
+		},
+		{
+			name:   "mariadb transaction and standalone ddl",
+			flavor: mysql.MariaDBFlavor,
+			events: []*replication.BinlogEvent{
+				newGtidEvent(t, mysql.MariaDBFlavor, "0-1-10"), rows, xid,
+				{
+					Header: &replication.EventHeader{EventType: replication.MARIADB_GTID_EVENT},
+					Event:  &replication.MariadbGTIDEvent{GTID: mysql.MariadbGTID{DomainID: 0, ServerID: 1, SequenceNumber: 11}, Flags: replication.BINLOG_MARIADB_FL_STANDALONE},
+				},
+				newQueryEvent("create table t2 (id int)"),
+			},
+			ends: []bool{false, false, true, false, true},
+			gtid: "0-1-11",
+		},
+	}
+
+	for _, c := range cases {
+		gset, _ := mysql.ParseGTIDSet(c.flavor, "")
+		tracker := &TrxPosTracker{gtidSet: gset}
+		for i, ev := range c.events {
+			end := tracker.ObserveEvent(ev)
+			if end != c.ends[i] {
+				t.Errorf("%s: event %d got trx end %v, want %v", c.name, i, end, c.ends[i])
+			}
+			if end {
+				tracker.CommitTrx("mysql-bin.000001", uint32(i), 0)
+			}
+		}
+		if got := tracker.gtidSet.String(); got != c.gtid {
+			t.Errorf("%s: got gtid set %s, want %s", c.name, got, c.gtid)
+		}
+	}
+}
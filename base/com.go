@@ -12,9 +12,10 @@ import (
 )
 
 type BinEventHandlingIndx struct {
-	EventIdx uint64
-	lock     sync.RWMutex
-	Finished bool
+	EventIdx      uint64
+	lock          sync.RWMutex
+	Finished      bool
+	pendingInsert *ForwardRollbackSqlOfPrint // 合并中的 insert ，-insert-rows 大于 1 时使用
 }
 
 var (
@@ -33,6 +34,10 @@ type MyBinEvent struct {
 	TrxStatus   int                    // 0:begin, 1: commit, 2: rollback, -1: in_progress
 	QuerySql    *dsql.SqlInfo          // for ddl and binlog which is not row format
 	OrgSql      string                 // for ddl and binlog which is not row format
+	RowsQuery   string                 // 产生该 rows 事件的原始语句，来自 ROWS_QUERY_EVENT/MARIADB_ANNOTATE_ROWS_EVENT
+	TableInfo   *TblInfoJson           // 读取该事件时的表结构，之后的 ddl 不会修改它
+	Checkpoint  *Checkpoint            // 事务结束事件的 checkpoint ，设置了 -checkpoint-file 时才有
+	TrxEnd      bool                   // 事务结束事件，不包含 rows
 }
 
 //
@@ -72,7 +77,7 @@ func (e *MyBinEvent) CheckBinEvent(cfg *ConfCmd, ev *replication.BinlogEvent, cu
 	// 过滤
 	if cfg.IfSetStartFilePos {
 		// 如果当前 myPos 小于 cfg.StartFilePos ，就 Continue 。
-		cmpRe := myPos.Compare(cfg.StartFilePos)
+		cmpRe := cfg.ComparePosition(myPos, cfg.StartFilePos)
 		if cmpRe == -1 {
 			return C_reContinue
 		}
@@ -80,7 +85,7 @@ func (e *MyBinEvent) CheckBinEvent(cfg *ConfCmd, ev *replication.BinlogEvent, cu
 	// 过滤
 	if cfg.IfSetStopFilePos {
 		// 如果当前 myPos 大于等于 cfg.StopFilePos ，就 Break 。
-		cmpRe := myPos.Compare(cfg.StopFilePos)
+		cmpRe := cfg.ComparePosition(myPos, cfg.StopFilePos)
 		if cmpRe >= 0 {
 			log.Infof("stop to get event. StopFilePos set. currentBinlog %s StopFilePos %s", myPos.String(), cfg.StopFilePos.String())
 			return C_reBreak
@@ -105,6 +110,15 @@ func (e *MyBinEvent) CheckBinEvent(cfg *ConfCmd, ev *replication.BinlogEvent, cu
 		}
 	}
 
+	// 按 GTID 过滤，以事务为粒度
+	if cfg.GtidFilter != nil {
+		chRe := cfg.GtidFilter.CheckBinEvent(ev)
+		if chRe != C_reProcess {
+			e.IfRowsEvent = false
+			return chRe
+		}
+	}
+
 	// 不过滤
 	if cfg.FilterSqlLen == 0 {
 		goto BinEventCheck
@@ -201,6 +215,27 @@ BinEventCheck:
 
 }
 
+// GetRowsQueryOfEvent 返回 ROWS_QUERY_EVENT(mysql binlog_rows_query_log_events=ON) 或者
+// MARIADB_ANNOTATE_ROWS_EVENT(mariadb binlog_annotate_row_events=ON) 中的原始语句，不是这两种事件返回 false
+func GetRowsQueryOfEvent(ev *replication.BinlogEvent) (string, bool) {
+	switch e := ev.Event.(type) {
+	case *replication.RowsQueryEvent:
+		return string(e.Query), true
+	case *replication.MariadbAnnotateRowsEvent:
+		return string(e.Query), true
+	}
+	return "", false
+}
+
+// TrackRowsQuery 原始语句之后的 rows 事件都是这条语句产生的，直到下一条原始语句或者事务结束，需要在事件被过滤之前调用
+func TrackRowsQuery(ev *replication.BinlogEvent, rowsQuery *string) {
+	if query, ok := GetRowsQueryOfEvent(ev); ok {
+		*rowsQuery = query
+	} else if ev.Header.EventType == replication.QUERY_EVENT || ev.Header.EventType == replication.XID_EVENT {
+		*rowsQuery = ""
+	}
+}
+
 func CheckBinHeaderCondition(cfg *ConfCmd, header *replication.EventHeader, currentBinlog string) int {
 	// process: 0, continue: 1, break: 2
 
@@ -210,7 +245,7 @@ func CheckBinHeaderCondition(cfg *ConfCmd, header *replication.EventHeader, curr
 
 	// 如果设置了解析的起始地址，且当前 pos 小于 start ，直接返回
 	if cfg.IfSetStartFilePos {
-		cmpRe := myPos.Compare(cfg.StartFilePos)
+		cmpRe := cfg.ComparePosition(myPos, cfg.StartFilePos)
 		if cmpRe == -1 {
 			return C_reContinue
 		}
@@ -218,7 +253,7 @@ func CheckBinHeaderCondition(cfg *ConfCmd, header *replication.EventHeader, curr
 
 	// 如果设置了解析的结束地址，且当前 pos 大于 end ，直接返回
 	if cfg.IfSetStopFilePos {
-		cmpRe := myPos.Compare(cfg.StopFilePos)
+		cmpRe := cfg.ComparePosition(myPos, cfg.StopFilePos)
 		if cmpRe >= 0 {
 			return C_reBreak
 		}
@@ -280,8 +315,16 @@ func GetFirstBinlogPosToParse(cfg *ConfCmd) (string, int64) {
 	var pos int64
 
 	// 如果指定了起始 binlog 文件
-	if cfg.StartFile != "" {
+	if cfg.StartFile != "" && cfg.BinlogFileList != nil {
+		binlog = cfg.BinlogFileList.Files[cfg.BinlogFileList.IndexOf(cfg.StartFile)]
+	} else if cfg.StartFile != "" {
 		binlog = filepath.Join(cfg.BinlogDir, cfg.StartFile)
+		// -resume 时 checkpoint 中的 binlog 可能已经被压缩归档了
+		if !toolkits.IsFile(binlog) {
+			if file := FindBinlogFile(cfg.BinlogDir, cfg.StartFile); file != "" {
+				binlog = file
+			}
+		}
 	} else {
 		binlog = cfg.GivenBinlogFile // 未指定，则读取指定 binlog 文件
 	}
@@ -0,0 +1,112 @@
+package base
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	toolkits "my2sql/toolkits"
+)
+
+const (
+	C_compressNone = ""
+	C_compressGzip = "gzip"
+	C_compressZstd = "zstd"
+	C_compressXz   = "xz"
+)
+
+var (
+	// 压缩后的 binlog 文件扩展名，如 mysql-bin.000001.gz
+	GBinlogCompressExts []string = []string{".gz", ".gzip", ".zst", ".zstd", ".xz"}
+
+	GBinlogCompressMagics map[string][]byte = map[string][]byte{
+		C_compressGzip: []byte{0x1f, 0x8b},
+		C_compressZstd: []byte{0x28, 0xb5, 0x2f, 0xfd},
+		C_compressXz:   []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00},
+	}
+)
+
+// GetCompressTypeByExt 按扩展名判断压缩格式
+func GetCompressTypeByExt(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".gz", ".gzip":
+		return C_compressGzip
+	case ".zst", ".zstd":
+		return C_compressZstd
+	case ".xz":
+		return C_compressXz
+	}
+	return C_compressNone
+}
+
+// GetBinlogName 去掉目录和压缩扩展名，mysql-bin.000001.gz => mysql-bin.000001
+func GetBinlogName(file string) string {
+	name := filepath.Base(file)
+	if GetCompressTypeByExt(name) != C_compressNone {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+// FindBinlogFile 在 dir 下查找 binlog 文件，可能是压缩过的，找不到返回空
+func FindBinlogFile(dir string, binlog string) string {
+	file := filepath.Join(dir, binlog)
+	if toolkits.IsFile(file) {
+		return file
+	}
+	for _, ext := range GBinlogCompressExts {
+		if toolkits.IsFile(file + ext) {
+			return file + ext
+		}
+	}
+	return ""
+}
+
+// NewBinlogFileReader 根据扩展名或者文件头判断压缩格式，返回解压后的 reader 以及压缩格式。
+// 返回的 close 函数用于释放解压使用的资源，不会关闭 f 。
+func NewBinlogFileReader(f *os.File) (io.Reader, string, func(), error) {
+	compressType := GetCompressTypeByExt(f.Name())
+
+	// 没有压缩扩展名时读取文件头判断，ReadAt 不会改变文件的读取位置
+	if compressType == C_compressNone {
+		head := make([]byte, 6)
+		n, err := f.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return nil, C_compressNone, nil, errors.Trace(err)
+		}
+		for tp, magic := range GBinlogCompressMagics {
+			if bytes.HasPrefix(head[:n], magic) {
+				compressType = tp
+				break
+			}
+		}
+	}
+
+	switch compressType {
+	case C_compressGzip:
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, compressType, nil, errors.Annotatef(err, "fail to open gzip file %s", f.Name())
+		}
+		return r, compressType, func() { r.Close() }, nil
+	case C_compressZstd:
+		r, err := zstd.NewReader(f)
+		if err != nil {
+			return nil, compressType, nil, errors.Annotatef(err, "fail to open zstd file %s", f.Name())
+		}
+		return r, compressType, r.Close, nil
+	case C_compressXz:
+		r, err := xz.NewReader(f)
+		if err != nil {
+			return nil, compressType, nil, errors.Annotatef(err, "fail to open xz file %s", f.Name())
+		}
+		return r, compressType, func() {}, nil
+	}
+	return f, C_compressNone, func() {}, nil
+}
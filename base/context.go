@@ -1,6 +1,7 @@
 package base
 
 import (
+	"crypto/tls"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -23,6 +24,15 @@ const (
 
 	EventTimeout = 5 * time.Second
 
+	CheckpointInterval = 1 * time.Second // 写 checkpoint 文件的最小间隔
+
+	C_insertModePlain   = "plain"   // INSERT INTO
+	C_insertModeReplace = "replace" // REPLACE INTO
+	C_insertModeIgnore  = "ignore"  // INSERT IGNORE INTO
+	C_insertModeUpsert  = "upsert"  // INSERT INTO ... ON DUPLICATE KEY UPDATE
+
+	C_defaultMaxStatementBytes = 1048576 // 和 mysqldump 的 net_buffer_length 上限一样，小于 max_allowed_packet 的默认值
+
 	C_unknownColPrefix   = "dropped_column_"
 	C_unknownColType     = "unknown_type"
 	C_unknownColTypeCode = mysql.MYSQL_TYPE_NULL
@@ -45,17 +55,20 @@ var (
 
 	GUseDatabase string = ""
 
-	GOptsValidMode      []string = []string{"repl", "file"}
+	GOptsValidMode      []string = []string{"repl", "file", "stdin"}
 	GOptsValidWorkType  []string = []string{"2sql", "rollback", "stats"}
 	GOptsValidMysqlType []string = []string{"mysql", "mariadb"}
 	GOptsValidFilterSql []string = []string{"insert", "update", "delete"}
 
+	GOptsValidInsertMode []string = []string{C_insertModePlain, C_insertModeReplace, C_insertModeIgnore, C_insertModeUpsert}
+
 	GOptsValueRange map[string][]int = map[string][]int{
 		"PrintInterval":  []int{1, 600, 30},
 		"BigTrxRowLimit": []int{1, 30000, 10},
 		"LongTrxSeconds": []int{0, 3600, 1},
-		"InsertRows":     []int{1, 500, 30},
+		"InsertRows":     []int{1, 500, 1},
 		"Threads":        []int{1, 16, 2},
+		"ParseThreads":   []int{1, 16, 1},
 	}
 
 	GStatsColumns []string = []string{
@@ -75,7 +88,7 @@ var (
 //	与 binlog2sql、MyFlash 差不多，my2sql 目前也不支持 8.0；闪回功能需要开启 binlog_format=row，binlog_row_image=full；只能闪回 DML 操作，不支持 DDL 的闪回。
 //	无法离线解析 binlog（MyFlash 支持）。
 //
-//	不能以 GTID 事务为单位进行解析（MyFlash 支持），具体 file+pos 点位需要先通过手工解析 binlog 后确认。
+//	可以通过 -start-gtid/-stop-gtid/-include-gtids/-exclude-gtids 以 GTID 事务为单位进行解析。
 //	闪回/前滚 SQL 中，没有提供具体的 begin/commit 的位置，使用时无法分隔事务，需要人工判断。
 //	使用事务分析功能时，只能给出具体的大/长事务发生时间、点位、涉及的对象和操作类型，不能给出具体的 SQL 语句，完整的语句仍然需要去 binlog 中进行查看（需设置 binlog_rows_query_log_events=on）
 //
@@ -98,6 +111,11 @@ var (
 // -start-pos：指定 binlog 文件中开始的点位
 // -start-datetime：指定开始的时间
 // -stop-datetime：指定结束的时间
+// -start-gtid：从指定 GTID 的事务开始解析，-stop-gtid：解析到指定 GTID 的事务为止
+// -include-gtids：只解析这些 GTID 的事务，-exclude-gtids：忽略这些 GTID 的事务
+// -binlog-index：按 mysql-bin.index/relay-log.index 解析 binlog 文件，-binlog-files：解析指定的 binlog 文件列表或者通配符
+// -follow：file 模式下持续读取正在写入的 binlog 文件
+// -checkpoint-file：记录已完整输出的最后一个事务的位置和 GTID 集合，-resume：从 checkpoint 继续解析
 // -output-dir：指定文件生成目录
 // -output-toScreen：指定输出到屏幕
 // -tl：指定时区（time location），默认为 local（Asia/Shanghai）
@@ -112,6 +130,7 @@ var (
 
 
 type ConfCmd struct {
+	Command   string // 子命令，为空时解析 binlog ，locate: 按时间查找 binlog 位置，dump-schema: 导出表结构
 	Mode      string
 	WorkType  string
 	MysqlType string
@@ -122,6 +141,19 @@ type ConfCmd struct {
 	Passwd   string
 	ServerId uint
 
+	PasswdFile    string
+	SslCa         string
+	SslCert       string
+	SslKey        string
+	SslServerName string
+	SslSkipVerify bool
+	TLSConfig     *tls.Config // 指定了 -ssl-* 参数时 repl 和查询表结构的连接使用 TLS
+
+	ReconnectRetries    int
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
+	HeartbeatPeriod     time.Duration
+
 	Databases    []string
 	Tables       []string
 	//DatabaseRegs []*regexp.Regexp
@@ -143,14 +175,32 @@ type ConfCmd struct {
 	StopFilePos      mysql.Position
 	IfSetStopFilePos bool
 
+	StartGtid    string
+	StopGtid     string
+	IncludeGtids string
+	ExcludeGtids string
+	GtidFilter   *GtidFilter
+
+	CheckpointFile   string
+	Resume           bool
+	ResumeCheckpoint *Checkpoint    // -resume 时读取到的 checkpoint
+	TrxTracker       *TrxPosTracker // 设置了 -checkpoint-file 时跟踪事务的结束位置
+
 	StartDatetime      uint32
 	StopDatetime       uint32
+	LocateDatetime     uint32 // my2sql locate -datetime
 	BinlogTimeLocation string
 
 	IfSetStartDateTime bool
 	IfSetStopDateTime  bool
 
-	LocalBinFile string
+	LocalBinFile   string
+	BinlogIndex    string
+	BinlogFiles    string
+	BinlogFileList *BinlogFileList // 指定了 -binlog-index/-binlog-files 时要解析的文件列表
+	Follow         bool
+	OnCorrupt      string
+	BinlogName     string // -mode=stdin 时读取的 binlog 文件名
 
 	OutputToScreen bool
 	PrintInterval  int
@@ -163,18 +213,23 @@ type ConfCmd struct {
 
 	//MinColumns     bool
 	FullColumns    bool
-	InsertRows     int
+	InsertRows        int // 一条 insert 语句最多包含的行数
+	MaxStatementBytes int // 合并的多行 insert 语句的最大字节数
 	KeepTrx        bool
 	SqlTblPrefixDb bool	// ???
 	FilePerTable   bool
 
 	PrintExtraInfo bool
+	EnumSetBitAsNumber bool // enum/set/bit 列输出为数字(enum 的序号、set 的位图)，默认 enum/set 输出为字符串，bit 输出为 b'..'
+	JsonCast       bool   // json 列的值输出为 CAST('..' AS JSON)
+	SqlCharset     string // 生成的 sql 的字符集，即执行 sql 时连接的字符集，字符串列的值转换为这个字符集
 
-	Threads uint
+	Threads      uint
+	ParseThreads int
 
-	ReadTblDefJsonFile string
-	OnlyColFromFile    bool
-	DumpTblDefToFile   string
+	ReadTblDefJsonFile string // -table-def-file ，从该文件读取表结构
+	OnlyColFromFile    bool   // 表结构只从 -table-def-file 读取，不查询 mysql
+	DumpTblDefToFile   string // my2sql dump-schema -table-def-file ，表结构导出到该文件
 
 	BinlogDir string
 
@@ -182,7 +237,8 @@ type ConfCmd struct {
 
 	UseUniqueKeyFirst         bool
 	IgnorePrimaryKeyForInsert bool
-	ReplaceIntoForInsert      bool
+	InsertMode                string // 生成的 insert 语句遇到重复的主键、唯一键时的处理方式，见 GOptsValidInsertMode
+	UpdateAsUpsert            bool   // update 转换为用完整的 after 镜像执行的 INSERT ... ON DUPLICATE KEY UPDATE
 
 	//DdlRegexp string
 	ParseStatementSql bool
@@ -199,6 +255,7 @@ type ConfCmd struct {
 	//DdlFH     *os.File
 	BiglongFH *os.File
 
+	BinlogSyncer   *replication.BinlogSyncer
 	BinlogStreamer *replication.BinlogStreamer
 	FromDB         *sql.DB
 }
@@ -214,6 +271,8 @@ func (this *ConfCmd) ParseCmdOptions() {
 		sqlTypes         string
 		startTime        string
 		stopTime         string
+		locateTime       string
+		tableDefFile     string
 		err              error
 		doNotAddPrifixDb bool
 	)
@@ -223,7 +282,7 @@ func (this *ConfCmd) ParseCmdOptions() {
 	}
 
 	flag.BoolVar(&version, "v", false, "print version")
-	flag.StringVar(&this.Mode, "mode", "repl", StrSliceToString(GOptsValidMode, C_joinSepComma, C_validOptMsg)+". repl: as a slave to get binlogs from master. file: get binlogs from local filesystem. default repl")
+	flag.StringVar(&this.Mode, "mode", "repl", StrSliceToString(GOptsValidMode, C_joinSepComma, C_validOptMsg)+". repl: as a slave to get binlogs from master. file: get binlogs from local filesystem. stdin: read one binlog stream from stdin or a pipe. default repl")
 	flag.StringVar(&this.WorkType, "work-type", "2sql", StrSliceToString(GOptsValidWorkType, C_joinSepComma, C_validOptMsg)+". 2sql: convert binlog to sqls, rollback: generate rollback sqls, stats: analyze transactions. default: 2sql")
 	flag.StringVar(&this.MysqlType, "mysql-type", "mysql", StrSliceToString(GOptsValidMysqlType, C_joinSepComma, C_validOptMsg)+". server of binlog, mysql or mariadb, default mysql")
 
@@ -231,7 +290,17 @@ func (this *ConfCmd) ParseCmdOptions() {
 	flag.UintVar(&this.Port, "port",3306, "mysql port, default 3306.")
 	flag.StringVar(&this.User, "user", "", "mysql user. ")
 	flag.StringVar(&this.Passwd, "password", "", "mysql user password.")
+	flag.StringVar(&this.PasswdFile, "password-file", "", "read mysql user password from this file instead of -password. if neither is set, use environment variable "+C_passwordEnv)
+	flag.StringVar(&this.SslCa, "ssl-ca", "", "connect to mysql with TLS. CA certificate file in PEM format to verify mysql server certificate, default system CAs")
+	flag.StringVar(&this.SslCert, "ssl-cert", "", "connect to mysql with TLS. client certificate file in PEM format, works with -ssl-key")
+	flag.StringVar(&this.SslKey, "ssl-key", "", "connect to mysql with TLS. client private key file in PEM format, works with -ssl-cert")
+	flag.StringVar(&this.SslServerName, "ssl-server-name", "", "connect to mysql with TLS. server name to verify mysql server certificate, default -host")
+	flag.BoolVar(&this.SslSkipVerify, "ssl-skip-verify", false, "connect to mysql with TLS, but do not verify mysql server certificate")
 	flag.UintVar(&this.ServerId, "server-id", 1113306, "this program replicates from mysql as slave to read binlogs. Must set this server id unique from other slaves, default 1113306")
+	flag.IntVar(&this.ReconnectRetries, "reconnect-retries", 10, "Works with -mode=repl. max times to reconnect to mysql when the binlog stream is broken, resume from the last fully parsed transaction. 0: exit when disconnected, -1: retry forever. default 10")
+	flag.DurationVar(&this.ReconnectBackoff, "reconnect-backoff", time.Second, "Works with -mode=repl. wait time before the first reconnection, doubled after each failed reconnection. default 1s")
+	flag.DurationVar(&this.ReconnectMaxBackoff, "reconnect-max-backoff", time.Minute, "Works with -mode=repl. max wait time between reconnections. default 60s")
+	flag.DurationVar(&this.HeartbeatPeriod, "heartbeat-period", 10*time.Second, "Works with -mode=repl. ask mysql to send heartbeat in this period when there is no binlog event, reconnect if nothing received in 3 periods. 0 to disable. default 10s")
 
 	flag.StringVar(&dbs, "databases", "", "only parse these databases, comma seperated, default all.")
 	flag.StringVar(&tbs, "tables", "", "only parse these tables, comma seperated, DONOT prefix with schema, default all.")
@@ -240,14 +309,31 @@ func (this *ConfCmd) ParseCmdOptions() {
 	flag.StringVar(&sqlTypes, "sql", "", StrSliceToString(GOptsValidFilterSql, C_joinSepComma, C_validOptMsg)+". only parse these types of sql, comma seperated, valid types are: insert, update, delete; default is all(insert,update,delete)")
 	flag.BoolVar(&this.IgnorePrimaryKeyForInsert, "ignore-primaryKey-forInsert", false, "for insert statement when -workType=2sql, ignore primary key")
 
+	flag.StringVar(&this.InsertMode, "insert-mode", C_insertModePlain, StrSliceToString(GOptsValidInsertMode, C_joinSepComma, C_validOptMsg)+". Works with -work-type=2sql|rollback. how generated inserts(also the rollback of deletes) handle rows that already exist, so that the sqls can be executed again after partial failures. plain: INSERT INTO. replace: REPLACE INTO. ignore: INSERT IGNORE INTO. upsert: INSERT INTO ... ON DUPLICATE KEY UPDATE all columns. default plain")
+	flag.BoolVar(&this.UpdateAsUpsert, "update-as-upsert", false, "Works with -work-type=2sql|rollback. generate INSERT INTO ... ON DUPLICATE KEY UPDATE with the full after image for updates instead of UPDATE, rows whose primary/unique key changed are deleted by the old key first. tables without primary/unique key still use UPDATE. default false")
+
 	flag.StringVar(&this.StartFile, "start-file", "", "binlog file to start reading")
 	flag.UintVar(&this.StartPos, "start-pos", 4, "start reading the binlog at position")
 	flag.StringVar(&this.StopFile, "stop-file", "", "binlog file to stop reading")
 	flag.UintVar(&this.StopPos, "stop-pos", 4, "Stop reading the binlog at position")
 	flag.StringVar(&this.LocalBinFile, "local-binlog-file", "", "local binlog files to process, It works with -mode=file ")
+	flag.StringVar(&this.BinlogIndex, "binlog-index", "", "Works with -mode=file. read binlog files to process from mysql-bin.index or relay-log.index, parse them in index order")
+	flag.StringVar(&this.BinlogFiles, "binlog-files", "", "Works with -mode=file. binlog files to process, comma seperated file names or globs, such as /data/binlog/mysql-bin.*, parse them in binlog sequence order")
+	flag.BoolVar(&this.Follow, "follow", false, "Works with -mode=file. keep reading the active binlog as mysql appends to it, and switch to the next binlog when it appears. stop by SIGINT/SIGTERM")
+	flag.StringVar(&this.BinlogName, "binlog-name", "", "Works with -mode=stdin. binlog file name of the stream, such as mysql-bin.000123. if not set, take it from the ROTATE event at the beginning of the stream")
+	flag.StringVar(&this.OnCorrupt, "on-corrupt", C_onCorruptAbort, StrSliceToString(GOptsValidOnCorrupt, C_joinSepComma, C_validOptMsg)+". Works with -mode=file|stdin. what to do with damaged events(checksum mismatch, invalid header, truncated). abort: stop parsing. skip: skip damaged bytes and continue from the next valid event. report: only check binlog, no sql or stats generated. damaged byte ranges are written into "+CorruptReportFile+" for skip and report. default abort")
+
+	flag.StringVar(&this.StartGtid, "start-gtid", "", "start reading the binlog at the transaction of this gtid(included), mysql: uuid:N, mariadb: domain-server-N. transactions of uuids(domains) not in it are all parsed, in repl mode only those after gtid_executed of the master")
+	flag.StringVar(&this.StopGtid, "stop-gtid", "", "stop reading the binlog after the transaction of this gtid(included), mysql: uuid:N, mariadb: domain-server-N")
+	flag.StringVar(&this.IncludeGtids, "include-gtids", "", "only parse transactions of these gtids, mysql: uuid:1-10,uuid2:5, mariadb: 0-1-100,0-1-105")
+	flag.StringVar(&this.ExcludeGtids, "exclude-gtids", "", "ignore transactions of these gtids, mysql: uuid:1-10,uuid2:5, mariadb: 0-1-100,0-1-105")
+
+	flag.StringVar(&this.CheckpointFile, "checkpoint-file", "", "Works with -work-type=2sql. record binlog position and gtid set of the last transaction whose sqls are fully written into files")
+	flag.BoolVar(&this.Resume, "resume", false, "Works with -checkpoint-file. continue parsing from the position recorded in checkpoint file, sql files are truncated to the recorded size and appended. binlog_status.txt and biglong_trx.txt are only appended, not truncated, so events between the checkpoint and the interruption may be counted twice, their stats are not exact after a resume")
 
 	flag.StringVar(&this.BinlogTimeLocation, "tl", "Local", "time location to parse timestamp/datetime column in binlog, such as Asia/Shanghai. default Local")
 	flag.StringVar(&startTime, "start-datetime", "", "Start reading the binlog at first event having a datetime equal or posterior to the argument, it should be like this: \"2020-01-01 01:00:00\"")
+	flag.StringVar(&locateTime, "datetime", "", "Works with command locate, such as my2sql locate -datetime \"2020-01-01 01:00:00\" -mode repl|file ... , print binlog position and gtid of the first transaction having a datetime equal or posterior to the argument")
 	flag.StringVar(&stopTime, "stop-datetime", "", "Stop reading the binlog at first event having a datetime equal or posterior to the argument, it should be like this: \"2020-12-30 01:00:00\"")
 
 	flag.BoolVar(&this.OutputToScreen, "output-toScreen", false, "Just output to screen,do not write to file")
@@ -257,23 +343,53 @@ func (this *ConfCmd) ParseCmdOptions() {
 	flag.BoolVar(&doNotAddPrifixDb, "do-not-add-prifixDb", false, "Prefix table name witch database name in sql,ex: insert into db1.tb1 (x1, x1) values (y1, y1). ")
 	flag.BoolVar(&this.UseUniqueKeyFirst, "U", false, "prefer to use unique key instead of primary key to build where condition for delete/update sql")
 
+	flag.BoolVar(&this.EnumSetBitAsNumber, "enum-set-bit-as-number", false, "Works with -work-type=2sql|rollback. output enum/set/bit columns as numbers(index of enum, bitmap of set) as stored in binlog. default false, that is, enum/set as their string values from table struct, bit as b'101'")
+	flag.BoolVar(&this.JsonCast, "json-cast", false, "Works with -work-type=2sql|rollback. output json column values as CAST('..' AS JSON) instead of plain strings, so that they compare as json in where conditions. default false")
+	flag.StringVar(&this.SqlCharset, "sql-charset", C_charsetUtf8mb4, StrSliceToString(GOptsValidSqlCharset, C_joinSepComma, C_validOptMsg)+". Works with -work-type=2sql|rollback. charset of generated sqls, execute them with this connection charset(e.g. mysql --default-character-set=utf8mb4). string values are converted from the column charset(latin1, gbk...) into it, values that can not be converted losslessly are written as _latin1 X'..'. default "+C_charsetUtf8mb4)
+	flag.IntVar(&this.InsertRows, "insert-rows", this.GetDefaultValueOfRange("InsertRows"), "Works with -work-type=2sql|rollback. merge consecutive inserts into the same table within a transaction into multi-row insert sqls with at most this many rows, also for the rollback of deletes. "+this.GetDefaultAndRangeValueMsg("InsertRows"))
+	flag.IntVar(&this.MaxStatementBytes, "max-statement-bytes", C_defaultMaxStatementBytes, "Works with -insert-rows. max bytes of a multi-row insert sql, it should be less than max_allowed_packet of the mysql executing the sqls. a single row longer than it is still one sql. "+fmt.Sprintf("default %d", C_defaultMaxStatementBytes))
 	flag.StringVar(&this.OutputDir, "output-dir", "", "result output dir, default current work dir. Attension, result files could be large, set it to a dir with large free space")
 	flag.BoolVar(&this.FilePerTable, "file-per-table", false, "One file for one table if true, else one file for all tables. default false. Attention, always one file for one binlog")
 	flag.IntVar(&this.PrintInterval, "print-interval", this.GetDefaultValueOfRange("PrintInterval"), "works with -w='stats', print stats info each PrintInterval. "+this.GetDefaultAndRangeValueMsg("PrintInterval"))
 	flag.IntVar(&this.BigTrxRowLimit, "big-trx-row-limit", this.GetDefaultValueOfRange("BigTrxRowLimit"), "transaction with affected rows greater or equal to this value is considerated as big transaction. "+this.GetDefaultAndRangeValueMsg("BigTrxRowLimit"))
 	flag.IntVar(&this.LongTrxSeconds, "long-trx-seconds", this.GetDefaultValueOfRange("LongTrxSeconds"), "transaction with duration greater or equal to this value is considerated as long transaction. "+this.GetDefaultAndRangeValueMsg("LongTrxSeconds"))
 
+	flag.StringVar(&tableDefFile, "table-def-file", "", "read table definitions(columns, primary/unique keys) from this json file instead of querying mysql, so -mode=file|stdin needs no database. the file is created by my2sql dump-schema -table-def-file ... , tables not in the file are still queried from mysql unless -only-table-def-file. a .sql file(e.g. mysqldump --no-data) or a directory of .sql migration files is also accepted, its CREATE/ALTER TABLE statements are applied in order")
+	flag.BoolVar(&this.OnlyColFromFile, "only-table-def-file", false, "Works with -table-def-file. never connect to mysql, tables not in -table-def-file are reported as not found")
 	flag.UintVar(&this.Threads, "threads", uint(this.GetDefaultValueOfRange("Threads")), "Works with -workType=2sql|rollback. threads to run")
+	flag.IntVar(&this.ParseThreads, "parse-threads", this.GetDefaultValueOfRange("ParseThreads"), "Works with -mode=file. parse this many binlog files concurrently, the results are output in binlog order, same as parsing one by one. "+this.GetDefaultAndRangeValueMsg("ParseThreads"))
 
-	flag.Parse()
+	// 子命令放在参数的最前面，如 my2sql locate -datetime ... ，其它参数和解析 binlog 时一样
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == C_cmdLocate || args[0] == C_cmdDumpSchema) {
+		this.Command = args[0]
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
 
 	if version {
 		fmt.Printf("%s\n", C_Version)
 		os.Exit(0)
 	}
 
-	if this.Mode != "repl" && this.Mode != "file" {
-		log.Fatalf("unsupported mode=%s, valid modes: file, repl", this.Mode)
+	if err = this.LoadPassword(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if this.TLSConfig, err = NewTLSConfig(this); err != nil {
+		log.Fatalf("invalid tls options %v", err)
+	}
+
+	if this.Mode != "repl" && this.Mode != "file" && this.Mode != "stdin" {
+		log.Fatalf("unsupported mode=%s, valid modes: file, repl, stdin", this.Mode)
+	}
+
+	this.SqlCharset = NormalizeCharset(this.SqlCharset)
+	if !CheckElementOfSliceStr(GOptsValidSqlCharset, this.SqlCharset, "invalid arg for -sql-charset", false) {
+		log.Fatalf("invalid arg for -sql-charset: %s, %s", this.SqlCharset, StrSliceToString(GOptsValidSqlCharset, C_joinSepComma, C_validOptMsg))
+	}
+
+	if !CheckElementOfSliceStr(GOptsValidInsertMode, this.InsertMode, "invalid arg for -insert-mode", false) {
+		log.Fatalf("invalid arg for -insert-mode: %s, %s", this.InsertMode, StrSliceToString(GOptsValidInsertMode, C_joinSepComma, C_validOptMsg))
 	}
 
 	// check --output-dir
@@ -347,6 +463,17 @@ func (this *ConfCmd) ParseCmdOptions() {
 		this.IfSetStopDateTime = false
 	}
 
+	if this.Command == C_cmdLocate {
+		if locateTime == "" {
+			log.Fatalf("-datetime must be specified for command %s", C_cmdLocate)
+		}
+		t, err := time.ParseInLocation(constvar.DATETIME_FORMAT, locateTime, GBinlogTimeLocation)
+		if err != nil {
+			log.Fatalf("invalid datetime -datetime " + locateTime)
+		}
+		this.LocateDatetime = uint32(t.Unix())
+	}
+
 	if startTime != "" && stopTime != "" {
 		if this.StartDatetime >= this.StopDatetime {
 			log.Fatalf("-start-datetime must be ealier than -stop-datetime")
@@ -355,7 +482,7 @@ func (this *ConfCmd) ParseCmdOptions() {
 
 	if this.StartFile != "" {
 		this.IfSetStartFilePos = true
-		this.StartFilePos = mysql.Position{Name: this.StartFile, Pos: uint32(this.StartPos)}
+		this.StartFilePos = mysql.Position{Name: GetBinlogName(this.StartFile), Pos: uint32(this.StartPos)}
 
 	} else {
 		this.IfSetStartFilePos = false
@@ -363,7 +490,7 @@ func (this *ConfCmd) ParseCmdOptions() {
 
 	if this.StopFile != "" {
 		this.IfSetStopFilePos = true
-		this.StopFilePos = mysql.Position{Name: this.StopFile, Pos: uint32(this.StopPos)}
+		this.StopFilePos = mysql.Position{Name: GetBinlogName(this.StopFile), Pos: uint32(this.StopPos)}
 		this.IfSetStopParsPoint = true
 
 	} else {
@@ -372,7 +499,38 @@ func (this *ConfCmd) ParseCmdOptions() {
 	}
 
 
-	if this.Mode == "file" {
+	if this.StartGtid != "" || this.StopGtid != "" || this.IncludeGtids != "" || this.ExcludeGtids != "" {
+		this.GtidFilter, err = NewGtidFilter(this.MysqlType, this.StartGtid, this.StopGtid, this.IncludeGtids, this.ExcludeGtids)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		// 越过 -stop-gtid 才结束，需要继续解析后面的 binlog 文件
+		if this.GtidFilter.IfSetStopGtid() {
+			this.IfSetStopParsPoint = true
+		}
+	}
+
+	if this.BinlogIndex != "" || this.BinlogFiles != "" {
+		if this.Mode != "file" {
+			log.Fatalf("-binlog-index and -binlog-files only work with -mode=file")
+		}
+		if this.BinlogIndex != "" && this.BinlogFiles != "" {
+			log.Fatalf("-binlog-index and -binlog-files can not be specified at the same time")
+		}
+		this.BinlogFileList, err = NewBinlogFileList(this.BinlogIndex, this.BinlogFiles)
+		if err != nil {
+			log.Fatalf("fail to get binlog files %v", err)
+		}
+		// 没有指定 -start-file 时从第一个文件开始解析
+		this.GivenBinlogFile = this.BinlogFileList.Files[0]
+		if this.StartFile != "" && this.BinlogFileList.IndexOf(this.StartFile) < 0 {
+			log.Fatalf("-start-file %s not found in binlog files", this.StartFile)
+		}
+		if this.StopFile != "" && this.BinlogFileList.IndexOf(this.StopFile) < 0 && !this.Follow {
+			log.Fatalf("-stop-file %s not found in binlog files", this.StopFile)
+		}
+		this.BinlogDir = filepath.Dir(this.GivenBinlogFile)
+	} else if this.Mode == "file" {
 
 		if this.StartFile == "" {
 			log.Fatalf("missing binlog file.  -start-file must be specify when -mode=file ")
@@ -385,7 +543,7 @@ func (this *ConfCmd) ParseCmdOptions() {
 		}
 	}
 
-	if this.Mode == "file" {
+	if this.Mode == "file" && this.BinlogFileList == nil {
 	        if this.LocalBinFile == "" {
 	                log.Fatalf("missing binlog file.  -local-binlog-file must be specify when -mode=file ")
 	        }
@@ -397,7 +555,116 @@ func (this *ConfCmd) ParseCmdOptions() {
 	        }
 	}
 
-	
+	if this.Follow && this.Mode != "file" {
+		log.Fatalf("-follow only works with -mode=file")
+	}
+
+	// my2sql dump-schema 只需要连接参数，-table-def-file 是导出的文件
+	if this.Command == C_cmdDumpSchema {
+		if tableDefFile == "" {
+			log.Fatalf("-table-def-file must be specified for command %s", C_cmdDumpSchema)
+		}
+		if IsSqlTableDefPath(tableDefFile) {
+			log.Fatalf("command %s writes json, -table-def-file %s must not be a .sql file or a directory", C_cmdDumpSchema, tableDefFile)
+		}
+		this.DumpTblDefToFile = tableDefFile
+		this.CreateDB()
+		return
+	}
+
+	// my2sql locate 只需要连接参数和 binlog 文件，不生成结果文件
+	if this.Command == C_cmdLocate {
+		if this.Mode != "repl" && this.Mode != "file" {
+			log.Fatalf("command %s only works with -mode=repl|file", C_cmdLocate)
+		}
+		if this.StartFile != "" {
+			this.StartFile = filepath.Base(this.StartFile)
+		}
+		if this.Mode == "repl" {
+			this.CreateDB()
+		}
+		return
+	}
+
+	if tableDefFile != "" {
+		this.ReadTblDefJsonFile = tableDefFile
+		if IsSqlTableDefPath(tableDefFile) {
+			err = G_TablesColumnsInfo.LoadTableDefsFromSql(tableDefFile)
+		} else {
+			err = G_TablesColumnsInfo.LoadTableDefsFromFile(tableDefFile)
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	} else if this.OnlyColFromFile {
+		log.Fatalf("-only-table-def-file must work with -table-def-file")
+	}
+
+	// 并行解析时各个文件独立解析，不能跨文件跟踪 GTID 和事务位置
+	if this.ParseThreads > 1 {
+		if this.Mode != "file" {
+			log.Fatalf("-parse-threads only works with -mode=file")
+		}
+		if this.Follow || this.CheckpointFile != "" || this.GtidFilter != nil {
+			log.Fatalf("-parse-threads > 1 does not work with -follow, -checkpoint-file and gtid options")
+		}
+	}
+
+	if this.ReconnectRetries < -1 {
+		log.Fatalf("invalid arg for -reconnect-retries %d, must be >= -1", this.ReconnectRetries)
+	}
+	if this.ReconnectBackoff <= 0 || this.ReconnectMaxBackoff < this.ReconnectBackoff {
+		log.Fatalf("invalid arg for -reconnect-backoff %v and -reconnect-max-backoff %v, must be 0 < -reconnect-backoff <= -reconnect-max-backoff", this.ReconnectBackoff, this.ReconnectMaxBackoff)
+	}
+	if this.HeartbeatPeriod < 0 {
+		log.Fatalf("invalid arg for -heartbeat-period %v", this.HeartbeatPeriod)
+	}
+
+	CheckElementOfSliceStr(GOptsValidOnCorrupt, this.OnCorrupt, "invalid arg for -on-corrupt", true)
+	if this.OnCorrupt != C_onCorruptAbort && this.Mode == "repl" {
+		log.Fatalf("-on-corrupt=%s only works with -mode=file|stdin", this.OnCorrupt)
+	}
+
+	if this.BinlogName != "" {
+		if this.Mode != "stdin" {
+			log.Fatalf("-binlog-name only works with -mode=stdin")
+		}
+		this.BinlogName = GetBinlogName(this.BinlogName)
+	}
+
+	if this.Resume && this.CheckpointFile == "" {
+		log.Fatalf("-resume must work with -checkpoint-file")
+	}
+
+	if this.CheckpointFile != "" {
+		if this.WorkType != "2sql" {
+			log.Fatalf("-checkpoint-file only works with -work-type=2sql")
+		}
+		if this.OutputToScreen {
+			log.Fatalf("-checkpoint-file does not work with -output-toScreen")
+		}
+		if this.Resume {
+			if toolkits.IsFile(this.CheckpointFile) {
+				this.ResumeCheckpoint, err = ReadCheckpointFile(this.CheckpointFile)
+				if err != nil {
+					log.Fatalf("fail to read checkpoint file %v", err)
+				}
+				// 从 checkpoint 中的位置继续解析
+				if this.BinlogFileList != nil && this.BinlogFileList.IndexOf(this.ResumeCheckpoint.Binlog) < 0 {
+					log.Fatalf("%s of checkpoint not found in binlog files", this.ResumeCheckpoint.Binlog)
+				}
+				this.StartFile = this.ResumeCheckpoint.Binlog
+				this.StartPos = uint(this.ResumeCheckpoint.Pos)
+				this.IfSetStartFilePos = true
+				this.StartFilePos = mysql.Position{Name: this.StartFile, Pos: uint32(this.StartPos)}
+				log.Infof("resume from checkpoint %s gtid set [%s]", this.StartFilePos.String(), this.ResumeCheckpoint.GtidSet)
+			} else {
+				log.Warnf("checkpoint file %s not exists, start from the beginning", this.CheckpointFile)
+			}
+		}
+		this.TrxTracker = NewTrxPosTracker(this)
+	}
+
 	this.EventChan = make(chan MyBinEvent, this.Threads*2)
 	this.StatChan = make(chan BinEventStats, this.Threads*2)
 	this.SqlChan = make(chan ForwardRollbackSqlOfPrint, this.Threads*2)
@@ -409,7 +676,28 @@ func (this *ConfCmd) ParseCmdOptions() {
 
 
 	this.CheckCmdOptions()
-	this.CreateDB()	
+	// repl 模式下需要查询主库，其它模式下只用来查询表结构，指定了 -table-def-file 时在需要时才连接
+	if this.Mode == "repl" || this.ReadTblDefJsonFile == "" {
+		this.CreateDB()
+	}
+	// 基于 GTID 从主库拉取时，-start-gtid 中没有的 uuid(domain) 从主库已执行的 GTID 之后开始
+	if this.Mode == "repl" && this.GtidFilter != nil && this.StartGtid != "" && this.ResumeCheckpoint == nil {
+		if err = this.GtidFilter.LoadServerGtidSet(this.FromDB); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	// 一次性加载需要解析的所有表的表结构，之后不在缓存中的表再逐个查询
+	if this.FromDB != nil && this.WorkType != "stats" && !this.OnlyColFromFile {
+		if err = G_TablesColumnsInfo.PreloadTableDefs(this); err != nil {
+			log.Warnf("fail to preload table definitions, query them one by one when needed: %v", err)
+		}
+	}
+
+	// 指定了 -start-datetime 时先找到该时间所在的 binlog 文件，-resume 和基于 GTID 拉取时不需要
+	if this.IfSetStartDateTime && this.ResumeCheckpoint == nil && (this.Mode == "repl" || this.Mode == "file") &&
+		(this.GtidFilter == nil || this.StartGtid == "") {
+		this.LocateStartFileByDatetime()
+	}
 
 }
 
@@ -439,7 +727,7 @@ func (this *ConfCmd) CheckCmdOptions() {
 
 	//check --start-binlog --start-pos --stop-binlog --stop-pos
 	if this.StartFile != "" && this.StartPos != 0 && this.StopFile != "" && this.StopPos != 0 {
-		cmpRes := CompareBinlogPos(this.StartFile, this.StartPos, this.StopFile, this.StopPos)
+		cmpRes := CompareBinlogPos(GetBinlogName(this.StartFile), this.StartPos, GetBinlogName(this.StopFile), this.StopPos)
 		if cmpRes != -1 {
 			log.Fatalf("start postion(-start-file -start-pos) must less than stop position(-end-file -end-pos)")
 		}
@@ -450,6 +738,19 @@ func (this *ConfCmd) CheckCmdOptions() {
 		this.CheckValueInRange("Threads", int(this.Threads), "value of -threads out of range", true)
 	}
 
+	// check --insert-rows
+	if this.InsertRows != this.GetDefaultValueOfRange("InsertRows") {
+		this.CheckValueInRange("InsertRows", this.InsertRows, "value of -insert-rows out of range", true)
+	}
+	if this.MaxStatementBytes <= 0 {
+		log.Fatalf("invalid arg for -max-statement-bytes %d, must be > 0", this.MaxStatementBytes)
+	}
+
+	// check --parse-threads
+	if this.ParseThreads != this.GetDefaultValueOfRange("ParseThreads") {
+		this.CheckValueInRange("ParseThreads", this.ParseThreads, "value of -parse-threads out of range", true)
+	}
+
 	// check --interval
 	if this.PrintInterval != this.GetDefaultValueOfRange("PrintInterval") {
 		this.CheckValueInRange("PrintInterval", this.PrintInterval, "value of -i out of range", true)
@@ -563,6 +864,22 @@ func (this *ConfCmd) GetDefaultAndRangeValueMsg(opt string) string {
 
 }*/
 
+// ComparePosition 比较两个 binlog 位置。
+// 指定了 -binlog-index/-binlog-files 时按文件在列表中的顺序比较，relay log 或者中途修改过 log-bin 文件名时也能正确比较。
+func (this *ConfCmd) ComparePosition(a mysql.Position, b mysql.Position) int {
+	if this.BinlogFileList != nil {
+		aIdx := this.BinlogFileList.IndexOf(a.Name)
+		bIdx := this.BinlogFileList.IndexOf(b.Name)
+		if aIdx >= 0 && bIdx >= 0 && aIdx != bIdx {
+			if aIdx < bIdx {
+				return -1
+			}
+			return 1
+		}
+	}
+	return a.Compare(b)
+}
+
 func (this *ConfCmd) IsTargetDml(dml string) bool {
 	if this.FilterSqlLen < 1 {
 		return true
@@ -577,25 +894,43 @@ func (this *ConfCmd) IsTargetDml(dml string) bool {
 // OpenStatsResultFiles 保存 binlog 的统计信息。
 func (this *ConfCmd) OpenStatsResultFiles() {
 	statFile := filepath.Join(this.OutputDir, "binlog_status.txt")
-	statFH, err := os.OpenFile(statFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	statFH, err := os.OpenFile(statFile, this.GetResultFileOpenFlag(), 0644)
 	if err != nil {
 		log.Fatalf("fail to open file %v"+statFile, err)
 	}
 	// 写入头部：[binlog, starttime, stoptime, startpos, stoppos, inserts, updates, deletes, database, table]
-	statFH.WriteString(GetStatsPrintHeaderLine(Stats_Result_Header_Column_names))
+	if this.ResumeCheckpoint == nil {
+		statFH.WriteString(GetStatsPrintHeaderLine(Stats_Result_Header_Column_names))
+	}
 	this.StatFH = statFH
 }
 
 func (this *ConfCmd) OpenTxResultFiles() {
 	biglongFile := filepath.Join(this.OutputDir, "biglong_trx.txt")
-	biglongFH, err := os.OpenFile(biglongFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	biglongFH, err := os.OpenFile(biglongFile, this.GetResultFileOpenFlag(), 0644)
 	if err != nil {
 		log.Fatalf("fail to open file %v"+biglongFile, err)
 	}
-	biglongFH.WriteString(GetBigLongTrxPrintHeaderLine(Stats_BigLongTrx_Header_Column_names))
+	if this.ResumeCheckpoint == nil {
+		biglongFH.WriteString(GetBigLongTrxPrintHeaderLine(Stats_BigLongTrx_Header_Column_names))
+	}
 	this.BiglongFH = biglongFH
 }
 
+// GetResultFileOpenFlag -resume 时追加写入统计结果文件，否则清空。
+// 统计结果按时间间隔汇总，和事务边界的 checkpoint 对不上，不截断，checkpoint 之后已经统计的事件会重复统计
+func (this *ConfCmd) GetResultFileOpenFlag() int {
+	if this.ResumeCheckpoint != nil {
+		return os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	return os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+}
+
+// IfParseOneBinlogFile file 模式下没有指定结束位置、-follow 和文件列表时，只解析 -local-binlog-file 一个文件
+func (this *ConfCmd) IfParseOneBinlogFile() bool {
+	return !this.IfSetStopParsPoint && !this.IfSetStopDateTime && !this.Follow && this.BinlogFileList == nil
+}
+
 func (this *ConfCmd) CloseFH(){
 	this.StatFH.Close()
 	this.BiglongFH.Close()
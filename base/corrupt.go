@@ -0,0 +1,297 @@
+package base
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	// -on-corrupt 遇到损坏的事件时的处理方式
+	C_onCorruptAbort  = "abort"  // 停止解析
+	C_onCorruptSkip   = "skip"   // 跳过损坏的部分，从下一个合法的事件继续解析
+	C_onCorruptReport = "report" // 和 skip 一样扫描整个 binlog ，只生成损坏报告，不生成 sql 和统计结果
+
+	CorruptReportFile = "corruption_report.txt"
+
+	C_eventReaderBufSize = 4 * 1024 * 1024    // 不超过这个大小的事件损坏时可以逐字节查找下一个事件
+	C_maxEventSize       = 1024 * 1024 * 1024 // 事件最大 1G ，和 max_allowed_packet 的上限一致
+)
+
+var (
+	GOptsValidOnCorrupt []string = []string{C_onCorruptAbort, C_onCorruptSkip, C_onCorruptReport}
+
+	Corrupt_Report_Header_Column_names []string = []string{"binlog", "startpos", "stoppos", "bytes", "reason"}
+)
+
+// CorruptReport 记录 binlog 中损坏的字节范围，写入 corruption_report.txt ，并行解析的多个文件共用
+type CorruptReport struct {
+	file  string
+	fh    *os.File
+	lock  sync.Mutex
+	Count int
+}
+
+func NewCorruptReport(dir string) (*CorruptReport, error) {
+	file := filepath.Join(dir, CorruptReportFile)
+	fh, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Annotatef(err, "fail to open %s", file)
+	}
+	if _, err = fh.WriteString(GetCorruptReportLine(Corrupt_Report_Header_Column_names)); err != nil {
+		fh.Close()
+		return nil, errors.Annotatef(err, "fail to write %s", file)
+	}
+	return &CorruptReport{file: file, fh: fh}, nil
+}
+
+func GetCorruptReportLine(columns []string) string {
+	return fmt.Sprintf("%-17s %-10s %-10s %-10s %s\n", ConvertStrArrToIntferfaceArrForPrint(columns)...)
+}
+
+// Add 记录 [start, stop) 之间的字节已损坏
+func (this *CorruptReport) Add(binlog string, start uint32, stop uint32, reason string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Count++
+	log.Warnf("%s of %s, skip damaged bytes [%d, %d)", reason, binlog, start, stop)
+	line := GetCorruptReportLine([]string{
+		binlog,
+		fmt.Sprintf("%d", start),
+		fmt.Sprintf("%d", stop),
+		fmt.Sprintf("%d", stop-start),
+		reason,
+	})
+	if _, err := this.fh.WriteString(line); err != nil {
+		log.Errorf("fail to write %s %v", this.file, err)
+	}
+}
+
+func (this *CorruptReport) Close() {
+	if err := this.fh.Close(); err != nil {
+		log.Errorf("fail to close %s %v", this.file, err)
+	}
+	log.Infof("%d damaged byte ranges found in binlog, see %s", this.Count, this.file)
+}
+
+// BinlogEventReader 从 binlog 文件中逐个读取事件，按 FORMAT_DESCRIPTION_EVENT 中的算法校验 checksum 。
+//
+// 遇到损坏的事件时，-on-corrupt=abort 返回错误；skip/report 把损坏的字节范围记录到 CorruptReport ，
+// 然后逐字节查找下一个合法的事件头继续读取。
+type BinlogEventReader struct {
+	br        *bufio.Reader
+	binlog    *string
+	onCorrupt string
+	report    *CorruptReport
+
+	pos         uint32 // 下一个事件在文件中的偏移，调用方已经读取了 4B 文件头
+	eventPos    uint32 // 最近读取的事件在文件中的偏移
+	checksumAlg byte
+	hasFde      bool // 是否已经读到 FORMAT_DESCRIPTION_EVENT ，之前的事件损坏时无法恢复
+}
+
+func NewBinlogEventReader(r io.Reader, binlog *string, onCorrupt string, report *CorruptReport) *BinlogEventReader {
+	return &BinlogEventReader{
+		br:          bufio.NewReaderSize(r, C_eventReaderBufSize),
+		binlog:      binlog,
+		onCorrupt:   onCorrupt,
+		report:      report,
+		pos:         uint32(len(replication.BinLogFileHeader)),
+		checksumAlg: replication.BINLOG_CHECKSUM_ALG_UNDEF,
+	}
+}
+
+// EventPos 最近读取的事件在文件中的偏移
+func (this *BinlogEventReader) EventPos() uint32 {
+	return this.eventPos
+}
+
+// ReadEvent 读取下一个完整并且 checksum 正确的事件，返回事件头和包含事件头的原始数据。
+// 文件结束，或者 skip/report 时文件尾部的事件不完整，返回 io.EOF 。
+func (this *BinlogEventReader) ReadEvent() (*replication.EventHeader, []byte, error) {
+	for {
+		this.eventPos = this.pos
+		head, err := this.br.Peek(replication.EventHeaderSize)
+		if len(head) == 0 && err == io.EOF {
+			return nil, nil, io.EOF
+		} else if len(head) < replication.EventHeaderSize {
+			if err != io.EOF {
+				return nil, nil, errors.Trace(err)
+			}
+			return nil, nil, this.skipToEnd(len(head), "truncated event header")
+		}
+
+		h := &replication.EventHeader{}
+		if err = h.Decode(head); err != nil || !IsValidEventHeader(h) {
+			if err = this.resync("invalid event header"); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		// 事件较小时先 Peek ，损坏时可以回退到事件头之后查找下一个事件
+		var rawData []byte
+		size := int(h.EventSize)
+		if size <= this.br.Size() {
+			data, err := this.br.Peek(size)
+			if len(data) < size {
+				if err != io.EOF {
+					return nil, nil, errors.Trace(err)
+				}
+				return nil, nil, this.skipToEnd(len(data), "truncated event")
+			}
+			if !this.verifyChecksum(h, data) {
+				if err = this.resync("checksum mismatch"); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			rawData = make([]byte, size)
+			copy(rawData, data)
+			this.br.Discard(size)
+		} else {
+			rawData = make([]byte, size)
+			n, err := io.ReadFull(this.br, rawData)
+			if n < size {
+				if err != io.EOF && err != io.ErrUnexpectedEOF {
+					return nil, nil, errors.Trace(err)
+				}
+				return nil, nil, this.skipToEnd(n, "truncated event")
+			}
+			// 已经读出，无法回退，只能相信事件头中的大小，跳过整个事件
+			if !this.verifyChecksum(h, rawData) {
+				if err = this.onDamaged(this.pos, this.pos+h.EventSize, "checksum mismatch"); err != nil {
+					return nil, nil, err
+				}
+				this.pos += h.EventSize
+				continue
+			}
+		}
+		this.pos += h.EventSize
+
+		if h.EventType == replication.FORMAT_DESCRIPTION_EVENT {
+			this.checksumAlg = GetChecksumAlgOfFde(rawData)
+			this.hasFde = true
+		}
+		return h, rawData, nil
+	}
+}
+
+// OnDamagedEvent 最近读取的事件无法解析时调用，skip/report 时记录下来，返回 nil 表示跳过该事件
+func (this *BinlogEventReader) OnDamagedEvent(h *replication.EventHeader, reason string) error {
+	return this.onDamaged(this.eventPos, this.eventPos+h.EventSize, reason)
+}
+
+func (this *BinlogEventReader) onDamaged(start uint32, stop uint32, reason string) error {
+	if this.onCorrupt == C_onCorruptAbort {
+		return errors.Errorf("%s of %s, damaged bytes [%d, %d)", reason, *this.binlog, start, stop)
+	}
+	if !this.hasFde {
+		return errors.Errorf("%s of %s before format description event, damaged bytes [%d, %d), can not recover", reason, *this.binlog, start, stop)
+	}
+	this.report.Add(*this.binlog, start, stop, reason)
+	return nil
+}
+
+// skipToEnd 文件尾部 n 个字节不足一个事件，crash 之后的 binlog 经常出现这种情况
+func (this *BinlogEventReader) skipToEnd(n int, reason string) error {
+	if err := this.onDamaged(this.pos, this.pos+uint32(n), reason); err != nil {
+		return err
+	}
+	this.br.Discard(n)
+	this.pos += uint32(n)
+	return io.EOF
+}
+
+// resync 从当前位置的下一个字节开始查找合法的事件，跳过的字节记为损坏，找不到时返回 io.EOF
+func (this *BinlogEventReader) resync(reason string) error {
+	start := this.pos
+	if this.onCorrupt == C_onCorruptAbort || !this.hasFde {
+		return errors.Errorf("%s of %s at position %d", reason, *this.binlog, start)
+	}
+
+	for {
+		this.br.Discard(1)
+		this.pos++
+
+		head, err := this.br.Peek(replication.EventHeaderSize)
+		if len(head) < replication.EventHeaderSize {
+			if err != io.EOF {
+				return errors.Trace(err)
+			}
+			this.report.Add(*this.binlog, start, this.pos+uint32(len(head)), reason)
+			this.br.Discard(len(head))
+			this.pos += uint32(len(head))
+			return io.EOF
+		}
+		if this.isEventStart(head) {
+			this.report.Add(*this.binlog, start, this.pos, reason)
+			return nil
+		}
+	}
+}
+
+// isEventStart 判断 head 是否为一个合法事件的开始。
+// 事件能放入缓冲区并且有 checksum 时校验 checksum ，否则要求事件头中的结束位置和文件偏移一致。
+func (this *BinlogEventReader) isEventStart(head []byte) bool {
+	h := &replication.EventHeader{}
+	if err := h.Decode(head); err != nil || !IsValidEventHeader(h) {
+		return false
+	}
+	if this.checksumAlg == replication.BINLOG_CHECKSUM_ALG_CRC32 && int(h.EventSize) <= this.br.Size() {
+		data, _ := this.br.Peek(int(h.EventSize))
+		if len(data) < int(h.EventSize) {
+			return h.LogPos == this.pos+h.EventSize
+		}
+		return this.verifyChecksum(h, data)
+	}
+	return h.LogPos == this.pos+h.EventSize
+}
+
+func (this *BinlogEventReader) verifyChecksum(h *replication.EventHeader, rawData []byte) bool {
+	checksumAlg := this.checksumAlg
+	if h.EventType == replication.FORMAT_DESCRIPTION_EVENT {
+		checksumAlg = GetChecksumAlgOfFde(rawData)
+	}
+	if checksumAlg != replication.BINLOG_CHECKSUM_ALG_CRC32 {
+		return true
+	}
+	if len(rawData) < replication.EventHeaderSize+replication.BinlogChecksumLength {
+		return false
+	}
+	n := len(rawData) - replication.BinlogChecksumLength
+	return crc32.ChecksumIEEE(rawData[:n]) == binary.LittleEndian.Uint32(rawData[n:])
+}
+
+// IsValidEventHeader 事件类型和大小是否合法
+func IsValidEventHeader(h *replication.EventHeader) bool {
+	if h.EventSize <= uint32(replication.EventHeaderSize) || h.EventSize > C_maxEventSize {
+		return false
+	}
+	// mysql 的事件类型到 HEARTBEAT_LOG_EVENT_V2(41) ，mariadb 的从 160 开始
+	return (h.EventType > replication.UNKNOWN_EVENT && h.EventType <= 41) ||
+		(h.EventType >= replication.MARIADB_ANNOTATE_ROWS_EVENT && h.EventType < 176)
+}
+
+// GetChecksumAlgOfFde 从 FORMAT_DESCRIPTION_EVENT 的原始数据中获取 checksum 算法，老版本没有返回 UNDEF
+func GetChecksumAlgOfFde(rawData []byte) byte {
+	fde := &replication.FormatDescriptionEvent{}
+	body := rawData[replication.EventHeaderSize:]
+	// binlog version(2) + server version(50) + create timestamp(4) + header length(1)
+	if len(body) < 57+5 {
+		return replication.BINLOG_CHECKSUM_ALG_UNDEF
+	}
+	if err := fde.Decode(body); err != nil {
+		return replication.BINLOG_CHECKSUM_ALG_UNDEF
+	}
+	return fde.ChecksumAlgorithm
+}
@@ -0,0 +1,199 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// testBinlogWriter 生成带 crc32 checksum 的 binlog ，记录每个事件的开始位置
+type testBinlogWriter struct {
+	buf       bytes.Buffer
+	eventPoss []uint32
+}
+
+func newTestBinlogWriter() *testBinlogWriter {
+	w := &testBinlogWriter{}
+	w.buf.Write(replication.BinLogFileHeader)
+
+	// FORMAT_DESCRIPTION_EVENT: binlog version, server version, create timestamp, header length, post header lengths, checksum alg
+	var body []byte
+	body = append(body, 4, 0)
+	serverVersion := make([]byte, 50)
+	copy(serverVersion, "8.0.30-log")
+	body = append(body, serverVersion...)
+	body = append(body, 0, 0, 0, 0, replication.EventHeaderSize)
+	body = append(body, make([]byte, 41)...)
+	body = append(body, replication.BINLOG_CHECKSUM_ALG_CRC32)
+	w.writeEvent(replication.FORMAT_DESCRIPTION_EVENT, body)
+	return w
+}
+
+func (this *testBinlogWriter) writeEvent(typ replication.EventType, body []byte) {
+	pos := uint32(this.buf.Len())
+	size := uint32(replication.EventHeaderSize + len(body) + replication.BinlogChecksumLength)
+	head := make([]byte, replication.EventHeaderSize)
+	binary.LittleEndian.PutUint32(head[0:], 1700000000)
+	head[4] = byte(typ)
+	binary.LittleEndian.PutUint32(head[5:], 1)
+	binary.LittleEndian.PutUint32(head[9:], size)
+	binary.LittleEndian.PutUint32(head[13:], pos+size)
+	data := append(head, body...)
+	checksum := make([]byte, replication.BinlogChecksumLength)
+	binary.LittleEndian.PutUint32(checksum, crc32.ChecksumIEEE(data))
+	this.buf.Write(append(data, checksum...))
+	this.eventPoss = append(this.eventPoss, pos)
+}
+
+func (this *testBinlogWriter) writeQuery(query string) {
+	// thread id, exec time, db name length, error code, status vars length, db name
+	body := []byte{1, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0}
+	body = append(body, "test"...)
+	body = append(body, 0)
+	this.writeEvent(replication.QUERY_EVENT, append(body, query...))
+}
+
+func (this *testBinlogWriter) writeXid() {
+	this.writeEvent(replication.XID_EVENT, make([]byte, 8))
+}
+
+// newDamagedTestBinlog FDE, BEGIN, insert, XID, BEGIN, XID ，insert 事件中翻转一个字节，最后一个事件截断
+func newDamagedTestBinlog() ([]byte, []uint32) {
+	w := newTestBinlogWriter()
+	w.writeQuery("BEGIN")
+	w.writeQuery("insert into t values (1)")
+	w.writeXid()
+	w.writeQuery("BEGIN")
+	w.writeXid()
+	data := w.buf.Bytes()
+	data[w.eventPoss[2]+replication.EventHeaderSize+20] ^= 0xff
+	return data[:len(data)-5], w.eventPoss
+}
+
+// readAllEvents 读取所有事件，返回每个事件的开始位置
+func readAllEvents(reader *BinlogEventReader) ([]uint32, error) {
+	var poss []uint32
+	for {
+		_, _, err := reader.ReadEvent()
+		if err == io.EOF {
+			return poss, nil
+		} else if err != nil {
+			return poss, err
+		}
+		poss = append(poss, reader.EventPos())
+	}
+}
+
+func newTestBinlogEventReader(t *testing.T, data []byte, onCorrupt string) (*BinlogEventReader, *CorruptReport, string) {
+	dir, err := ioutil.TempDir("", "my2sql_corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewCorruptReport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binlog := "mysql-bin.000001"
+	data = data[len(replication.BinLogFileHeader):]
+	return NewBinlogEventReader(bytes.NewReader(data), &binlog, onCorrupt, report), report, dir
+}
+
+func TestBinlogEventReaderValid(t *testing.T) {
+	w := newTestBinlogWriter()
+	w.writeQuery("BEGIN")
+	w.writeXid()
+	reader, report, dir := newTestBinlogEventReader(t, w.buf.Bytes(), C_onCorruptAbort)
+	defer os.RemoveAll(dir)
+	defer report.Close()
+
+	poss, err := readAllEvents(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(poss) != fmt.Sprint(w.eventPoss) {
+		t.Errorf("got events at %v, want %v", poss, w.eventPoss)
+	}
+}
+
+func TestBinlogEventReaderAbort(t *testing.T) {
+	data, eventPoss := newDamagedTestBinlog()
+	reader, report, dir := newTestBinlogEventReader(t, data, C_onCorruptAbort)
+	defer os.RemoveAll(dir)
+	defer report.Close()
+
+	poss, err := readAllEvents(reader)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("got error %v, want checksum mismatch", err)
+	}
+	if fmt.Sprint(poss) != fmt.Sprint(eventPoss[:2]) {
+		t.Errorf("got events at %v before error, want %v", poss, eventPoss[:2])
+	}
+
+	// 只有尾部截断时同样返回错误
+	w := newTestBinlogWriter()
+	w.writeQuery("BEGIN")
+	reader, report2, dir2 := newTestBinlogEventReader(t, w.buf.Bytes()[:w.buf.Len()-1], C_onCorruptAbort)
+	defer os.RemoveAll(dir2)
+	defer report2.Close()
+	if _, err = readAllEvents(reader); err == nil || err == io.EOF {
+		t.Errorf("got error %v for truncated event, want error", err)
+	}
+}
+
+func TestBinlogEventReaderSkip(t *testing.T) {
+	for _, onCorrupt := range []string{C_onCorruptSkip, C_onCorruptReport} {
+		data, eventPoss := newDamagedTestBinlog()
+		reader, report, dir := newTestBinlogEventReader(t, data, onCorrupt)
+		defer os.RemoveAll(dir)
+
+		poss, err := readAllEvents(reader)
+		if err != nil {
+			t.Fatalf("%s: %v", onCorrupt, err)
+		}
+		// 跳过损坏的 insert 事件，从下一个事件头继续，截断的最后一个事件不返回
+		want := []uint32{eventPoss[0], eventPoss[1], eventPoss[3], eventPoss[4]}
+		if fmt.Sprint(poss) != fmt.Sprint(want) {
+			t.Errorf("%s: got events at %v, want %v", onCorrupt, poss, want)
+		}
+		report.Close()
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, CorruptReportFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantReport := GetCorruptReportLine(Corrupt_Report_Header_Column_names) +
+			GetCorruptReportLine([]string{"mysql-bin.000001", fmt.Sprint(eventPoss[2]), fmt.Sprint(eventPoss[3]),
+				fmt.Sprint(eventPoss[3] - eventPoss[2]), "checksum mismatch"}) +
+			GetCorruptReportLine([]string{"mysql-bin.000001", fmt.Sprint(eventPoss[5]), fmt.Sprint(len(data)),
+				fmt.Sprint(uint32(len(data)) - eventPoss[5]), "truncated event"})
+		if string(content) != wantReport {
+			t.Errorf("%s: got report\n%s\nwant\n%s", onCorrupt, content, wantReport)
+		}
+		if report.Count != 2 {
+			t.Errorf("%s: got %d damaged ranges, want 2", onCorrupt, report.Count)
+		}
+	}
+}
+
+func TestBinlogEventReaderDamagedBeforeFde(t *testing.T) {
+	w := newTestBinlogWriter()
+	w.writeXid()
+	data := w.buf.Bytes()
+	data[len(replication.BinLogFileHeader)+replication.EventHeaderSize+10] ^= 0xff
+	reader, report, dir := newTestBinlogEventReader(t, data, C_onCorruptSkip)
+	defer os.RemoveAll(dir)
+	defer report.Close()
+
+	if _, err := readAllEvents(reader); err == nil || err == io.EOF {
+		t.Errorf("got error %v for damaged format description event, want error", err)
+	}
+}
@@ -0,0 +1,101 @@
+package base
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/siddontang/go-log/log"
+	"my2sql/dsql"
+	"my2sql/sqltypes"
+)
+
+// enum/set/bit 列的值
+//
+// binlog 中 enum 是取值的序号(从 1 开始)，set 是位图，bit 是整数。生成 sql 时 enum/set 输出为字符串，
+// 取值来自 binlog_row_metadata=FULL 的 TABLE_MAP_EVENT、-table-def-file 或者数据库的表结构；
+// bit 输出为 b'101' 。-enum-set-bit-as-number 时都输出为数字。
+
+var (
+	warnedEnumSetLock    sync.Mutex
+	warnedEnumSetColumns = map[string]bool{} // {db.tb.col: true}，没有取值的 enum/set 列只警告一次
+)
+
+// IsEnumSetType 是否 enum 或者 set 类型
+func IsEnumSetType(fieldType string) bool {
+	fieldType = strings.ToLower(fieldType)
+	return fieldType == "enum" || fieldType == "set"
+}
+
+// GetEnumSetValues information_schema.COLUMNS.COLUMN_TYPE 中 enum/set 的取值，如 enum('a','b') 的 a 和 b ，其他类型返回空
+func GetEnumSetValues(colType string) []string {
+	if !IsEnumSetType(GetFiledType(colType)) {
+		return nil
+	}
+	col, err := dsql.ParseColumnType(colType)
+	if err != nil {
+		log.Warnf("fail to get values of %s: %v", colType, err)
+		return nil
+	}
+	return col.Args
+}
+
+// DecodeEnumSetValues TABLE_MAP_EVENT 中的 enum/set 取值是列字符集的原始字节，转换为 utf8
+func DecodeEnumSetValues(values []string, charset string) []string {
+	charset = NormalizeCharset(charset)
+	decoded := make([]string, len(values))
+	for i, val := range values {
+		utf8Str, ok := TranscodeCharsetToUtf8(val, charset)
+		if !ok {
+			return values
+		}
+		decoded[i] = utf8Str
+	}
+	return decoded
+}
+
+// ConvertEnumSetBitValue 把 enum 的序号、set 的位图转换为字符串，bit 转换为 sqltypes.Bit 。
+// enum/set 没有对应的取值时返回 false ，保持数字
+func ConvertEnumSetBitValue(val interface{}, colType string, values []string) (interface{}, bool) {
+	num, ok := val.(int64)
+	if !ok {
+		return val, true
+	}
+	switch colType {
+	case "bit":
+		return sqltypes.Bit(num), true
+	case "enum":
+		// 序号 0 是插入非法值时保存的空字符串
+		if num == 0 {
+			return "", true
+		}
+		if num < 0 || num > int64(len(values)) {
+			return val, false
+		}
+		return values[num-1], true
+	case "set":
+		var labels []string
+		for i := 0; i < 64 && num>>uint(i) != 0; i++ {
+			if num>>uint(i)&1 == 0 {
+				continue
+			}
+			if i >= len(values) {
+				return val, false
+			}
+			labels = append(labels, values[i])
+		}
+		return strings.Join(labels, ","), true
+	}
+	return val, true
+}
+
+// WarnEnumSetWithoutValues 表结构中没有 enum/set 列的取值，每个列只警告一次
+func WarnEnumSetWithoutValues(tbKey string, colName string, posStr string) {
+	key := tbKey + "." + colName
+	warnedEnumSetLock.Lock()
+	defer warnedEnumSetLock.Unlock()
+	if warnedEnumSetColumns[key] {
+		return
+	}
+	warnedEnumSetColumns[key] = true
+	log.Warnf("no values of enum/set column %s in table struct, output its numeric value. RowsEvent position:%s", key, posStr)
+}
@@ -0,0 +1,66 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConvertEnumSetBitValue(t *testing.T) {
+	var values64 []string
+	for i := 0; i < 64; i++ {
+		values64 = append(values64, string(rune('a'+i%26))+string(rune('0'+i/26)))
+	}
+	cases := []struct {
+		name    string
+		val     interface{}
+		colType string
+		values  []string
+		want    string // 输出到 sql 中的形式
+		ok      bool
+	}{
+		{"enum", int64(2), "enum", []string{"x", "y,z"}, "'y,z'", true},
+		{"enum index 0", int64(0), "enum", []string{"x"}, "''", true},
+		{"enum index past the end", int64(3), "enum", []string{"x", "y"}, "3", false},
+		{"enum without values", int64(1), "enum", nil, "1", false},
+		{"set", int64(5), "set", []string{"a", "b", "c"}, "'a,c'", true},
+		{"empty set", int64(0), "set", []string{"a"}, "''", true},
+		{"set with 64 members", int64(-1 << 63), "set", values64, "'l2'", true},
+		{"set with top and bottom bits", int64(-1<<63 | 1), "set", values64, "'a0,l2'", true},
+		{"set bit beyond values", int64(1 | 1<<3), "set", []string{"a", "b", "c"}, "9", false},
+		{"bit", int64(5), "bit", nil, "b'101'", true},
+		{"bit 0", int64(0), "bit", nil, "b'0'", true},
+		{"bit(64)", int64(-1), "bit", nil, "b'1111111111111111111111111111111111111111111111111111111111111111'", true},
+		{"not int64", "x", "enum", []string{"x"}, "'x'", true},
+		{"other type", int64(7), "int", nil, "7", true},
+	}
+	for _, c := range cases {
+		got, ok := ConvertEnumSetBitValue(c.val, c.colType, c.values)
+		if ok != c.ok {
+			t.Errorf("%s: got ok %v, want %v", c.name, ok, c.ok)
+		}
+		if s := encodeSqlValue(t, got); s != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, s, c.want)
+		}
+	}
+}
+
+func TestDecodeEnumSetValues(t *testing.T) {
+	cases := []struct {
+		name    string
+		values  []string
+		charset string
+		want    []string
+	}{
+		{"utf8mb4", []string{"中", "b"}, "utf8mb4", []string{"中", "b"}},
+		{"gbk", []string{"\xd6\xd0", "b"}, "GBK", []string{"中", "b"}},
+		{"latin1", []string{"caf\xe9", "\x81"}, "latin1", []string{"café", "\u0081"}},
+		{"invalid gbk", []string{"\xd6\xd0", "\xd6"}, "gbk", []string{"\xd6\xd0", "\xd6"}},
+		{"unsupported charset", []string{"\xa4"}, "dec8", []string{"\xa4"}},
+		{"unknown charset", []string{"a"}, "", []string{"a"}},
+	}
+	for _, c := range cases {
+		if got := DecodeEnumSetValues(c.values, c.charset); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -24,11 +24,15 @@ type ExtraSqlInfoOfPrint struct {
 	datetime  string
 	trxIndex  uint64
 	trxStatus int
+	rowsQuery string // 原始语句，作为注释输出在 sql 上面
 }
 
 type ForwardRollbackSqlOfPrint struct {
-	sqls    []string
-	sqlInfo ExtraSqlInfoOfPrint
+	sqls       []string
+	sqlInfo    ExtraSqlInfoOfPrint
+	insertRows *InsertRows // insert 语句的各行，用于把同一事务中同一个表的连续 insert 合并成多行 insert
+	checkpoint *Checkpoint // 不为空时只用于写 checkpoint 文件，没有 sql
+	trxEnd     bool        // 事务结束，没有 sql
 }
 
 var (
@@ -49,9 +53,11 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 		colsTypeName       []string
 		colCnt             int
 		sqlArr             []string
+		insRows            *InsertRows
 		uniqueKeyIdx       []int
 		uniqueKey          KeyInfo
 		primaryKeyIdx      []int
+		generatedIdx       []int
 		ifRollback         bool = false
 		ifIgnorePrimary    bool = cfg.IgnorePrimaryKeyForInsert
 		currentSqlForPrint ForwardRollbackSqlOfPrint
@@ -65,6 +71,12 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 	}
 
 	for ev := range cfg.EventChan {
+		// 事务结束事件，按顺序输出合并中的 insert ，checkpoint 交给写文件线程
+		if ev.TrxEnd {
+			PrintForwardRollbackSqlInOrder(cfg, ev.EventIdx, ForwardRollbackSqlOfPrint{checkpoint: ev.Checkpoint, trxEnd: true})
+			continue
+		}
+
 		// 只处理 rows 类型事件
 		if !ev.IfRowsEvent {
 			continue
@@ -76,8 +88,11 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 		tb = string(ev.BinEvent.Table.Table)
 		fulltb = GetAbsTableName(db, tb)
 
-		// 获取库表信息
-		tbInfo, err = G_TablesColumnsInfo.GetTableInfoJson(db, tb)
+		// 获取库表信息，使用读取事件时的表结构
+		tbInfo = ev.TableInfo
+		if tbInfo == nil {
+			tbInfo, err = G_TablesColumnsInfo.GetTableInfoJson(db, tb)
+		}
 		if err != nil {
 			log.Errorf(fmt.Sprintf("error to found %s table structure for event", fulltb))
 			continue
@@ -95,6 +110,7 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 		colsDef, colsTypeName = GetSqlFieldsEXpressions(colCnt, allColNames, ev.BinEvent.Table)
 
 		colsTypeNameFromMysql := make([]string, len(colsTypeName))
+		collations := ev.BinEvent.Table.CollationMap()
 		if len(colsTypeName) > len(tbInfo.Columns) {
 			log.Fatalf("%s column count %d in binlog > in table structure %d, usually means DDL in the middle", fulltb, len(colsTypeName), len(tbInfo.Columns))
 		}
@@ -132,20 +148,48 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 				}
 			}
 
-			/*if colType == "json" {
-				for ri, _ := range ev.BinEvent.Rows {
-					if ev.BinEvent.Rows[ri][ci] == nil {
-						continue
-					}
-					txtStr, coOk := ev.BinEvent.Rows[ri][ci].([]byte)
-					if !coOk {
-						log.Fatalf("%s.%s %v []byte  empty %s", fulltb, allColNames[ci].FieldName, ev.BinEvent.Rows[ri][ci], posStr)
-					} else {
-						ev.BinEvent.Rows[ri][ci] = string(txtStr)
+			// 字符串转换为 -sql-charset ，blob 的值是 []byte ，不转换。
+			// TABLE_MAP_EVENT 中有字符集(binlog_row_metadata)时优先使用，这是写 binlog 时的字符集
+			if colType == "varchar" || colType == "char" || colType == "blob" {
+				charset := GetColumnCharset(tbInfo.Columns[ci])
+				if coll, ok := collations[ci]; ok && GetCharsetOfCollationId(coll) != "" {
+					charset = GetCharsetOfCollationId(coll)
+				}
+				for ri := range ev.BinEvent.Rows {
+					ev.BinEvent.Rows[ri][ci] = ConvertStringCharset(ev.BinEvent.Rows[ri][ci], charset, cfg.SqlCharset)
+				}
+			}
+
+			// enum/set 输出为字符串，bit 输出为 b'..'
+			if !cfg.EnumSetBitAsNumber && (colType == "enum" || colType == "set" || colType == "bit") {
+				for ri := range ev.BinEvent.Rows {
+					val, ok := ConvertEnumSetBitValue(ev.BinEvent.Rows[ri][ci], colType, tbInfo.Columns[ci].Values)
+					if !ok {
+						WarnEnumSetWithoutValues(fulltb, tbInfo.Columns[ci].FieldName, posStr)
 					}
+					ev.BinEvent.Rows[ri][ci] = val
 				}
+			}
 
-			}*/
+			// json 输出为 mysql 格式的 json 文本，geometry 输出为 ST_GeomFromWKB(x'..', srid[, 'axis-order=long-lat'])
+			if colType == "json" {
+				for ri := range ev.BinEvent.Rows {
+					val, err := ConvertJsonValue(ev.BinEvent.Rows[ri][ci], cfg.JsonCast)
+					if err != nil {
+						log.Warnf("fail to parse json value of %s.%s, output it as a string: %v. RowsEvent position:%s", fulltb, allColNames[ci].FieldName, err, posStr)
+					}
+					ev.BinEvent.Rows[ri][ci] = val
+				}
+			}
+			if colType == "geometry" {
+				for ri := range ev.BinEvent.Rows {
+					val, ok := ConvertGeometryValue(ev.BinEvent.Rows[ri][ci], cfg.MysqlType)
+					if !ok {
+						log.Warnf("invalid geometry value of %s.%s, output it as bytes. RowsEvent position:%s", fulltb, allColNames[ci].FieldName, posStr)
+					}
+					ev.BinEvent.Rows[ri][ci] = val
+				}
+			}
 
 		}
 
@@ -167,7 +211,11 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 			ifIgnorePrimary = false
 		}
 
+		// 生成列
+		generatedIdx = GetGeneratedColIndex(tbInfo.Columns)
+
 		// 生成 sql 语句
+		insRows = nil
 		if ev.SqlType == "insert" {
 			if ifRollback {
 				// 生成一组 Delete 语句
@@ -182,37 +230,55 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 
 			} else {
 				// 生成一组 Insert 语句
-				sqlArr = GenInsertSqlsForOneRowsEvent(
+				insRows = GenInsertRowsForOneRowsEvent(
 					posStr,
 					ev.BinEvent,
 					colsDef,
-					1,			// 一条 sql 负责几个 rows 的插入，默认是 1
 					false,
 					cfg.SqlTblPrefixDb,
 					ifIgnorePrimary,
 					primaryKeyIdx,
+					generatedIdx,
+					cfg.InsertMode,
 				)
+				// 一条 sql 负责几个 rows 的插入，默认是 1
+				sqlArr = insRows.Sqls(cfg.InsertRows, cfg.MaxStatementBytes, false)
 			}
 		} else if ev.SqlType == "delete" {
 			if ifRollback {
-				sqlArr = GenInsertSqlsForOneRowsEventRollbackDelete(posStr, ev.BinEvent, colsDef, 1, cfg.SqlTblPrefixDb)
+				insRows = GenInsertRowsForOneRowsEventRollbackDelete(posStr, ev.BinEvent, colsDef, cfg.SqlTblPrefixDb, generatedIdx, cfg.InsertMode)
+				sqlArr = insRows.Sqls(cfg.InsertRows, cfg.MaxStatementBytes, true)
 			} else {
 				sqlArr = GenDeleteSqlsForOneRowsEvent(posStr, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb)
 			}
 		} else if ev.SqlType == "update" {
-			if ifRollback {
-				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, true, cfg.SqlTblPrefixDb)
+			// update 转换为 upsert ，没有主键和唯一键的表仍然用 update
+			if cfg.UpdateAsUpsert && len(uniqueKeyIdx) > 0 {
+				delSqls, upsertRows := GenUpsertForOneRowsEventUpdate(posStr, ev.BinEvent, colsDef, uniqueKeyIdx, ifRollback, cfg.SqlTblPrefixDb, generatedIdx)
+				sqlArr = JoinUpsertSqls(delSqls, upsertRows, cfg.InsertRows, cfg.MaxStatementBytes, ifRollback)
+				// 没有 delete 时可以和相邻的 insert 合并
+				if len(delSqls) == 0 {
+					insRows = upsertRows
+				}
+			} else if ifRollback {
+				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, true, cfg.SqlTblPrefixDb, generatedIdx)
 			} else {
-				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb)
+				sqlArr = GenUpdateSqlsForOneRowsEvent(posStr, colsTypeNameFromMysql, colsTypeName, ev.BinEvent, colsDef, uniqueKeyIdx, cfg.FullColumns, false, cfg.SqlTblPrefixDb, generatedIdx)
 			}
 		} else {
-			fmt.Println("unsupported query type %s to generate 2sql|rollback sql, it should one of insert|update|delete. %s", ev.SqlType, ev.MyPos.String())
+			log.Errorf("unsupported query type %s to generate 2sql|rollback sql, it should one of insert|update|delete. %s", ev.SqlType, ev.MyPos.String())
 			continue
 		}
 
+		// 没有主键和唯一键，delete/update 用所有字段作为条件。-update-as-upsert 只用于有主键或唯一键的表，不会走到这里
+		if len(uniqueKeyIdx) == 0 {
+			G_KeylessTables.AddRowsEvent(db, tb, ev.SqlType, ifRollback, len(ev.BinEvent.Rows), len(sqlArr))
+		}
+
 		// 构造解析结果，用于输出
 		currentSqlForPrint = ForwardRollbackSqlOfPrint{
 			sqls: sqlArr,
+			insertRows: insRows,
 			sqlInfo: ExtraSqlInfoOfPrint{
 				schema: db,
 				table: tb,
@@ -222,34 +288,125 @@ func GenForwardRollbackSqlFromBinEvent(i uint, cfg *ConfCmd, wg *sync.WaitGroup)
 				datetime: GetDatetimeStr(int64(ev.Timestamp), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
 				trxIndex: ev.TrxIndex,
 				trxStatus: ev.TrxStatus,
+				rowsQuery: ev.RowsQuery,
 			},
 		}
 
-		for {
-			//fmt.Println("in thread", i)
-			// 锁，多个 goroutine 串行输出，避免错乱
-			G_HandlingBinEventIndex.lock.Lock()
-			//fmt.Println("handing index:", G_HandlingBinEventIndex.EventIdx, "binevent index:", ev.EventIdx)
-			if G_HandlingBinEventIndex.EventIdx == ev.EventIdx {
-				// 输出到屏幕
-				if cfg.OutputToScreen {
-					for _, sql := range currentSqlForPrint.sqls {
-						fmt.Println(sql)
-					}
-				// 输出到管道
-				} else {
-					cfg.SqlChan <- currentSqlForPrint
-				}
-				G_HandlingBinEventIndex.EventIdx++
-				G_HandlingBinEventIndex.lock.Unlock()
-				//fmt.Println("handing index == binevent index, break")
-				break
-			}
+		PrintForwardRollbackSqlInOrder(cfg, ev.EventIdx, currentSqlForPrint)
+	}
+	log.Infof(fmt.Sprintf("exit thread %d to generate redo/rollback sql", i))
+}
+
+// PrintForwardRollbackSqlInOrder 等到 eventIdx 之前的事件都输出之后再输出，保证和 binlog 中的顺序一致
+func PrintForwardRollbackSqlInOrder(cfg *ConfCmd, eventIdx uint64, sqlForPrint ForwardRollbackSqlOfPrint) {
+	for {
+		//fmt.Println("in thread", i)
+		// 锁，多个 goroutine 串行输出，避免错乱
+		G_HandlingBinEventIndex.lock.Lock()
+		//fmt.Println("handing index:", G_HandlingBinEventIndex.EventIdx, "binevent index:", eventIdx)
+		if G_HandlingBinEventIndex.EventIdx == eventIdx {
+			G_HandlingBinEventIndex.outputSql(cfg, sqlForPrint)
+			G_HandlingBinEventIndex.EventIdx++
 			G_HandlingBinEventIndex.lock.Unlock()
-			time.Sleep(1 * time.Microsecond)
+			//fmt.Println("handing index == binevent index, break")
+			break
 		}
+		G_HandlingBinEventIndex.lock.Unlock()
+		time.Sleep(1 * time.Microsecond)
+	}
+}
+
+// outputSql 按顺序输出，调用时持有 lock 。
+// -insert-rows 大于 1 时，同一事务中同一个表的连续 insert 先合并，凑满一条语句、遇到其他 sql 或者事务结束时再输出
+func (this *BinEventHandlingIndx) outputSql(cfg *ConfCmd, sqlForPrint ForwardRollbackSqlOfPrint) {
+	if cfg.InsertRows <= 1 {
+		if !sqlForPrint.trxEnd || sqlForPrint.checkpoint != nil {
+			EmitForwardRollbackSql(cfg, sqlForPrint)
+		}
+		return
+	}
+
+	if this.pendingInsert != nil && this.pendingInsert.canMergeInsert(sqlForPrint) {
+		this.pendingInsert.insertRows.rows = append(this.pendingInsert.insertRows.rows, sqlForPrint.insertRows.rows...)
+		this.pendingInsert.sqlInfo.endpos = sqlForPrint.sqlInfo.endpos
+		this.emitPendingInsert(cfg, false)
+		return
+	}
+
+	this.emitPendingInsert(cfg, true)
+	if sqlForPrint.insertRows != nil {
+		this.pendingInsert = &sqlForPrint
+		this.emitPendingInsert(cfg, false)
+	} else if !sqlForPrint.trxEnd || sqlForPrint.checkpoint != nil {
+		EmitForwardRollbackSql(cfg, sqlForPrint)
+	}
+}
+
+// emitPendingInsert 输出合并中的 insert 。all 为 false 时只输出已经凑满的语句，剩下的行继续等待合并
+func (this *BinEventHandlingIndx) emitPendingInsert(cfg *ConfCmd, all bool) {
+	pending := this.pendingInsert
+	if pending == nil {
+		return
+	}
+	insRows := pending.insertRows
+	start, sqlArr := 0, []string{}
+	for start < len(insRows.rows) {
+		end := insRows.batchEnd(start, cfg.InsertRows, cfg.MaxStatementBytes)
+		// 最后一条语句没有凑满，可能还能合并后面的行
+		if !all && end == len(insRows.rows) && end-start < cfg.InsertRows {
+			break
+		}
+		sqlArr = append(sqlArr, insRows.joinRows(insRows.rows[start:end], cfg.WorkType == "rollback"))
+		start = end
+	}
+	if len(sqlArr) > 0 {
+		sc := *pending
+		sc.sqls = sqlArr
+		sc.insertRows = nil
+		EmitForwardRollbackSql(cfg, sc)
+	}
+	if start == len(insRows.rows) {
+		this.pendingInsert = nil
+	} else {
+		insRows.rows = insRows.rows[start:]
+	}
+}
+
+// FlushPendingInsert 所有事件都处理完之后，输出还在合并中的 insert
+func (this *BinEventHandlingIndx) FlushPendingInsert(cfg *ConfCmd) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.emitPendingInsert(cfg, true)
+}
+
+// canMergeInsert sqlForPrint 的 insert 能否合并到 this 中：同一个事务、同一个表、同样的语句头和原始语句
+func (this *ForwardRollbackSqlOfPrint) canMergeInsert(sqlForPrint ForwardRollbackSqlOfPrint) bool {
+	if this.insertRows == nil || sqlForPrint.insertRows == nil {
+		return false
+	}
+	a, b := this.sqlInfo, sqlForPrint.sqlInfo
+	return a.trxIndex == b.trxIndex && a.binlog == b.binlog && a.schema == b.schema && a.table == b.table &&
+		a.rowsQuery == b.rowsQuery && this.insertRows.head == sqlForPrint.insertRows.head &&
+		this.insertRows.tail == sqlForPrint.insertRows.tail
+}
+
+// EmitForwardRollbackSql 输出到屏幕或者交给写文件线程
+func EmitForwardRollbackSql(cfg *ConfCmd, sqlForPrint ForwardRollbackSqlOfPrint) {
+	// 输出到屏幕
+	if cfg.OutputToScreen {
+		if sqlForPrint.checkpoint != nil {
+			return
+		}
+		if sqlForPrint.sqlInfo.rowsQuery != "" {
+			fmt.Println(GetRowsQueryCommentLine(sqlForPrint.sqlInfo.rowsQuery))
+		}
+		for _, sql := range sqlForPrint.sqls {
+			fmt.Println(sql)
+		}
+	// 输出到管道
+	} else {
+		cfg.SqlChan <- sqlForPrint
 	}
-	log.Infof(fmt.Sprintf("exit thread %d to generate redo/rollback sql", i))
 }
 
 func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
@@ -273,9 +430,33 @@ func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
 		lastPrintPos       uint32             = 0
 		lastPrintFile      string             = ""
 		printBytesInterval uint32             = 1024 * 1024 * 10 //every 10MB print process info
+		fileSizes          map[string]int64   = map[string]int64{} // 已写入各个文件的字节数，用于 checkpoint
+		lastCheckpoint     *Checkpoint                             // 还没有写入文件的 checkpoint
+		lastCheckpointTime time.Time
 	)
+	// -resume 时沿用之前写过的文件
+	if cfg.ResumeCheckpoint != nil {
+		for fn, size := range cfg.ResumeCheckpoint.Files {
+			fileSizes[fn] = size
+		}
+	}
 	log.Infof(fmt.Sprintf("start thread to write redo/rollback sql into file"))
 	for sc := range cfg.SqlChan {
+		// 事务结束，此时 fileSizes 就是该事务的 sql 全部写完时各个文件的大小
+		if sc.checkpoint != nil {
+			lastCheckpoint = sc.checkpoint
+			lastCheckpoint.Files = map[string]int64{}
+			for fn, size := range fileSizes {
+				lastCheckpoint.Files[fn] = size
+			}
+			if time.Since(lastCheckpointTime) >= CheckpointInterval {
+				FlushSqlFilesAndWriteCheckpoint(cfg.CheckpointFile, lastCheckpoint, fhArrBuf)
+				lastCheckpoint = nil
+				lastCheckpointTime = time.Now()
+			}
+			continue
+		}
+
 		if cfg.WorkType == "rollback" {
 			tmpFileName = GetForwardRollbackSqlFileName(sc.sqlInfo.schema, sc.sqlInfo.table, cfg.FilePerTable, cfg.OutputDir, true, sc.sqlInfo.binlog, true)
 			rollbackFileName = GetForwardRollbackSqlFileName(sc.sqlInfo.schema, sc.sqlInfo.table, cfg.FilePerTable, cfg.OutputDir, true, sc.sqlInfo.binlog, false)
@@ -283,7 +464,7 @@ func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
 			tmpFileName = GetForwardRollbackSqlFileName(sc.sqlInfo.schema, sc.sqlInfo.table, cfg.FilePerTable, cfg.OutputDir, false, sc.sqlInfo.binlog, false)
 		}
 		if _, ok := fhArr[tmpFileName]; !ok {
-			FH, err = os.OpenFile(tmpFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			FH, err = OpenSqlResultFile(tmpFileName, fileSizes)
 			if err != nil {
 
 			}
@@ -297,8 +478,9 @@ func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
 		}
 
 		//lastTrxIndex = sc.sqlInfo.trxIndex
-		oneSqls = GetForwardRollbackContentLineWithExtra(sc, cfg.PrintExtraInfo)
+		oneSqls = GetForwardRollbackContentLineWithExtra(sc, cfg.PrintExtraInfo, cfg.WorkType == "rollback")
 		fhArrBuf[tmpFileName].WriteString(oneSqls)
+		fileSizes[tmpFileName] += int64(len(oneSqls))
 		if lastPrintFile == "" {
 			lastPrintFile = sc.sqlInfo.binlog
 		}
@@ -321,6 +503,11 @@ func PrintExtraInfoForForwardRollbackupSql(cfg *ConfCmd, wg *sync.WaitGroup) {
 		fhArr[fn].Close()
 	}
 
+	// 最后一个完整事务的 checkpoint
+	if lastCheckpoint != nil {
+		FlushSqlFilesAndWriteCheckpoint(cfg.CheckpointFile, lastCheckpoint, fhArrBuf)
+	}
+
 	// reverse rollback sql file
 	if cfg.WorkType == "rollback" {
 		log.Info("finish writing rollback sql into tmp files, start to revert content order of tmp files")
@@ -375,15 +562,29 @@ func GetForwardRollbackSqlFileName(schema string, table string, filePerTable boo
 
 }
 
-func GetForwardRollbackContentLineWithExtra(sq ForwardRollbackSqlOfPrint, ifExtra bool) string {
+// GetForwardRollbackContentLineWithExtra ifRollback 时写入的是 tmp 文件，之后会逐行倒序，
+// 所以原始语句的注释写在 sql 后面，倒序后就在 sql 上面了
+func GetForwardRollbackContentLineWithExtra(sq ForwardRollbackSqlOfPrint, ifExtra bool, ifRollback bool) string {
+	var str string
 	if ifExtra {
-		return fmt.Sprintf("# datetime=%s database=%s table=%s binlog=%s startpos=%d stoppos=%d\n%s;\n",
+		str = fmt.Sprintf("# datetime=%s database=%s table=%s binlog=%s startpos=%d stoppos=%d\n%s;\n",
 			sq.sqlInfo.datetime, sq.sqlInfo.schema, sq.sqlInfo.table, sq.sqlInfo.binlog, sq.sqlInfo.startpos,
 			sq.sqlInfo.endpos, strings.Join(sq.sqls, ";\n"))
 	} else {
+		str = strings.Join(sq.sqls, ";\n") + ";\n"
+	}
 
-		str := strings.Join(sq.sqls, ";\n") + ";\n"
-		return str
+	if sq.sqlInfo.rowsQuery != "" {
+		if ifRollback {
+			str = str + GetRowsQueryCommentLine(sq.sqlInfo.rowsQuery) + "\n"
+		} else {
+			str = GetRowsQueryCommentLine(sq.sqlInfo.rowsQuery) + "\n" + str
+		}
 	}
+	return str
+}
 
+// GetRowsQueryCommentLine 原始语句作为单行注释输出，其中的换行替换为空格
+func GetRowsQueryCommentLine(rowsQuery string) string {
+	return "# rows_query: " + strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(rowsQuery)
 }
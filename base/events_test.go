@@ -0,0 +1,178 @@
+package base
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestInsert(trxIndex uint64, table string, rows ...string) ForwardRollbackSqlOfPrint {
+	return ForwardRollbackSqlOfPrint{
+		sqlInfo:    ExtraSqlInfoOfPrint{schema: "db", table: table, binlog: "mysql-bin.000001", trxIndex: trxIndex},
+		insertRows: &InsertRows{head: "I " + table + " ", rows: rows},
+	}
+}
+
+func newTestSql(trxIndex uint64, sql string) ForwardRollbackSqlOfPrint {
+	return ForwardRollbackSqlOfPrint{
+		sqls:    []string{sql},
+		sqlInfo: ExtraSqlInfoOfPrint{schema: "db", table: "t", binlog: "mysql-bin.000001", trxIndex: trxIndex},
+	}
+}
+
+func newTestTrxEnd(trxIndex uint64, checkpoint bool) ForwardRollbackSqlOfPrint {
+	sc := ForwardRollbackSqlOfPrint{
+		sqlInfo: ExtraSqlInfoOfPrint{binlog: "mysql-bin.000001", trxIndex: trxIndex},
+		trxEnd:  true,
+	}
+	if checkpoint {
+		sc.checkpoint = &Checkpoint{Binlog: "mysql-bin.000001"}
+	}
+	return sc
+}
+
+// drainSqlChan 取出已经输出的 sql ，checkpoint 记为 checkpoint
+func drainSqlChan(cfg *ConfCmd) []string {
+	var arr []string
+	for {
+		select {
+		case sc := <-cfg.SqlChan:
+			if sc.checkpoint != nil {
+				arr = append(arr, "checkpoint")
+			} else {
+				arr = append(arr, strings.Join(sc.sqls, "; "))
+			}
+		default:
+			return arr
+		}
+	}
+}
+
+func TestOutputSqlMergeInserts(t *testing.T) {
+	cases := []struct {
+		name     string
+		workType string
+		maxBytes int
+		sqls     []ForwardRollbackSqlOfPrint
+		want     []string
+	}{
+		{
+			name: "row count limit",
+			sqls: []ForwardRollbackSqlOfPrint{
+				newTestInsert(1, "t", "(1)", "(2)"),
+				newTestInsert(1, "t", "(3)", "(4)"),
+				newTestInsert(1, "t", "(5)"),
+				newTestTrxEnd(1, false),
+			},
+			want: []string{"I t (1), (2), (3)", "I t (4), (5)"},
+		},
+		{
+			name: "one rows event longer than the limit",
+			sqls: []ForwardRollbackSqlOfPrint{
+				newTestInsert(1, "t", "(1)", "(2)", "(3)", "(4)", "(5)", "(6)", "(7)"),
+				newTestTrxEnd(1, false),
+			},
+			want: []string{"I t (1), (2), (3); I t (4), (5), (6)", "I t (7)"},
+		},
+		{
+			name:     "byte limit",
+			maxBytes: len("I t (1), (2)"),
+			sqls: []ForwardRollbackSqlOfPrint{
+				newTestInsert(1, "t", "(1)", "(2)"),
+				newTestInsert(1, "t", "(333333333333)", "(4)"),
+				newTestTrxEnd(1, false),
+			},
+			want: []string{"I t (1), (2); I t (333333333333)", "I t (4)"},
+		},
+		{
+			name: "flushed by other sql",
+			sqls: []ForwardRollbackSqlOfPrint{
+				newTestInsert(1, "t", "(1)"),
+				newTestSql(1, "U t"),
+				newTestInsert(1, "t", "(2)"),
+				newTestTrxEnd(1, true),
+			},
+			want: []string{"I t (1)", "U t", "I t (2)", "checkpoint"},
+		},
+		{
+			name: "flushed by other table",
+			sqls: []ForwardRollbackSqlOfPrint{
+				newTestInsert(1, "t", "(1)"),
+				newTestInsert(1, "t2", "(2)"),
+				newTestInsert(1, "t2", "(3)"),
+				newTestInsert(1, "t", "(4)"),
+			},
+			want: []string{"I t (1)", "I t2 (2), (3)", "I t (4)"},
+		},
+		{
+			name: "flushed by other transaction",
+			sqls: []ForwardRollbackSqlOfPrint{
+				newTestInsert(1, "t", "(1)"),
+				newTestInsert(2, "t", "(2)"),
+			},
+			want: []string{"I t (1)", "I t (2)"},
+		},
+		{
+			name:     "reversed for rollback",
+			workType: "rollback",
+			sqls: []ForwardRollbackSqlOfPrint{
+				newTestInsert(1, "t", "(1)", "(2)"),
+				newTestInsert(1, "t", "(3)", "(4)"),
+				newTestTrxEnd(1, false),
+			},
+			want: []string{"I t (3), (2), (1)", "I t (4)"},
+		},
+	}
+
+	for _, c := range cases {
+		cfg := &ConfCmd{
+			WorkType:          "2sql",
+			InsertRows:        3,
+			MaxStatementBytes: 1024,
+			SqlChan:           make(chan ForwardRollbackSqlOfPrint, 100),
+		}
+		if c.workType != "" {
+			cfg.WorkType = c.workType
+		}
+		if c.maxBytes > 0 {
+			cfg.MaxStatementBytes = c.maxBytes
+		}
+		handler := &BinEventHandlingIndx{}
+		for _, sc := range c.sqls {
+			handler.outputSql(cfg, sc)
+		}
+		// 最后一个事件之后还在合并的 insert
+		handler.FlushPendingInsert(cfg)
+		if got := drainSqlChan(cfg); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\n got: %q\nwant: %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestOutputSqlPendingInsert(t *testing.T) {
+	cfg := &ConfCmd{
+		WorkType:          "2sql",
+		InsertRows:        3,
+		MaxStatementBytes: 1024,
+		SqlChan:           make(chan ForwardRollbackSqlOfPrint, 100),
+	}
+	handler := &BinEventHandlingIndx{}
+
+	// 没有凑满时等待合并，不输出
+	handler.outputSql(cfg, newTestInsert(1, "t", "(1)", "(2)"))
+	if got := drainSqlChan(cfg); len(got) != 0 {
+		t.Errorf("got %q before the statement is full", got)
+	}
+	// 凑满的语句先输出，剩下的行继续等待
+	handler.outputSql(cfg, newTestInsert(1, "t", "(3)", "(4)"))
+	if got := drainSqlChan(cfg); !reflect.DeepEqual(got, []string{"I t (1), (2), (3)"}) {
+		t.Errorf("got %q", got)
+	}
+	if handler.pendingInsert == nil || !reflect.DeepEqual(handler.pendingInsert.insertRows.rows, []string{"(4)"}) {
+		t.Errorf("got pending insert %v, want (4)", handler.pendingInsert)
+	}
+	handler.FlushPendingInsert(cfg)
+	if got := drainSqlChan(cfg); !reflect.DeepEqual(got, []string{"I t (4)"}) || handler.pendingInsert != nil {
+		t.Errorf("got %q after flush", got)
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/go-mysql-org/go-mysql/replication"
 	"github.com/juju/errors"
 	"github.com/siddontang/go-log/log"
-	toolkits "my2sql/toolkits"
 )
 
 var (
@@ -22,6 +21,23 @@ var (
 
 type BinFileParser struct {
 	Parser *replication.BinlogParser
+
+	stopChan      chan struct{}  // -follow 模式下收到退出信号时关闭
+	payload       *PayloadParser // 解析 TRANSACTION_PAYLOAD_EVENT
+	corruptReport *CorruptReport // -on-corrupt=skip|report 时记录损坏的字节范围
+
+	eventIdx *uint64             // 已发送的事件数，顺序解析时指向 fileBinEventHandlingIndex
+	trxIdx   *uint64             // 已解析的事务数，顺序解析时指向 fileTrxIndex
+	out      chan<- parsedItem   // -parse-threads > 1 时解析结果先发到这里，再按文件顺序转发
+	quit     <-chan struct{}     // -parse-threads > 1 时后面的文件不再需要解析时关闭
+}
+
+// NewFileBinlogParser 创建 file/stdin 模式下解析事件的 parser ，并行解析时每个文件使用单独的 parser
+func NewFileBinlogParser() *replication.BinlogParser {
+	psr := replication.NewBinlogParser()
+	psr.SetParseTime(false)  // do not parse mysql datetime/time column into go time structure, take it as string
+	psr.SetUseDecimal(true) // decimal 列解析为 decimal.Decimal ，按原样输出全部数字
+	return psr
 }
 
 // [root@10-186-61-119 binlog]# ll
@@ -41,15 +57,25 @@ func (this BinFileParser) MyParseAllBinlogFiles(cfg *ConfCmd) {
 
 	// 提取配置：binlog 文件名、binlog 位置偏移
 	binlog, binpos := GetFirstBinlogPosToParse(cfg)
-
-	// 将 mysql3306-bin.000004 解析成 mysql3306-bin, 4
-	binBaseName, binBaseIndx := GetBinlogBasenameAndIndex(binlog)
 	log.Info(fmt.Sprintf("start to parse %s %d\n", binlog, binpos))
 
+	if cfg.Follow {
+		this.stopChan = NotifyFollowStop()
+	}
+	this.initParsers(cfg)
+	defer this.closeCorruptReport()
+
+	// 多个文件并行解析，只解析一个文件时没有必要
+	if cfg.ParseThreads > 1 && !cfg.IfParseOneBinlogFile() {
+		this.MyParseBinlogFilesParallel(cfg, binlog)
+		log.Info("finish parsing binlog from local files")
+		return
+	}
+
 	for {
 		// 如果设置了 stop pos ，读到指定位置会自动停止
 		if cfg.IfSetStopFilePos {
-			if cfg.StopFilePos.Compare(mysql.Position{Name: filepath.Base(binlog), Pos: 4}) < 1 {
+			if cfg.ComparePosition(cfg.StopFilePos, mysql.Position{Name: GetBinlogName(binlog), Pos: 4}) < 1 {
 				break
 			}
 		}
@@ -65,17 +91,21 @@ func (this BinFileParser) MyParseAllBinlogFiles(cfg *ConfCmd) {
 			break
 		// 文件尾
 		} else if result == C_reFileEnd {
-			if !cfg.IfSetStopParsPoint && !cfg.IfSetStopDateTime {
+			if cfg.IfParseOneBinlogFile() {
 				//just parse one binlog
 				break
 			}
+			// -follow 模式下收到了退出信号
+			if cfg.Follow && IsFollowStopped(this.stopChan) {
+				break
+			}
 			// 继续解析下一个 binlog 文件
-			binlog = filepath.Join(cfg.BinlogDir, GetNextBinlog(binBaseName, binBaseIndx))
-			if !toolkits.IsFile(binlog) {
-				log.Info(fmt.Sprintf("%s not exists nor a file\n", binlog))
+			nextBinlog := this.GetNextBinlogFile(cfg, binlog)
+			if nextBinlog == "" {
+				log.Info(fmt.Sprintf("no more binlog file after %s\n", binlog))
 				break
 			}
-			binBaseIndx++ 	// 索引下标 +1
+			binlog = nextBinlog
 			binpos = 4		// 重置 offset
 		} else {
 			log.Info(fmt.Sprintf("this should not happen: return value of MyParseOneBinlog is %d\n", result))
@@ -85,6 +115,72 @@ func (this BinFileParser) MyParseAllBinlogFiles(cfg *ConfCmd) {
 	log.Info("finish parsing binlog from local files")
 }
 
+// MyParseStdin 解析从标准输入读取的一个 binlog 流，如 ssh host cat mysql-bin.000123 | my2sql -mode=stdin 。
+// 标准输入不能 seek ，只能从头读取，-start-file/-start-pos 之前的事件读取后被过滤掉。
+func (this BinFileParser) MyParseStdin(cfg *ConfCmd) {
+	defer cfg.CloseChan()
+	log.Info("start to parse binlog from stdin")
+
+	this.initParsers(cfg)
+	defer this.closeCorruptReport()
+
+	// 校验 4B 文件头
+	b := make([]byte, len(replication.BinLogFileHeader))
+	if _, err := io.ReadFull(os.Stdin, b); err != nil {
+		log.Error(fmt.Sprintf("fail to read binlog file header from stdin %v", err))
+		return
+	} else if !bytes.Equal(b, replication.BinLogFileHeader) {
+		log.Error("stdin is not a valid binlog stream, head 4 bytes must fe'bin' ")
+		return
+	}
+
+	// 没有指定 -binlog-name 时，MyParseReader 从开头的 ROTATE_EVENT 中获取文件名
+	binlog := cfg.BinlogName
+	if _, err := this.MyParseReader(cfg, os.Stdin, &binlog); err != nil {
+		log.Error(fmt.Sprintf("error to parse binlog from stdin %v", err))
+	}
+	log.Info("finish parsing binlog from stdin")
+}
+
+// initParsers 初始化解析 payload 事件的 parser ，以及 -on-corrupt=skip|report 时的损坏报告
+func (this *BinFileParser) initParsers(cfg *ConfCmd) {
+	this.payload = NewPayloadParser(nil)
+	this.eventIdx = &fileBinEventHandlingIndex
+	this.trxIdx = &fileTrxIndex
+	if cfg.OnCorrupt != C_onCorruptAbort {
+		report, err := NewCorruptReport(cfg.OutputDir)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		this.corruptReport = report
+	}
+}
+
+func (this *BinFileParser) closeCorruptReport() {
+	if this.corruptReport != nil {
+		this.corruptReport.Close()
+	}
+}
+
+// GetNextBinlogFile 返回 binlog 的下一个文件，不存在时返回空。
+// 指定了 -binlog-index/-binlog-files 时从文件列表中获取，否则按序号推算下一个文件名。
+func (this BinFileParser) GetNextBinlogFile(cfg *ConfCmd, binlog string) string {
+	if cfg.BinlogFileList != nil {
+		// -follow 模式下可能生成了新的 binlog 文件
+		if cfg.Follow {
+			if err := cfg.BinlogFileList.Reload(); err != nil {
+				log.Errorf("fail to reload binlog files %v", err)
+			}
+		}
+		return cfg.BinlogFileList.Next(binlog)
+	}
+
+	// 将 mysql3306-bin.000004 解析成 mysql3306-bin, 4
+	binBaseName, binBaseIndx := GetBinlogBasenameAndIndex(GetBinlogName(binlog))
+	// 下一个文件可能是压缩过的
+	return FindBinlogFile(filepath.Dir(binlog), GetNextBinlog(binBaseName, binBaseIndx))
+}
+
 func (this BinFileParser) MyParseOneBinlogFile(cfg *ConfCmd, name string) (int, error) {
 	// process: 0, continue: 1, break: 2
 	// 打开文件
@@ -96,10 +192,23 @@ func (this BinFileParser) MyParseOneBinlogFile(cfg *ConfCmd, name string) (int,
 		log.Error(fmt.Sprintf("fail to open %s %v\n", name, err))
 		return C_reBreak, errors.Trace(err)
 	}
+	// 压缩过的 binlog 边读边解压
+	r, compressType, closeReader, err := NewBinlogFileReader(f)
+	if err != nil {
+		log.Error(fmt.Sprintf("fail to open %s %v\n", name, err))
+		return C_reBreak, errors.Trace(err)
+	}
+	defer closeReader()
+	// -follow 模式下等待正在写入的内容，压缩过的 binlog 都是已经写完的
+	if cfg.Follow && compressType == C_compressNone {
+		r = NewFollowReader(f, func() bool {
+			return this.GetNextBinlogFile(cfg, name) != ""
+		}, this.stopChan)
+	}
 	// 读取文件类型
 	fileTypeBytes := int64(4)
 	b := make([]byte, fileTypeBytes)
-	if _, err = f.Read(b); err != nil {
+	if _, err = io.ReadFull(r, b); err != nil {
 		log.Error(fmt.Sprintf("fail to read %s %v", name, err))
 		return C_reBreak, errors.Trace(err)
 	} else if !bytes.Equal(b, replication.BinLogFileHeader) {
@@ -108,14 +217,16 @@ func (this BinFileParser) MyParseOneBinlogFile(cfg *ConfCmd, name string) (int,
 		return C_reBreak, errors.Trace(err)
 	}
 	// must not seek to other position, otherwise the program may panic because formatevent, table map event is skipped
-	// 跳过 4B
-	if _, err = f.Seek(fileTypeBytes, os.SEEK_SET); err != nil {
-		log.Error(fmt.Sprintf("error seek %s to %d", name, fileTypeBytes))
-		return C_reBreak, errors.Trace(err)
+	// 跳过 4B ，压缩文件不能 seek ，已经读过了 4B
+	if compressType == C_compressNone {
+		if _, err = f.Seek(fileTypeBytes, os.SEEK_SET); err != nil {
+			log.Error(fmt.Sprintf("error seek %s to %d", name, fileTypeBytes))
+			return C_reBreak, errors.Trace(err)
+		}
 	}
-	// 执行解析
-	var binlog string = filepath.Base(name)
-	return this.MyParseReader(cfg, f, &binlog)
+	// 执行解析，binlog 名去掉压缩扩展名
+	var binlog string = GetBinlogName(name)
+	return this.MyParseReader(cfg, r, &binlog)
 }
 
 
@@ -123,7 +234,6 @@ func (this BinFileParser) MyParseReader(cfg *ConfCmd, r io.Reader, binlog *strin
 	// process: 0, continue: 1, break: 2, EOF: 3
 	var (
 		err         error
-		n           int64
 		db          string = ""
 		tb          string = ""
 		sql         string = ""
@@ -132,96 +242,47 @@ func (this BinFileParser) MyParseReader(cfg *ConfCmd, r io.Reader, binlog *strin
 		trxStatus   int    = 0
 		sqlLower    string = ""
 		tbMapPos    uint32 = 0	//
+		rowsQuery   string = ""	// 当前语句的原始 sql
+
+		er *BinlogEventReader = NewBinlogEventReader(r, binlog, cfg.OnCorrupt, this.corruptReport)
 	)
 
 	for {
-		// 读取 19B 事件头
-		headBuf := make([]byte, replication.EventHeaderSize)
-		if _, err = io.ReadFull(r, headBuf); err == io.EOF {
+		// 读取一个完整的事件，校验 checksum
+		var (
+			h       *replication.EventHeader
+			rawData []byte
+		)
+		h, rawData, err = er.ReadEvent()
+		if err == io.EOF {
 			return C_reFileEnd, nil
 		} else if err != nil {
-			log.Error(fmt.Sprintf("fail to read binlog event header of %s %v", *binlog, err))
-			return C_reBreak, errors.Trace(err)
-		}
-		// 解析事件头
-		var h *replication.EventHeader
-		h, err = this.Parser.ParseHeader(headBuf)
-		if err != nil {
-			log.Error(fmt.Sprintf("fail to parse binlog event header of %s %v" , *binlog, err))
+			log.Error(fmt.Sprintf("fail to read binlog event of %s %v", *binlog, err))
 			return C_reBreak, errors.Trace(err)
 		}
-		//fmt.Printf("parsing %s %d %s\n", *binlog, h.LogPos, GetDatetimeStr(int64(h.Timestamp), int64(0), DATETIME_FORMAT))
-		// 校验
-		if h.EventSize <= uint32(replication.EventHeaderSize) {
-			err = errors.Errorf("invalid event header, event size is %d, too small", h.EventSize)
-			log.Error("%v", err)
-			return C_reBreak, err
-		}
-		// 读取 size 事件体
-		var buf bytes.Buffer
-		if n, err = io.CopyN(&buf, r, int64(h.EventSize)-int64(replication.EventHeaderSize)); err != nil {
-			err = errors.Errorf("get event body err %v, need %d - %d, but got %d", err, h.EventSize, replication.EventHeaderSize, n)
-			log.Error("%v", err)
-			return C_reBreak, err
-		}
-
-		//h.Dump(os.Stdout)
-		// 拼接 head 和 body
-		data := buf.Bytes()
-		var rawData []byte
-		rawData = append(rawData, headBuf...)
-		rawData = append(rawData, data...)
-		// 校验
-		eventLen := int(h.EventSize) - replication.EventHeaderSize
-		if len(data) != eventLen {
-			err = errors.Errorf("invalid data size %d in event %s, less event length %d", len(data), h.EventType, eventLen)
-			log.Errorf("%v", err)
-			return C_reBreak, err
-		}
 
 		// 解析事件体
 		var e replication.Event
-		e, err = this.Parser.ParseEvent(h, data, rawData)
+		e, err = this.Parser.ParseEvent(h, rawData[replication.EventHeaderSize:], rawData)
 		if err != nil {
+			// 损坏的事件之后，table map 可能已经丢失
+			if err = er.OnDamagedEvent(h, fmt.Sprintf("fail to parse %s: %v", h.EventType, err)); err == nil {
+				continue
+			}
 			log.Error(fmt.Sprintf("fail to parse binlog event body of %s %v",*binlog, err))
 			return C_reBreak, errors.Trace(err)
 		}
 
-		// 基于 ROW 格式的 MySQL Binlog 在记录 DML 语句的数据时，总会先写入一个 table_map_event ，
-		// 这种类型的 event 用于记录表结构相关元数据信息，比如数据库名称，表名称，表的字段类型，表的字段元数据等等。
-		//
-		// TABLE_MAP_EVENT 只有在 binlog 文件是以 ROW 格式记录的时候，才会使用。
-		// binlog 中记录的每个更改的记录之前都会有一个对应要操作的表的 TABLE_MAP_EVENT 。
-		// TABLE_MAP_EVENT 中记录了表的定义（包括数据库名称，表名称，表的字段类型定义），
-		// 并且会将这个表的定义对应于一个数字，称为 table_id 。
-		//
-		// 设计 TABLE_MAP_EVENT 类型 event 的目的是为了当主库和从库之间有不同的表定义的时候，复制仍能进行。
-		// 如果一个事务中操作了多个表，多行记录，在 binlog 中会将对多行记录的操作 event 进行分组，
-		// 每组行记录操作 event 前面会出现对应表的 TABLE_MAP_EVENT 。
-		//
-		if h.EventType == replication.TABLE_MAP_EVENT {
-			// ???
-			tbMapPos = h.LogPos - h.EventSize // avoid mysqlbing mask the row event as unknown table row event
-		}
-
-		//e.Dump(os.Stdout)
-		// can not advance this check, because we need to parse table map event or table may not found.
-		// Also we must seek ahead the read file position
-		//
-		// 不能提前进行这个检查，因为我们需要解析表映射事件，否则可能找不到表。
-		// 另外，我们必须提前寻找读取文件的位置。
-
-		// 检查当前 event 是否应该被处理
-		chRe := CheckBinHeaderCondition(cfg, h, *binlog)
-		if chRe == C_reBreak {
-			// 结束
-			return C_reBreak, nil
-		} else if chRe == C_reContinue {
-			// 忽略，继续
+		// 标准输入读取的 binlog 流没有文件名，取开头的 ROTATE_EVENT 中的文件名
+		if *binlog == "" {
+			if h.EventType != replication.ROTATE_EVENT {
+				err = errors.Errorf("binlog name is unknown, the stream does not start with a ROTATE event, please specify -binlog-name")
+				log.Errorf("%v", err)
+				return C_reBreak, err
+			}
+			*binlog = string(e.(*replication.RotateEvent).NextLogName)
+			log.Infof("binlog name of the stream is %s", *binlog)
 			continue
-		} else if chRe == C_reFileEnd {
-			// 文件尾
-			return C_reFileEnd, nil
 		}
 
 		//binEvent := &replication.BinlogEvent{RawData: rawData, Header: h, Event: e}
@@ -233,111 +294,210 @@ func (this BinFileParser) MyParseReader(cfg *ConfCmd, r io.Reader, binlog *strin
 			// RawData: rawData, // we donnot need raw data
 		}
 
-		oneMyEvent := &MyBinEvent{
-			MyPos: mysql.Position{
-				Name: *binlog,
-				Pos: h.LogPos,
-			},
-			StartPos: tbMapPos,
+		// payload 中的事件要用当前文件的 FORMAT_DESCRIPTION_EVENT 解析
+		if h.EventType == replication.FORMAT_DESCRIPTION_EVENT {
+			if err = this.payload.OnFormatDescriptionEvent(rawData); err != nil {
+				log.Error(fmt.Sprintf("fail to parse format description event of %s %v", *binlog, err))
+				return C_reBreak, errors.Trace(err)
+			}
 		}
 
-		//StartPos: h.LogPos - h.EventSize}
-		// 解析当前 event ，得到 RowsEvent 后保存到 oneMyEvent.BinEvent 上。
-		chRe = oneMyEvent.CheckBinEvent(cfg, binEvent, binlog)
-		if chRe == C_reBreak {
-			// 停止遍历(时间区间非法)
-			return C_reBreak, nil
-		} else if chRe == C_reContinue {
-			// 继续遍历(过滤)
-			continue
-		} else if chRe == C_reFileEnd {
-			// 停止遍历(文件尾)
-			return C_reFileEnd, nil
+		// TRANSACTION_PAYLOAD_EVENT 解压后逐个处理其中的事件，其它事件只处理自身
+		binEvents := []*replication.BinlogEvent{binEvent}
+		if h.EventType == C_transactionPayloadEvent {
+			binEvents, err = this.payload.Decode(binEvent)
+			if err != nil {
+				if err = er.OnDamagedEvent(h, fmt.Sprintf("fail to decode transaction payload: %v", err)); err == nil {
+					continue
+				}
+				log.Error(fmt.Sprintf("fail to decode transaction payload event of %s %d %v", *binlog, h.LogPos, err))
+				return C_reBreak, errors.Trace(err)
+			}
 		}
 
-		// 库, 表, 类型, 语句, 行数目
-		db, tb, sqlType, sql, rowCnt = GetDbTbAndQueryAndRowCntFromBinevent(binEvent)
-
-		// 查询
-		if sqlType == "query" {
-			sqlLower = strings.ToLower(sql)
-			// 事务
-			if sqlLower == "begin" {
-				trxStatus = C_trxBegin
-				fileTrxIndex++	// 事务号
-			} else if sqlLower == "commit" {
-				trxStatus = C_trxCommit
-			} else if sqlLower == "rollback" {
-				trxStatus = C_trxRollback
-			} else if oneMyEvent.QuerySql != nil {
-				trxStatus = C_trxProcess
-				rowCnt = 1
+		for _, binEvent := range binEvents {
+			h := binEvent.Header
+
+			// 基于 ROW 格式的 MySQL Binlog 在记录 DML 语句的数据时，总会先写入一个 table_map_event ，
+			// 这种类型的 event 用于记录表结构相关元数据信息，比如数据库名称，表名称，表的字段类型，表的字段元数据等等。
+			//
+			// TABLE_MAP_EVENT 只有在 binlog 文件是以 ROW 格式记录的时候，才会使用。
+			// binlog 中记录的每个更改的记录之前都会有一个对应要操作的表的 TABLE_MAP_EVENT 。
+			// TABLE_MAP_EVENT 中记录了表的定义（包括数据库名称，表名称，表的字段类型定义），
+			// 并且会将这个表的定义对应于一个数字，称为 table_id 。
+			//
+			// 设计 TABLE_MAP_EVENT 类型 event 的目的是为了当主库和从库之间有不同的表定义的时候，复制仍能进行。
+			// 如果一个事务中操作了多个表，多行记录，在 binlog 中会将对多行记录的操作 event 进行分组，
+			// 每组行记录操作 event 前面会出现对应表的 TABLE_MAP_EVENT 。
+			//
+			if h.EventType == replication.TABLE_MAP_EVENT {
+				// ???
+				tbMapPos = h.LogPos - h.EventSize // avoid mysqlbing mask the row event as unknown table row event
 			}
-		} else {
-			trxStatus = C_trxProcess
-		}
 
-		// 任务类型
-		if cfg.WorkType != "stats" {
-
-			// 是否需要发送
-			ifSendEvent := false
-
-			// 当前事件是 INSERT/UPDATE/DELETE 的 rows 事件
-			if oneMyEvent.IfRowsEvent {
-				// 构造库表名 db.tb
-				tbKey := GetAbsTableName(string(oneMyEvent.BinEvent.Table.Schema), string(oneMyEvent.BinEvent.Table.Table))
-				// 查询 db.tb 的表信息(字段、索引)
-				_, err = G_TablesColumnsInfo.GetTableInfoJson(string(oneMyEvent.BinEvent.Table.Schema), string(oneMyEvent.BinEvent.Table.Table))
-				if err != nil {
-					log.Fatalf(fmt.Sprintf("no table struct found for %s, it maybe dropped, skip it. RowsEvent position:%s",
-							tbKey, oneMyEvent.MyPos.String()))
-				}
-				// 需要将当前 event 发送出去
-				ifSendEvent = true
+			// 记录 rows 事件之前的原始语句
+			TrackRowsQuery(binEvent, &rowsQuery)
+
+			//e.Dump(os.Stdout)
+			// can not advance this check, because we need to parse table map event or table may not found.
+			// Also we must seek ahead the read file position
+			//
+			// 不能提前进行这个检查，因为我们需要解析表映射事件，否则可能找不到表。
+			// 另外，我们必须提前寻找读取文件的位置。
+
+			// 检查当前 event 是否应该被处理
+			chRe := CheckBinHeaderCondition(cfg, h, *binlog)
+			if chRe == C_reBreak {
+				// 结束
+				return C_reBreak, nil
+			} else if chRe == C_reContinue {
+				// 忽略，继续
+				continue
+			} else if chRe == C_reFileEnd {
+				// 文件尾
+				return C_reFileEnd, nil
 			}
 
-			// 发送到管道 cfg.EventChan 上
-			if ifSendEvent {
-				fileBinEventHandlingIndex++
-				oneMyEvent.EventIdx = fileBinEventHandlingIndex
-				oneMyEvent.SqlType = sqlType
-				oneMyEvent.Timestamp = h.Timestamp
-				oneMyEvent.TrxIndex = fileTrxIndex
-				oneMyEvent.TrxStatus = trxStatus
-				cfg.EventChan <- *oneMyEvent
+			// -on-corrupt=report 只检查 binlog 是否损坏
+			if cfg.OnCorrupt == C_onCorruptReport {
+				continue
+			}
+
+			oneMyEvent := &MyBinEvent{
+				MyPos: mysql.Position{
+					Name: *binlog,
+					Pos: h.LogPos,
+				},
+				StartPos: tbMapPos,
 			}
-		}
 
-		//output analysis result whatever the WorkType is
-		//
-		//
-		if sqlType != "" {
-			// 查询类型
+			// 在过滤之前记录事务的 GTID 和事务边界
+			trxEnd := false
+			if cfg.TrxTracker != nil {
+				trxEnd = cfg.TrxTracker.ObserveEvent(binEvent)
+			}
+
+			//StartPos: h.LogPos - h.EventSize}
+			// 解析当前 event ，得到 RowsEvent 后保存到 oneMyEvent.BinEvent 上。
+			chRe = oneMyEvent.CheckBinEvent(cfg, binEvent, binlog)
+			if chRe == C_reBreak {
+				// 停止遍历(时间区间非法)
+				return C_reBreak, nil
+			} else if chRe == C_reContinue {
+				// 继续遍历(过滤)，被过滤的事务同样推进 checkpoint
+				if trxEnd && cfg.WorkType != "stats" {
+					*this.eventIdx++
+					if !this.sendEvent(cfg, NewTrxEndEvent(cfg, *this.eventIdx, *binlog, h.LogPos, h.Timestamp)) {
+						return C_reBreak, nil
+					}
+				}
+				continue
+			} else if chRe == C_reFileEnd {
+				// 停止遍历(文件尾)
+				return C_reFileEnd, nil
+			}
+
+			// 库, 表, 类型, 语句, 行数目
+			db, tb, sqlType, sql, rowCnt = GetDbTbAndQueryAndRowCntFromBinevent(binEvent)
+
+			// 查询
 			if sqlType == "query" {
-				// 发送到管道 cfg.StatChan 上
-				cfg.StatChan <- BinEventStats{
-					Timestamp: h.Timestamp,				//
-					Binlog: *binlog,					//
-					StartPos: h.LogPos - h.EventSize, 	// ???
-					StopPos: h.LogPos,
-					Database: db,
-					Table: tb,
-					QuerySql: sql,
-					RowCnt: rowCnt,
-					QueryType: sqlType,
+				sqlLower = strings.ToLower(sql)
+				// 事务
+				if sqlLower == "begin" {
+					trxStatus = C_trxBegin
+					*this.trxIdx++	// 事务号
+				} else if sqlLower == "commit" {
+					trxStatus = C_trxCommit
+				} else if sqlLower == "rollback" {
+					trxStatus = C_trxRollback
+				} else if oneMyEvent.QuerySql != nil {
+					trxStatus = C_trxProcess
+					rowCnt = 1
+				}
+
+				// ddl 修改缓存的表结构，之后的 rows 事件使用新的表结构
+				if cfg.WorkType != "stats" && sqlLower != "begin" && sqlLower != "commit" && sqlLower != "rollback" {
+					if !this.applyDdl(cfg, &QueryDdl{Database: db, Sql: sql, Pos: oneMyEvent.MyPos}) {
+						return C_reBreak, nil
+					}
 				}
 			} else {
-				cfg.StatChan <- BinEventStats{
-					Timestamp: h.Timestamp,
-					Binlog: *binlog,
-					StartPos: tbMapPos,
-					StopPos: h.LogPos,
-					Database: db,
-					Table: tb,
-					QuerySql: sql,
-					RowCnt: rowCnt,
-					QueryType: sqlType,
+				trxStatus = C_trxProcess
+			}
+
+			// 任务类型
+			if cfg.WorkType != "stats" {
+
+				// 是否需要发送
+				ifSendEvent := false
+
+				// 当前事件是 INSERT/UPDATE/DELETE 的 rows 事件，需要将当前 event 发送出去
+				if oneMyEvent.IfRowsEvent {
+					ifSendEvent = true
+				}
+
+				// 发送到管道 cfg.EventChan 上
+				if ifSendEvent {
+					*this.eventIdx++
+					oneMyEvent.EventIdx = *this.eventIdx
+					oneMyEvent.SqlType = sqlType
+					oneMyEvent.Timestamp = h.Timestamp
+					oneMyEvent.TrxIndex = *this.trxIdx
+					oneMyEvent.TrxStatus = trxStatus
+					oneMyEvent.RowsQuery = rowsQuery
+					if !this.sendEvent(cfg, oneMyEvent) {
+						return C_reBreak, nil
+					}
+				}
+
+				// 事务结束，发送事务结束事件。跟踪 checkpoint 时包括 DDL 等单独的语句
+				if cfg.TrxTracker == nil {
+					trxEnd = NeedTrxEndEvent(cfg) && sqlType == "query" && sqlLower == "commit"
+				}
+				if trxEnd {
+					*this.eventIdx++
+					if !this.sendEvent(cfg, NewTrxEndEvent(cfg, *this.eventIdx, *binlog, h.LogPos, h.Timestamp)) {
+						return C_reBreak, nil
+					}
+				}
+			}
+
+			//output analysis result whatever the WorkType is
+			//
+			//
+			if sqlType != "" {
+				// 查询类型
+				var st *BinEventStats
+				if sqlType == "query" {
+					st = &BinEventStats{
+						Timestamp: h.Timestamp,				//
+						Binlog: *binlog,					//
+						StartPos: h.LogPos - h.EventSize, 	// ???
+						StopPos: h.LogPos,
+						Database: db,
+						Table: tb,
+						QuerySql: sql,
+						RowCnt: rowCnt,
+						QueryType: sqlType,
+					}
+				} else {
+					st = &BinEventStats{
+						Timestamp: h.Timestamp,
+						Binlog: *binlog,
+						StartPos: tbMapPos,
+						StopPos: h.LogPos,
+						Database: db,
+						Table: tb,
+						QuerySql: sql,
+						RowCnt: rowCnt,
+						QueryType: sqlType,
+						RowsQuery: rowsQuery,
+					}
+				}
+				// 发送到管道 cfg.StatChan 上
+				if !this.sendStats(cfg, st) {
+					return C_reBreak, nil
 				}
 			}
 		}
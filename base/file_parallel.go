@@ -0,0 +1,174 @@
+package base
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_parseAheadEvents = 10000 // 并行解析时每个文件最多预先解析的事件数，超过后等待前面的文件输出完
+)
+
+// parsedItem 并行解析时一个文件的解析结果，rows 事件和统计信息按解析顺序放在一起
+type parsedItem struct {
+	event *MyBinEvent
+	stats *BinEventStats
+	ddl   *QueryDdl
+}
+
+// parsedFile 一个正在并行解析的 binlog 文件
+type parsedFile struct {
+	name  string
+	items chan parsedItem // 解析完成后关闭，之后才能读取下面的字段
+
+	result   int
+	err      error
+	eventCnt uint64 // 该文件中发送的事件数，EventIdx 从 1 开始编号
+	trxCnt   uint64 // 该文件中的事务数，TrxIndex 从 0 开始编号
+}
+
+// sendEvent 发送 rows 事件或者事务结束事件，并行解析时返回 false 表示不再需要解析
+func (this BinFileParser) sendEvent(cfg *ConfCmd, ev *MyBinEvent) bool {
+	if this.out == nil {
+		CheckTableOfRowsEvent(ev)
+		cfg.EventChan <- *ev
+		return true
+	}
+	select {
+	case this.out <- parsedItem{event: ev}:
+		return true
+	case <-this.quit:
+		return false
+	}
+}
+
+// sendStats 发送统计信息，并行解析时返回 false 表示不再需要解析
+func (this BinFileParser) sendStats(cfg *ConfCmd, st *BinEventStats) bool {
+	if this.out == nil {
+		cfg.StatChan <- *st
+		return true
+	}
+	select {
+	case this.out <- parsedItem{stats: st}:
+		return true
+	case <-this.quit:
+		return false
+	}
+}
+
+// applyDdl 应用 QUERY_EVENT 中的 ddl ，并行解析时返回 false 表示不再需要解析
+func (this BinFileParser) applyDdl(cfg *ConfCmd, qd *QueryDdl) bool {
+	if this.out == nil {
+		G_TablesColumnsInfo.ApplyDdl(qd)
+		return true
+	}
+	select {
+	case this.out <- parsedItem{ddl: qd}:
+		return true
+	case <-this.quit:
+		return false
+	}
+}
+
+// CheckTableOfRowsEvent 获取 db.tb 的表信息(字段、索引)，记录到事件上，找不到时退出。
+// 表结构要按 binlog 的顺序应用 ddl ，并行解析时也只在转发结果的协程中调用。
+func CheckTableOfRowsEvent(ev *MyBinEvent) {
+	if !ev.IfRowsEvent {
+		return
+	}
+	schema, table := string(ev.BinEvent.Table.Schema), string(ev.BinEvent.Table.Table)
+	tbInfo, err := G_TableMetaProvider.GetTableInfo(ev.BinEvent.Table)
+	if err != nil {
+		log.Fatalf(fmt.Sprintf("no table struct found for %s, it maybe dropped, skip it. RowsEvent position:%s",
+			GetAbsTableName(schema, table), ev.MyPos.String()))
+	}
+	ev.TableInfo = tbInfo
+}
+
+// MyParseBinlogFilesParallel 最多 -parse-threads 个 binlog 文件同时解析，每个文件使用单独的 parser 。
+//
+// 每个 binlog 文件以 FORMAT_DESCRIPTION_EVENT 开始，事务也不会跨文件，所以各个文件可以独立解析。
+// 解析结果按文件顺序转发到 cfg.EventChan 和 cfg.StatChan ，EventIdx 和 TrxIndex 加上前面文件的数量，
+// 输出和逐个文件解析完全一致。某个文件结束解析(到达 -stop-*)或者出错时，丢弃后面文件的结果。
+func (this BinFileParser) MyParseBinlogFilesParallel(cfg *ConfCmd, binlog string) {
+	var (
+		order = make(chan *parsedFile, cfg.ParseThreads-1) // 加上正在转发的文件，最多 ParseThreads 个文件同时解析
+		quit  = make(chan struct{})
+		wg    sync.WaitGroup
+	)
+	log.Infof("parse binlog files with %d threads", cfg.ParseThreads)
+
+	// 按顺序列出要解析的文件，每个文件启动一个协程解析
+	go func() {
+		defer close(order)
+		for binlog != "" {
+			if cfg.IfSetStopFilePos {
+				if cfg.ComparePosition(cfg.StopFilePos, mysql.Position{Name: GetBinlogName(binlog), Pos: 4}) < 1 {
+					return
+				}
+			}
+			pf := &parsedFile{name: binlog, items: make(chan parsedItem, C_parseAheadEvents)}
+			select {
+			case order <- pf:
+			case <-quit:
+				return
+			}
+
+			wg.Add(1)
+			go func(pf *parsedFile) {
+				defer wg.Done()
+				defer close(pf.items)
+				worker := this
+				worker.Parser = NewFileBinlogParser()
+				worker.payload = NewPayloadParser(nil)
+				worker.eventIdx = &pf.eventCnt
+				worker.trxIdx = &pf.trxCnt
+				worker.out = pf.items
+				worker.quit = quit
+				log.Info(fmt.Sprintf("start to parse %s %d\n", pf.name, 4))
+				pf.result, pf.err = worker.MyParseOneBinlogFile(cfg, pf.name)
+			}(pf)
+
+			binlog = this.GetNextBinlogFile(cfg, binlog)
+		}
+	}()
+
+	// 按文件顺序转发解析结果
+	for pf := range order {
+		eventBase, trxBase := fileBinEventHandlingIndex, fileTrxIndex
+		for item := range pf.items {
+			if item.event != nil {
+				item.event.EventIdx += eventBase
+				item.event.TrxIndex += trxBase
+				CheckTableOfRowsEvent(item.event)
+				cfg.EventChan <- *item.event
+			} else if item.ddl != nil {
+				G_TablesColumnsInfo.ApplyDdl(item.ddl)
+			} else {
+				cfg.StatChan <- *item.stats
+			}
+		}
+		fileBinEventHandlingIndex += pf.eventCnt
+		fileTrxIndex += pf.trxCnt
+
+		if pf.err != nil {
+			log.Error(fmt.Sprintf("error to parse binlog %s %v", pf.name, pf.err))
+			break
+		}
+		if pf.result == C_reBreak {
+			break
+		} else if pf.result != C_reFileEnd {
+			log.Info(fmt.Sprintf("this should not happen: return value of MyParseOneBinlog is %d\n", pf.result))
+			break
+		}
+	}
+
+	// 停止后面文件的解析
+	close(quit)
+	for range order {
+	}
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package base
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	FollowPollInterval = 500 * time.Millisecond // -follow 模式下读到文件尾后等待新内容的间隔
+)
+
+// FollowReader -follow 模式下读取正在写入的 binlog 文件。
+//
+// 读到文件尾时不返回 io.EOF ，而是等待 mysql 继续写入，因此写了一半的事件会等它写完再解析。
+// 只有下一个 binlog 文件出现(当前文件已经写完)或者收到退出信号时才返回 io.EOF 。
+type FollowReader struct {
+	f        *os.File
+	hasNext  func() bool // 下一个 binlog 文件是否已经出现
+	stopChan <-chan struct{}
+}
+
+func NewFollowReader(f *os.File, hasNext func() bool, stopChan <-chan struct{}) *FollowReader {
+	return &FollowReader{
+		f:        f,
+		hasNext:  hasNext,
+		stopChan: stopChan,
+	}
+}
+
+func (this *FollowReader) Read(p []byte) (int, error) {
+	for {
+		n, err := this.f.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		// mysql 先写完当前文件的 ROTATE_EVENT 再创建下一个文件，
+		// 看到下一个文件之后再读一次，避免漏掉检查期间写入的内容
+		if this.hasNext() {
+			return this.f.Read(p)
+		}
+
+		select {
+		case <-this.stopChan:
+			return 0, io.EOF
+		case <-time.After(FollowPollInterval):
+		}
+	}
+}
+
+// NotifyFollowStop 收到 SIGINT/SIGTERM 时关闭返回的管道，-follow 模式据此结束解析，正常输出已解析的结果
+func NotifyFollowStop() chan struct{} {
+	stopChan := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		log.Infof("receive signal %v, stop following binlog", sig)
+		signal.Stop(sigChan)
+		close(stopChan)
+	}()
+	return stopChan
+}
+
+// IsFollowStopped 是否已经收到退出信号
+func IsFollowStopped(stopChan <-chan struct{}) bool {
+	select {
+	case <-stopChan:
+		return true
+	default:
+		return false
+	}
+}
@@ -14,9 +14,9 @@ import (
 )
 
 func IntSliceToString(iArr []int, sep string, prefix string) string {
-	sArr := make([]string, len(iArr))
+	sArr := make([]string, 0, len(iArr))
 	for _, v := range iArr {
-		sArr = append(sArr, string(v))
+		sArr = append(sArr, strconv.Itoa(v))
 	}
 
 	return prefix + " " + strings.Join(sArr, sep)
@@ -0,0 +1,372 @@
+package base
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// GtidFilter 以事务为粒度，按 GTID 过滤 binlog 事件。
+//
+// 每个事务以 GTID_EVENT(mysql) 或 MARIADB_GTID_EVENT(mariadb) 开始，
+// 遇到 GTID 事件时判断整个事务是否需要处理，事务内的其它事件沿用这个判断结果。
+//
+//	-start-gtid		从该 GTID 对应的事务开始解析(包含)
+//	-stop-gtid		解析到该 GTID 对应的事务为止(包含)
+//	-include-gtids	只解析这些 GTID 对应的事务
+//	-exclude-gtids	忽略这些 GTID 对应的事务
+type GtidFilter struct {
+	flavor string
+
+	startSet   mysql.GTIDSet
+	stopSet    mysql.GTIDSet
+	includeSet mysql.GTIDSet
+	excludeSet mysql.GTIDSet
+
+	executedSet mysql.GTIDSet // 主库已执行的 GTID 集合，repl 模式下用于计算 StartSyncGTID 的集合
+
+	started     bool   // 是否已经到达 -start-gtid ，之后的匿名事务才处理
+	skipTrx     bool   // 当前事务是否被过滤
+	CurrentGtid string // 当前事务的 GTID ，匿名事务为空
+}
+
+// NewGtidFilter 解析命令行中的 GTID 参数，参数为空表示不按该条件过滤
+func NewGtidFilter(flavor string, start string, stop string, include string, exclude string) (*GtidFilter, error) {
+	var err error
+	f := &GtidFilter{flavor: flavor}
+
+	if f.startSet, err = ParseGtidSetOption(flavor, start); err != nil {
+		return nil, errors.Annotatef(err, "invalid -start-gtid %s", start)
+	}
+	if f.stopSet, err = ParseGtidSetOption(flavor, stop); err != nil {
+		return nil, errors.Annotatef(err, "invalid -stop-gtid %s", stop)
+	}
+	if f.includeSet, err = ParseGtidSetOption(flavor, include); err != nil {
+		return nil, errors.Annotatef(err, "invalid -include-gtids %s", include)
+	}
+	if f.excludeSet, err = ParseGtidSetOption(flavor, exclude); err != nil {
+		return nil, errors.Annotatef(err, "invalid -exclude-gtids %s", exclude)
+	}
+	f.started = f.startSet == nil
+	return f, nil
+}
+
+// ParseGtidSetOption 解析 GTID 集合，mysql: uuid:1-10,uuid2:5 ; mariadb: 0-1-100,1-2-30
+func ParseGtidSetOption(flavor string, s string) (mysql.GTIDSet, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if flavor == mysql.MariaDBFlavor {
+		// MariadbGTIDSet 只保存每个 domain 的最大 GTID ，不能表示离散的事务，这里自己解析
+		set := &MariadbGtidList{}
+		for _, one := range CommaSeparatedListToArray(s) {
+			gtid, err := mysql.ParseMariadbGTID(one)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			set.Gtids = append(set.Gtids, gtid)
+		}
+		return set, nil
+	}
+	return mysql.ParseMysqlGTIDSet(s)
+}
+
+// IsSet 是否设置了任意 GTID 过滤条件
+func (this *GtidFilter) IsSet() bool {
+	return this.startSet != nil || this.stopSet != nil || this.includeSet != nil || this.excludeSet != nil
+}
+
+// IfSetStopGtid 是否设置了 -stop-gtid
+func (this *GtidFilter) IfSetStopGtid() bool {
+	return this.stopSet != nil
+}
+
+// LoadServerGtidSet 查询主库已执行的 GTID 集合(mysql: gtid_executed, mariadb: gtid_binlog_pos)，
+// repl 模式下 -start-gtid 中没有的 uuid(domain) 从这个集合之后开始拉取
+func (this *GtidFilter) LoadServerGtidSet(db *sql.DB) error {
+	varName := "gtid_executed"
+	if this.flavor == mysql.MariaDBFlavor {
+		varName = "gtid_binlog_pos"
+	}
+	var str string
+	if err := db.QueryRow("SELECT @@GLOBAL." + varName).Scan(&str); err != nil {
+		return errors.Annotatef(err, "fail to query @@GLOBAL.%s", varName)
+	}
+	// gtid_executed 中每个 uuid 之间有换行
+	set, err := mysql.ParseGTIDSet(this.flavor, strings.Replace(str, "\n", "", -1))
+	if err != nil {
+		return errors.Annotatef(err, "invalid @@GLOBAL.%s %s", varName, str)
+	}
+	this.executedSet = set
+	return nil
+}
+
+// GetSyncGtidSet 计算 repl 模式下 StartSyncGTID 需要的 GTID 集合，主库会从 -start-gtid 对应的事务开始发送 binlog ：
+// 主库已执行的 GTID 集合中去掉 -start-gtid 及之后的事务，-start-gtid 中没有的 uuid(domain) 认为已经开始，
+// 只发送之后的新事务。没有查询主库(LoadServerGtidSet)时，为 -start-gtid 之前的所有事务。
+func (this *GtidFilter) GetSyncGtidSet() (mysql.GTIDSet, error) {
+	if this.startSet == nil {
+		return nil, nil
+	}
+
+	if this.flavor == mysql.MariaDBFlavor {
+		var arr []string
+		startDomains := map[uint32]bool{}
+		for _, gtid := range this.startSet.(*MariadbGtidList).Gtids {
+			startDomains[gtid.DomainID] = true
+			if gtid.SequenceNumber < 1 {
+				continue
+			}
+			arr = append(arr, fmt.Sprintf("%d-%d-%d", gtid.DomainID, gtid.ServerID, gtid.SequenceNumber-1))
+		}
+		if this.executedSet != nil {
+			for domain, gtid := range this.executedSet.(*mysql.MariadbGTIDSet).Sets {
+				if !startDomains[domain] {
+					arr = append(arr, gtid.String())
+				}
+			}
+		}
+		return mysql.ParseMariadbGTIDSet(strings.Join(arr, C_joinSepComma))
+	}
+
+	startSets := this.startSet.(*mysql.MysqlGTIDSet).Sets
+	if this.executedSet != nil {
+		// 复制一份，MinusSet 会修改集合
+		gset, err := mysql.ParseMysqlGTIDSet(this.executedSet.String())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, uuidSet := range startSets {
+			if len(uuidSet.Intervals) == 0 {
+				continue
+			}
+			gset.(*mysql.MysqlGTIDSet).MinusSet(&mysql.UUIDSet{
+				SID:       uuidSet.SID,
+				Intervals: mysql.IntervalSlice{{Start: uuidSet.Intervals[0].Start, Stop: math.MaxInt64}},
+			})
+		}
+		return gset, nil
+	}
+
+	var arr []string
+	for sid, uuidSet := range startSets {
+		if len(uuidSet.Intervals) == 0 || uuidSet.Intervals[0].Start <= 1 {
+			continue
+		}
+		arr = append(arr, fmt.Sprintf("%s:1-%d", sid, uuidSet.Intervals[0].Start-1))
+	}
+	return mysql.ParseMysqlGTIDSet(strings.Join(arr, C_joinSepComma))
+}
+
+// CheckBinEvent 遇到 GTID 事件时判断新事务是否需要处理，其它事件沿用当前事务的判断结果。
+// 返回 C_reProcess: 处理, C_reContinue: 忽略, C_reBreak: 已经越过 -stop-gtid ，结束解析
+func (this *GtidFilter) CheckBinEvent(ev *replication.BinlogEvent) int {
+	switch ev.Header.EventType {
+	case replication.GTID_EVENT:
+		gtidEvent := ev.Event.(*replication.GTIDEvent)
+		return this.checkNewTrx(GetMysqlGtidStr(gtidEvent.SID, gtidEvent.GNO))
+	case replication.ANONYMOUS_GTID_EVENT:
+		return this.checkNewTrx("")
+	case replication.MARIADB_GTID_EVENT:
+		gtidEvent := ev.Event.(*replication.MariadbGTIDEvent)
+		return this.checkNewTrx(gtidEvent.GTID.String())
+	}
+
+	if this.skipTrx {
+		return C_reContinue
+	}
+	return C_reProcess
+}
+
+func (this *GtidFilter) checkNewTrx(gtidStr string) int {
+	this.CurrentGtid = gtidStr
+	this.skipTrx = false
+
+	// 匿名事务，只要设置了起始或者包含条件就忽略
+	if gtidStr == "" {
+		if !this.started || this.includeSet != nil {
+			this.skipTrx = true
+			return C_reContinue
+		}
+		return C_reProcess
+	}
+
+	// 越过了 -stop-gtid
+	if this.stopSet != nil && this.isAfter(this.stopSet, gtidStr) {
+		log.Infof("stop to get event. StopGtid set. current gtid %s", gtidStr)
+		return C_reBreak
+	}
+
+	// 早于 -start-gtid 中同源的 GTID 的事务忽略，其它 uuid(domain) 的事务认为已经开始
+	atStart := this.startSet == nil || this.isAtOrAfterStart(gtidStr)
+	if atStart {
+		this.started = true
+	}
+
+	if !atStart {
+		this.skipTrx = true
+	} else if this.includeSet != nil && !this.contains(this.includeSet, gtidStr) {
+		this.skipTrx = true
+	} else if this.excludeSet != nil && this.contains(this.excludeSet, gtidStr) {
+		this.skipTrx = true
+	}
+
+	if this.skipTrx {
+		return C_reContinue
+	}
+	return C_reProcess
+}
+
+// contains 判断 gtid 是否属于集合 set
+func (this *GtidFilter) contains(set mysql.GTIDSet, gtidStr string) bool {
+	if this.flavor == mysql.MariaDBFlavor {
+		gtid, err := mysql.ParseMariadbGTID(gtidStr)
+		if err != nil {
+			return false
+		}
+		for _, one := range set.(*MariadbGtidList).Gtids {
+			if one.DomainID == gtid.DomainID && one.SequenceNumber == gtid.SequenceNumber {
+				return true
+			}
+		}
+		return false
+	}
+	oneSet, err := mysql.ParseMysqlGTIDSet(gtidStr)
+	if err != nil {
+		return false
+	}
+	return set.Contain(oneSet)
+}
+
+// isAtOrAfterStart 判断 gtid 是否等于或者晚于 -start-gtid 中同源(uuid/domain)的 GTID ，
+// -start-gtid 中没有该 uuid(domain) 时认为已经开始
+func (this *GtidFilter) isAtOrAfterStart(gtidStr string) bool {
+	if this.flavor == mysql.MariaDBFlavor {
+		gtid, err := mysql.ParseMariadbGTID(gtidStr)
+		if err != nil {
+			return false
+		}
+		for _, one := range this.startSet.(*MariadbGtidList).Gtids {
+			if one.DomainID == gtid.DomainID {
+				return gtid.SequenceNumber >= one.SequenceNumber
+			}
+		}
+		return true
+	}
+	sid, gno, ok := SplitMysqlGtidStr(gtidStr)
+	if !ok {
+		return false
+	}
+	uuidSet, ok := this.startSet.(*mysql.MysqlGTIDSet).Sets[sid]
+	if !ok || len(uuidSet.Intervals) == 0 {
+		return true
+	}
+	return gno >= uuidSet.Intervals[0].Start
+}
+
+// isAfter 判断 gtid 是否晚于集合 set 中同源(uuid/domain)的最后一个 GTID
+func (this *GtidFilter) isAfter(set mysql.GTIDSet, gtidStr string) bool {
+	if this.flavor == mysql.MariaDBFlavor {
+		gtid, err := mysql.ParseMariadbGTID(gtidStr)
+		if err != nil {
+			return false
+		}
+		for _, one := range set.(*MariadbGtidList).Gtids {
+			if one.DomainID == gtid.DomainID && gtid.SequenceNumber > one.SequenceNumber {
+				return true
+			}
+		}
+		return false
+	}
+	sid, gno, ok := SplitMysqlGtidStr(gtidStr)
+	if !ok {
+		return false
+	}
+	uuidSet, ok := set.(*mysql.MysqlGTIDSet).Sets[sid]
+	if !ok || len(uuidSet.Intervals) == 0 {
+		return false
+	}
+	// Interval 是左闭右开区间
+	return gno >= uuidSet.Intervals[len(uuidSet.Intervals)-1].Stop
+}
+
+// GetMysqlGtidStr 把 GTID_EVENT 中的 SID 和 GNO 格式化为 uuid:gno
+func GetMysqlGtidStr(sid []byte, gno int64) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x:%d", sid[:4], sid[4:6], sid[6:8], sid[8:10], sid[10:], gno)
+}
+
+// SplitMysqlGtidStr 把 uuid:gno 拆分为 uuid 和 gno
+func SplitMysqlGtidStr(gtidStr string) (string, int64, bool) {
+	uuidSet, err := mysql.ParseUUIDSet(gtidStr)
+	if err != nil || len(uuidSet.Intervals) != 1 {
+		return "", 0, false
+	}
+	return uuidSet.SID.String(), uuidSet.Intervals[0].Start, true
+}
+
+// MariadbGtidList 离散的 mariadb GTID 列表，用于 -include-gtids/-exclude-gtids 等参数
+type MariadbGtidList struct {
+	Gtids []*mysql.MariadbGTID
+}
+
+func (s *MariadbGtidList) String() string {
+	arr := make([]string, len(s.Gtids))
+	for i, gtid := range s.Gtids {
+		arr[i] = gtid.String()
+	}
+	return strings.Join(arr, C_joinSepComma)
+}
+
+func (s *MariadbGtidList) Encode() []byte {
+	return []byte(s.String())
+}
+
+func (s *MariadbGtidList) Equal(o mysql.GTIDSet) bool {
+	return o != nil && s.String() == o.String()
+}
+
+func (s *MariadbGtidList) Contain(o mysql.GTIDSet) bool {
+	other, ok := o.(*MariadbGtidList)
+	if !ok {
+		return false
+	}
+	for _, gtid := range other.Gtids {
+		found := false
+		for _, one := range s.Gtids {
+			if one.DomainID == gtid.DomainID && one.SequenceNumber == gtid.SequenceNumber {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MariadbGtidList) Update(GTIDStr string) error {
+	for _, one := range CommaSeparatedListToArray(GTIDStr) {
+		gtid, err := mysql.ParseMariadbGTID(one)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		s.Gtids = append(s.Gtids, gtid)
+	}
+	return nil
+}
+
+func (s *MariadbGtidList) Clone() mysql.GTIDSet {
+	clone := &MariadbGtidList{}
+	for _, gtid := range s.Gtids {
+		clone.Gtids = append(clone.Gtids, gtid.Clone())
+	}
+	return clone
+}
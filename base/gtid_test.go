@@ -0,0 +1,313 @@
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const (
+	testUuidA = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	testUuidB = "4e11fa47-71ca-11e1-9e33-c80aa9429562"
+	testUuidC = "5e11fa47-71ca-11e1-9e33-c80aa9429562"
+)
+
+// newGtidEvent 构造事务开始的 GTID 事件，gtid 为空时为匿名事务
+func newGtidEvent(t *testing.T, flavor string, gtid string) *replication.BinlogEvent {
+	if gtid == "" {
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.ANONYMOUS_GTID_EVENT},
+			Event:  &replication.GTIDEvent{},
+		}
+	}
+	if flavor == mysql.MariaDBFlavor {
+		mgtid, err := mysql.ParseMariadbGTID(gtid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &replication.BinlogEvent{
+			Header: &replication.EventHeader{EventType: replication.MARIADB_GTID_EVENT},
+			Event:  &replication.MariadbGTIDEvent{GTID: *mgtid},
+		}
+	}
+	uuidSet, err := mysql.ParseUUIDSet(gtid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &replication.BinlogEvent{
+		Header: &replication.EventHeader{EventType: replication.GTID_EVENT},
+		Event:  &replication.GTIDEvent{SID: uuidSet.SID[:], GNO: uuidSet.Intervals[0].Start},
+	}
+}
+
+func TestGtidFilter(t *testing.T) {
+	type step struct {
+		gtid string
+		want int
+	}
+	cases := []struct {
+		name                          string
+		flavor                        string
+		start, stop, include, exclude string
+		steps                         []step
+	}{
+		{
+			name:   "no filter",
+			flavor: mysql.MySQLFlavor,
+			steps:  []step{{testUuidA + ":1", C_reProcess}, {"", C_reProcess}},
+		},
+		{
+			name:   "mysql start and stop",
+			flavor: mysql.MySQLFlavor,
+			start:  testUuidA + ":5",
+			stop:   testUuidA + ":8",
+			steps: []step{
+				{"", C_reContinue}, // 还没有到达 -start-gtid 的匿名事务
+				{testUuidA + ":4", C_reContinue},
+				{testUuidA + ":5", C_reProcess},
+				{"", C_reProcess},
+				{testUuidB + ":1", C_reProcess}, // -start-gtid 中没有的 uuid 认为已经开始
+				{testUuidA + ":8", C_reProcess},
+				{testUuidA + ":9", C_reBreak},
+			},
+		},
+		{
+			name:   "mysql missing uuid counts as started",
+			flavor: mysql.MySQLFlavor,
+			start:  testUuidA + ":5",
+			steps: []step{
+				{testUuidB + ":1", C_reProcess},
+				{"", C_reProcess},
+				{testUuidA + ":3", C_reContinue},
+				{testUuidA + ":6", C_reProcess},
+			},
+		},
+		{
+			name:   "mysql stop inside and at the edge of intervals",
+			flavor: mysql.MySQLFlavor,
+			stop:   testUuidA + ":1-3:7-10," + testUuidB + ":5",
+			steps: []step{
+				{testUuidA + ":5", C_reProcess}, // 在两个区间之间，没有越过最后一个区间
+				{testUuidA + ":10", C_reProcess},
+				{testUuidB + ":5", C_reProcess},
+				{testUuidC + ":100", C_reProcess},
+				{testUuidB + ":6", C_reBreak},
+			},
+		},
+		{
+			name:    "mysql include",
+			flavor:  mysql.MySQLFlavor,
+			include: testUuidA + ":2-4",
+			steps: []step{
+				{testUuidA + ":1", C_reContinue},
+				{testUuidA + ":2", C_reProcess},
+				{"", C_reContinue}, // 有 -include-gtids 时忽略匿名事务
+				{testUuidA + ":4", C_reProcess},
+				{testUuidA + ":5", C_reContinue},
+				{testUuidB + ":3", C_reContinue},
+			},
+		},
+		{
+			name:    "mysql exclude",
+			flavor:  mysql.MySQLFlavor,
+			exclude: testUuidA + ":2," + testUuidB + ":1-10",
+			steps: []step{
+				{testUuidA + ":1", C_reProcess},
+				{testUuidA + ":2", C_reContinue},
+				{"", C_reProcess},
+				{testUuidB + ":10", C_reContinue},
+				{testUuidB + ":11", C_reProcess},
+			},
+		},
+		{
+			name:    "mysql start with include and exclude",
+			flavor:  mysql.MySQLFlavor,
+			start:   testUuidA + ":3",
+			include: testUuidA + ":1-10",
+			exclude: testUuidA + ":5",
+			steps: []step{
+				{testUuidA + ":2", C_reContinue},
+				{testUuidA + ":3", C_reProcess},
+				{testUuidA + ":5", C_reContinue},
+				{testUuidA + ":6", C_reProcess},
+				{"", C_reContinue},
+				{testUuidA + ":11", C_reContinue},
+			},
+		},
+		{
+			name:   "mariadb start and stop",
+			flavor: mysql.MariaDBFlavor,
+			start:  "0-1-100,1-2-30",
+			stop:   "0-1-105",
+			steps: []step{
+				{"", C_reContinue},
+				{"0-1-99", C_reContinue},
+				{"0-1-100", C_reProcess},
+				{"1-2-29", C_reContinue}, // 每个 domain 分别判断
+				{"1-3-30", C_reProcess},  // server id 不同，序号相同
+				{"2-1-1", C_reProcess},   // -start-gtid 中没有的 domain 认为已经开始
+				{"", C_reProcess},
+				{"1-2-1000", C_reProcess},
+				{"0-1-105", C_reProcess},
+				{"0-1-106", C_reBreak},
+			},
+		},
+		{
+			name:    "mariadb include and exclude",
+			flavor:  mysql.MariaDBFlavor,
+			include: "0-1-5,0-1-7,1-1-7",
+			exclude: "0-1-7",
+			steps: []step{
+				{"0-1-5", C_reProcess},
+				{"0-1-6", C_reContinue},
+				{"0-1-7", C_reContinue},
+				{"1-2-7", C_reProcess},
+				{"", C_reContinue},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		f, err := NewGtidFilter(c.flavor, c.start, c.stop, c.include, c.exclude)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		for i, s := range c.steps {
+			if got := f.CheckBinEvent(newGtidEvent(t, c.flavor, s.gtid)); got != s.want {
+				t.Errorf("%s: step %d gtid %q: got %d, want %d", c.name, i, s.gtid, got, s.want)
+			}
+			if f.CurrentGtid != s.gtid {
+				t.Errorf("%s: step %d: current gtid %q, want %q", c.name, i, f.CurrentGtid, s.gtid)
+			}
+			// 事务内的其它事件沿用事务的判断结果
+			if s.want != C_reBreak {
+				ev := &replication.BinlogEvent{Header: &replication.EventHeader{EventType: replication.XID_EVENT}}
+				if got := f.CheckBinEvent(ev); got != s.want {
+					t.Errorf("%s: step %d gtid %q: xid event got %d, want %d", c.name, i, s.gtid, got, s.want)
+				}
+			}
+		}
+	}
+}
+
+func TestNewGtidFilterError(t *testing.T) {
+	if _, err := NewGtidFilter(mysql.MySQLFlavor, "not-a-gtid", "", "", ""); err == nil {
+		t.Errorf("expect error for invalid mysql gtid")
+	}
+	if _, err := NewGtidFilter(mysql.MariaDBFlavor, "", "", testUuidA+":1", ""); err == nil {
+		t.Errorf("expect error for mysql gtid with mariadb flavor")
+	}
+}
+
+func TestGetSyncGtidSet(t *testing.T) {
+	cases := []struct {
+		name     string
+		flavor   string
+		start    string
+		executed string
+		want     string
+	}{
+		{
+			name:   "mysql without server gtid set",
+			flavor: mysql.MySQLFlavor,
+			start:  testUuidA + ":10," + testUuidB + ":1",
+			want:   testUuidA + ":1-9",
+		},
+		{
+			name:     "mysql with server gtid set",
+			flavor:   mysql.MySQLFlavor,
+			start:    testUuidA + ":10," + testUuidB + ":51",
+			executed: testUuidA + ":1-20,\n" + testUuidB + ":1-50,\n" + testUuidC + ":1-7",
+			want:     testUuidA + ":1-9," + testUuidB + ":1-50," + testUuidC + ":1-7",
+		},
+		{
+			name:     "mysql start inside a gap of server gtid set",
+			flavor:   mysql.MySQLFlavor,
+			start:    testUuidA + ":10",
+			executed: testUuidA + ":1-5:8-20:30-40",
+			want:     testUuidA + ":1-5:8-9",
+		},
+		{
+			name:   "mariadb without server gtid set",
+			flavor: mysql.MariaDBFlavor,
+			start:  "0-1-100,1-2-30",
+			want:   "0-1-99,1-2-29",
+		},
+		{
+			name:     "mariadb with server gtid set",
+			flavor:   mysql.MariaDBFlavor,
+			start:    "0-1-100",
+			executed: "0-1-200,1-2-40,2-3-7",
+			want:     "0-1-99,1-2-40,2-3-7",
+		},
+	}
+
+	for _, c := range cases {
+		f, err := NewGtidFilter(c.flavor, c.start, "", "", "")
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.executed != "" {
+			// 和 LoadServerGtidSet 一样去掉换行
+			if f.executedSet, err = mysql.ParseGTIDSet(c.flavor, strings.Replace(c.executed, "\n", "", -1)); err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+		}
+		var executed string
+		if f.executedSet != nil {
+			executed = f.executedSet.String()
+		}
+		gset, err := f.GetSyncGtidSet()
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := gset.String(); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+		// 不修改主库的 GTID 集合
+		if f.executedSet != nil && f.executedSet.String() != executed {
+			t.Errorf("%s: server gtid set changed to %s", c.name, f.executedSet.String())
+		}
+	}
+
+	f, _ := NewGtidFilter(mysql.MySQLFlavor, "", "", testUuidA+":1", "")
+	if gset, err := f.GetSyncGtidSet(); err != nil || gset != nil {
+		t.Errorf("got %v %v without -start-gtid, want nil", gset, err)
+	}
+}
+
+func TestMariadbGtidList(t *testing.T) {
+	set, err := ParseGtidSetOption(mysql.MariaDBFlavor, " 0-1-5, 1-2-3 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := set.String(); got != "0-1-5,1-2-3" {
+		t.Errorf("got %s", got)
+	}
+
+	one, _ := ParseGtidSetOption(mysql.MariaDBFlavor, "1-9-3")
+	if !set.Contain(one) {
+		t.Errorf("%s should contain %s", set, one)
+	}
+	other, _ := ParseGtidSetOption(mysql.MariaDBFlavor, "0-1-5,0-1-6")
+	if set.Contain(other) {
+		t.Errorf("%s should not contain %s", set, other)
+	}
+
+	clone := set.Clone()
+	if err = clone.Update("0-1-6"); err != nil {
+		t.Fatal(err)
+	}
+	if !clone.Contain(other) || set.Contain(other) {
+		t.Errorf("update of clone %s changed %s", clone, set)
+	}
+	if set.Equal(clone) || !set.Equal(set.Clone()) {
+		t.Errorf("wrong Equal of %s and %s", set, clone)
+	}
+	if err = clone.Update("bad"); err == nil {
+		t.Errorf("expect error for invalid gtid")
+	}
+}
@@ -0,0 +1,174 @@
+package base
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/shopspring/decimal"
+	"my2sql/sqltypes"
+)
+
+// json/geometry 列的值
+//
+// binlog 解析出的 json 是 go 的 json.Marshal 的结果，会把 <>& 转义为 \u003c 这样的形式，key 按字典序排列。
+// 生成 sql 时重新输出为 mysql 格式的 json 文本({"a": 1, "b": [1, 2]}，key 按长度再按字节排序)，
+// -json-cast 时输出为 CAST('..' AS JSON) 。
+// geometry 在 binlog 中是 4 字节 srid 加经度在前的 WKB ，输出为 ST_GeomFromWKB(x'..', srid) ，
+// mysql 8.0 按 srid 对应坐标系的轴顺序(如 4326 为纬度在前)读取 WKB ，所以 mysql 中 srid 不为 0 时
+// 输出为 ST_GeomFromWKB(x'..', srid, 'axis-order=long-lat') 。
+
+func init() {
+	// json 中的 decimal 输出为数字，而不是字符串
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
+// ConvertJsonValue 把 binlog 中解析出的 json 转换为 sqltypes.Json ，
+// 相同语义的 json 转换后相等，可以用 == 比较。解析失败时返回字符串
+func ConvertJsonValue(val interface{}, cast bool) (interface{}, error) {
+	var data []byte
+	switch v := val.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return val, nil
+	}
+	// NOT NULL 的 json 列插入了 NULL 时，binlog 中是空值
+	if len(data) == 0 {
+		return sqltypes.MakeJson("null", cast), nil
+	}
+	text, err := CanonicalJson(data)
+	if err != nil {
+		return string(data), err
+	}
+	return sqltypes.MakeJson(text, cast), nil
+}
+
+// CanonicalJson 把 json 文本转换为 mysql 输出的格式，数字保持原样
+func CanonicalJson(data []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	if dec.More() {
+		return "", fmt.Errorf("invalid json: extra data after the value")
+	}
+	var buf bytes.Buffer
+	writeCanonicalJson(&buf, v)
+	return buf.String(), nil
+}
+
+func writeCanonicalJson(buf *bytes.Buffer, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if val {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case json.Number:
+		buf.WriteString(val.String())
+	case string:
+		writeJsonString(buf, val)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range val {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeCanonicalJson(buf, elem)
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		// 和 mysql 保存 json 对象的顺序一样，先按长度再按字节排序
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeJsonString(buf, k)
+			buf.WriteString(": ")
+			writeCanonicalJson(buf, val[k])
+		}
+		buf.WriteByte('}')
+	}
+}
+
+// writeJsonString 只转义 json 要求转义的字符，其他 unicode 字符原样输出
+func writeJsonString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf.WriteString("\ufffd")
+			} else {
+				buf.WriteString(s[i : i+size])
+			}
+			i += size
+			continue
+		}
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xF])
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+		i++
+	}
+	buf.WriteByte('"')
+}
+
+// ConvertGeometryValue 把 geometry 列的值(4 字节小端的 srid 加 WKB)转换为 sqltypes.Geometry ，
+// mariadb 没有 axis-order 参数。格式不对时返回 false ，保持原值
+func ConvertGeometryValue(val interface{}, flavor string) (interface{}, bool) {
+	data, ok := val.([]byte)
+	if !ok {
+		return val, val == nil
+	}
+	// srid 之后至少有 WKB 的字节序和类型
+	if len(data) < 4+1+4 {
+		return val, false
+	}
+	return sqltypes.MakeGeometry(binary.LittleEndian.Uint32(data[:4]), data[4:], flavor != mysql.MariaDBFlavor), true
+}
@@ -0,0 +1,74 @@
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/siddontang/go-log/log"
+)
+
+// G_KeylessTables 生成 delete/update 时没有主键和唯一键可用的表，解析结束时汇总输出警告
+var G_KeylessTables = &KeylessTables{tables: map[string]*keylessTableStats{}}
+
+type keylessTableStats struct {
+	rows    int // 用所有字段作为条件的行数
+	dupRows int // 和同一个事件中的其他行完全相同、合并到 LIMIT n 中的行数
+}
+
+// KeylessTables 记录没有主键和唯一键的表。这些表的 delete/update 只能用所有字段作为条件，
+// 字段值不能精确比较(如 float)时可能匹配不到行，需要人工确认
+type KeylessTables struct {
+	lock   sync.Mutex
+	tables map[string]*keylessTableStats // {db.tb: 统计}
+}
+
+// AddRowsEvent 记录没有主键和唯一键的表的一个 rows 事件，只记录生成 delete/update 的事件：
+// delete 和回滚 insert 时 rowCnt 为行数，update 时为行数的两倍(修改前后各一行)
+func (this *KeylessTables) AddRowsEvent(schema string, table string, sqlType string, ifRollback bool, rowCnt int, sqlCnt int) {
+	if sqlType == "update" {
+		this.Add(schema, table, rowCnt/2, sqlCnt)
+	} else if sqlType == "delete" && !ifRollback || sqlType == "insert" && ifRollback {
+		this.Add(schema, table, rowCnt, sqlCnt)
+	}
+}
+
+// Add 记录 db.tb 的一个 rows 事件：rows 行生成了 sqlCnt 条 sql
+func (this *KeylessTables) Add(schema string, table string, rows int, sqlCnt int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	tbKey := GetAbsTableName(schema, table)
+	st, ok := this.tables[tbKey]
+	if !ok {
+		st = &keylessTableStats{}
+		this.tables[tbKey] = st
+	}
+	st.rows += rows
+	// sql 比行数多时不是合并了重复行(如 -update-as-upsert 的 delete 加 upsert ，只用于有主键或唯一键的表)，不计为重复行
+	if sqlCnt < rows {
+		st.dupRows += rows - sqlCnt
+	}
+}
+
+// WarnSummary 输出没有主键和唯一键的表及其行数
+func (this *KeylessTables) WarnSummary() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if len(this.tables) == 0 {
+		return
+	}
+	tbKeys := make([]string, 0, len(this.tables))
+	for tbKey := range this.tables {
+		tbKeys = append(tbKeys, tbKey)
+	}
+	sort.Strings(tbKeys)
+	lines := make([]string, len(tbKeys))
+	for i, tbKey := range tbKeys {
+		st := this.tables[tbKey]
+		lines[i] = fmt.Sprintf("\t%s: %d rows, %d of them are duplicate rows in the same event", tbKey, st.rows, st.dupRows)
+	}
+	log.Warnf("%d tables have no primary key or unique key, their delete/update sqls use all columns as condition "+
+		"with LIMIT n (n is the count of identical rows in the event), please check them before executing:\n%s",
+		len(tbKeys), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,42 @@
+package base
+
+import (
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestKeylessTablesAddRowsEvent(t *testing.T) {
+	tbMap, colDefs, colTypeNames := newTestTable()
+	rows := [][]interface{}{{int32(1), "a"}, {int32(1), "a"}, {int32(2), "b"}}
+	rowsEv := &replication.RowsEvent{Table: tbMap, Rows: rows}
+	updEv := &replication.RowsEvent{Table: tbMap, Rows: [][]interface{}{
+		{int32(1), "a"}, {int32(1), "z"}, {int32(1), "a"}, {int32(1), "z"},
+	}}
+
+	keyless := &KeylessTables{tables: map[string]*keylessTableStats{}}
+	sqls := GenDeleteSqlsForOneRowsEvent("pos", rowsEv, colDefs, nil, false, false, false)
+	keyless.AddRowsEvent("db", "t", "delete", false, len(rowsEv.Rows), len(sqls))
+	sqls = GenDeleteSqlsForOneRowsEventRollbackInsert("pos", rowsEv, colDefs, nil, false, false)
+	keyless.AddRowsEvent("db", "t", "insert", true, len(rowsEv.Rows), len(sqls))
+	sqls = GenUpdateSqlsForOneRowsEvent("pos", colTypeNames, colTypeNames, updEv, colDefs, nil, false, false, false, nil)
+	keyless.AddRowsEvent("db", "t", "update", false, len(updEv.Rows), len(sqls))
+	// insert 和回滚 delete 生成 insert ，不需要条件，不记录
+	keyless.AddRowsEvent("db", "t2", "insert", false, 3, 1)
+	keyless.AddRowsEvent("db", "t2", "delete", true, 3, 1)
+
+	if len(keyless.tables) != 1 {
+		t.Errorf("got %d tables, want 1", len(keyless.tables))
+	}
+	st := keyless.tables[GetAbsTableName("db", "t")]
+	// delete 3 行 1 行重复，回滚 insert 3 行 1 行重复，update 2 行 1 行重复
+	if st == nil || st.rows != 8 || st.dupRows != 3 {
+		t.Errorf("got %+v, want rows 8, dupRows 3", st)
+	}
+
+	// sql 比行数多时不计为重复行
+	keyless.Add("db", "t", 1, 2)
+	if st.rows != 9 || st.dupRows != 3 {
+		t.Errorf("got %+v, want rows 9, dupRows 3", st)
+	}
+}
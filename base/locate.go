@@ -0,0 +1,390 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+	constvar "my2sql/constvar"
+)
+
+const (
+	C_cmdLocate = "locate" // my2sql locate -datetime ... ，输出该时间点对应的 binlog 位置和 GTID
+)
+
+// BinlogProber 按时间查找 binlog 位置时，读取 binlog 文件列表和其中的事件。
+// repl 模式下从主库读取，file 模式下读取本地文件。
+type BinlogProber interface {
+	// ListBinlogs 按顺序返回可以查找的 binlog 文件
+	ListBinlogs() ([]string, error)
+	// FirstEventTime 返回 binlog 文件第一个事件(FORMAT_DESCRIPTION_EVENT)的时间
+	FirstEventTime(binlog string) (uint32, error)
+	// ScanEvents 从 binlog 文件开头逐个读取事件，读到 binlogs 中最后一个文件的结尾或者 fn 返回 true 时结束
+	ScanEvents(binlogs []string, fn func(binlog string, ev *replication.BinlogEvent) bool) error
+}
+
+// LocateResult 某个时间点之后第一个事务的开始位置
+type LocateResult struct {
+	Binlog    string
+	Pos       uint32
+	Gtid      string // 事务的 GTID ，没有开启 GTID 时为空
+	Timestamp uint32
+	Found     bool // false 表示该时间点之后没有事务，Binlog 和 Pos 为 binlog 的结尾
+}
+
+func NewBinlogProber(cfg *ConfCmd) BinlogProber {
+	if cfg.Mode == "repl" {
+		return &ReplBinlogProber{cfg: cfg}
+	}
+	return &FileBinlogProber{cfg: cfg}
+}
+
+// LocateBinlogByDatetime 二分查找 ts 所在的 binlog 文件，即第一个事件早于 ts 的最后一个文件。
+// 之前文件中的事件都早于这个文件的第一个事件，所以都早于 ts ，不需要读取；都不早于 ts 时返回第一个文件。
+func LocateBinlogByDatetime(p BinlogProber, binlogs []string, ts uint32) (int, error) {
+	var err error
+	// 第一个 "第一个事件不早于 ts" 的文件
+	i := sort.Search(len(binlogs), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var first uint32
+		first, err = p.FirstEventTime(binlogs[i])
+		if err != nil {
+			err = errors.Annotatef(err, "fail to get the first event of %s", binlogs[i])
+			return true
+		}
+		log.Debugf("the first event of %s is at %s", binlogs[i], GetDatetimeStr(int64(first), 0, constvar.DATETIME_FORMAT))
+		return first >= ts
+	})
+	if err != nil {
+		return 0, err
+	}
+	if i > 0 {
+		i--
+	}
+	return i, nil
+}
+
+// LocatePosByDatetime 从 binlogs[idx] 开始读取事件，找到第一个不早于 ts 的事务。
+// 事务从 GTID 事件开始，没有 GTID 事件时从 BEGIN 或者单独的语句(如 DDL)开始。
+func LocatePosByDatetime(p BinlogProber, binlogs []string, idx int, ts uint32) (*LocateResult, error) {
+	var (
+		res       *LocateResult = &LocateResult{}
+		inTrx     bool
+		afterGtid bool // 上一个事件是 GTID 事件，事务已经从 GTID 事件开始
+	)
+	err := p.ScanEvents(binlogs[idx:], func(binlog string, ev *replication.BinlogEvent) bool {
+		h := ev.Header
+		if h.LogPos == 0 || h.Flags&replication.LOG_EVENT_ARTIFICIAL_F != 0 {
+			return false
+		}
+		// 没有找到时返回 binlog 的结尾
+		res.Binlog, res.Pos, res.Timestamp = binlog, h.LogPos, h.Timestamp
+
+		isTrxStart := false
+		gtid, isGtidEvent := GetGtidOfEvent(ev)
+		switch {
+		case isGtidEvent:
+			isTrxStart = true
+		case h.EventType == replication.QUERY_EVENT:
+			query := strings.ToUpper(strings.TrimSpace(string(ev.Event.(*replication.QueryEvent).Query)))
+			if query == "BEGIN" || strings.HasPrefix(query, "XA START") {
+				isTrxStart = !afterGtid
+				inTrx = true
+			} else if query == "COMMIT" || query == "ROLLBACK" {
+				inTrx = false
+			} else if !inTrx {
+				isTrxStart = !afterGtid
+			}
+		case h.EventType == replication.XID_EVENT:
+			inTrx = false
+		}
+		afterGtid = isGtidEvent
+
+		if isTrxStart && h.Timestamp >= ts {
+			res = &LocateResult{Binlog: binlog, Pos: h.LogPos - h.EventSize, Gtid: gtid, Timestamp: h.Timestamp, Found: true}
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LocateStartFileByDatetime 指定了 -start-datetime 时，二分查找到该时间所在的 binlog 文件，从这个文件开始解析，
+// 不用从 -start-file 开始读取再过滤掉之前的事件。
+func (this *ConfCmd) LocateStartFileByDatetime() {
+	p := NewBinlogProber(this)
+	binlogs, err := p.ListBinlogs()
+	if err != nil {
+		log.Fatalf("fail to list binlog files to locate -start-datetime %v", err)
+	}
+	if len(binlogs) == 0 {
+		return
+	}
+	idx, err := LocateBinlogByDatetime(p, binlogs, this.StartDatetime)
+	if err != nil {
+		log.Fatalf("fail to locate -start-datetime %v", err)
+	}
+
+	binlog := GetBinlogName(binlogs[idx])
+	if binlog == this.StartFile {
+		return
+	}
+	log.Infof("-start-datetime %s is in %s, start from it", GetDatetimeStr(int64(this.StartDatetime), 0, constvar.DATETIME_FORMAT), binlog)
+	this.StartFile = binlog
+	this.StartPos = 4
+	this.IfSetStartFilePos = true
+	this.StartFilePos = mysql.Position{Name: this.StartFile, Pos: uint32(this.StartPos)}
+}
+
+// LocateDatetime my2sql locate ：输出 -datetime 之后第一个事务的 binlog 位置和 GTID
+func LocateDatetime(cfg *ConfCmd) {
+	p := NewBinlogProber(cfg)
+	binlogs, err := p.ListBinlogs()
+	if err != nil {
+		log.Fatalf("fail to list binlog files %v", err)
+	}
+	if len(binlogs) == 0 {
+		log.Fatalf("no binlog file found")
+	}
+	idx, err := LocateBinlogByDatetime(p, binlogs, cfg.LocateDatetime)
+	if err != nil {
+		log.Fatalf("fail to locate -datetime %v", err)
+	}
+	res, err := LocatePosByDatetime(p, binlogs, idx, cfg.LocateDatetime)
+	if err != nil {
+		log.Fatalf("fail to locate -datetime %v", err)
+	}
+
+	if !res.Found {
+		fmt.Printf("no transaction at or after %s, binlog ends at %s:%d\n",
+			GetDatetimeStr(int64(cfg.LocateDatetime), 0, constvar.DATETIME_FORMAT), res.Binlog, res.Pos)
+		return
+	}
+	fmt.Printf("position: %s:%d\n", res.Binlog, res.Pos)
+	fmt.Printf("gtid:     %s\n", res.Gtid)
+	fmt.Printf("datetime: %s\n", GetDatetimeStr(int64(res.Timestamp), 0, constvar.DATETIME_FORMAT))
+}
+
+// ReplBinlogProber 通过 SHOW BINARY LOGS 获取主库上的 binlog 文件，伪装成从库读取其中的事件
+type ReplBinlogProber struct {
+	cfg *ConfCmd
+}
+
+// ListBinlogs 指定了 -start-file 时只返回它和之后的文件
+func (this *ReplBinlogProber) ListBinlogs() ([]string, error) {
+	rows, err := this.cfg.FromDB.Query("SHOW BINARY LOGS")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+
+	// mysql 8.0 多了 Encrypted 列，只取第一列 Log_name
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var binlogs []string
+	for rows.Next() {
+		values := make([]sql.RawBytes, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, errors.Trace(err)
+		}
+		binlogs = append(binlogs, string(values[0]))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if this.cfg.StartFile != "" {
+		for i, binlog := range binlogs {
+			if binlog == this.cfg.StartFile {
+				return binlogs[i:], nil
+			}
+		}
+		return nil, errors.Errorf("-start-file %s not found in binary logs of master", this.cfg.StartFile)
+	}
+	return binlogs, nil
+}
+
+func (this *ReplBinlogProber) FirstEventTime(binlog string) (uint32, error) {
+	var ts uint32
+	err := this.ScanEvents([]string{binlog}, func(binlog string, ev *replication.BinlogEvent) bool {
+		// 开头是主库生成的 ROTATE_EVENT ，时间为 0
+		if ev.Header.Timestamp == 0 {
+			return false
+		}
+		ts = ev.Header.Timestamp
+		return true
+	})
+	if err == nil && ts == 0 {
+		err = errors.Errorf("no event found in %s", binlog)
+	}
+	return ts, err
+}
+
+// ScanEvents 从 binlogs[0] 开头拉取 binlog ，EventTimeout 内没有新的事件认为已经读到最后一个文件的结尾
+func (this *ReplBinlogProber) ScanEvents(binlogs []string, fn func(binlog string, ev *replication.BinlogEvent) bool) error {
+	syncer := NewReplBinlogSyncer(this.cfg)
+	defer syncer.Close()
+	streamer, err := syncer.StartSync(mysql.Position{Name: binlogs[0], Pos: 4})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	binlog := binlogs[0]
+	last := binlogs[len(binlogs)-1]
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), EventTimeout)
+		ev, err := streamer.GetEvent(ctx)
+		cancel()
+		if err == context.DeadlineExceeded {
+			return nil
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+
+		if ev.Header.EventType == replication.ROTATE_EVENT {
+			next := string(ev.Event.(*replication.RotateEvent).NextLogName)
+			// 最后一个文件已经读完
+			if binlog == last && next != last {
+				return nil
+			}
+			binlog = next
+			continue
+		}
+		if ev.Header.EventType == replication.HEARTBEAT_EVENT {
+			continue
+		}
+		if fn(binlog, ev) {
+			return nil
+		}
+	}
+}
+
+// FileBinlogProber 读取本地的 binlog 文件，-binlog-index/-binlog-files 指定的文件，或者 -local-binlog-file 及之后的文件
+type FileBinlogProber struct {
+	cfg *ConfCmd
+}
+
+// ListBinlogs 指定了 -start-file 时只返回它和之后的文件，解析 binlog 时和 MyParseAllBinlogFiles 解析的文件一致
+func (this *FileBinlogProber) ListBinlogs() ([]string, error) {
+	var (
+		binlogs []string
+		psr     BinFileParser
+	)
+	binlog, _ := GetFirstBinlogPosToParse(this.cfg)
+	if this.cfg.Command == "" && this.cfg.IfParseOneBinlogFile() {
+		return []string{binlog}, nil
+	}
+	for binlog != "" {
+		binlogs = append(binlogs, binlog)
+		binlog = psr.GetNextBinlogFile(this.cfg, binlog)
+	}
+	return binlogs, nil
+}
+
+func (this *FileBinlogProber) FirstEventTime(binlog string) (uint32, error) {
+	r, closeFile, err := OpenBinlogFileToRead(binlog)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFile()
+
+	head := make([]byte, replication.EventHeaderSize)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return 0, errors.Annotatef(err, "fail to read the first event of %s", binlog)
+	}
+	h := &replication.EventHeader{}
+	if err = h.Decode(head); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return h.Timestamp, nil
+}
+
+// ScanEvents 只解析判断事务开始需要的事件，其它事件只有事件头
+func (this *FileBinlogProber) ScanEvents(binlogs []string, fn func(binlog string, ev *replication.BinlogEvent) bool) error {
+	for _, file := range binlogs {
+		stop, err := this.scanFile(file, fn)
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *FileBinlogProber) scanFile(file string, fn func(binlog string, ev *replication.BinlogEvent) bool) (bool, error) {
+	r, closeFile, err := OpenBinlogFileToRead(file)
+	if err != nil {
+		return false, err
+	}
+	defer closeFile()
+
+	binlog := GetBinlogName(file)
+	psr := NewFileBinlogParser()
+	er := NewBinlogEventReader(r, &binlog, C_onCorruptAbort, nil)
+	for {
+		h, rawData, err := er.ReadEvent()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		ev := &replication.BinlogEvent{Header: h}
+		switch h.EventType {
+		case replication.FORMAT_DESCRIPTION_EVENT, replication.QUERY_EVENT, replication.GTID_EVENT,
+			replication.MARIADB_GTID_EVENT, replication.ROTATE_EVENT:
+			if ev.Event, err = psr.ParseEvent(h, rawData[replication.EventHeaderSize:], rawData); err != nil {
+				return false, errors.Annotatef(err, "fail to parse %s of %s at %d", h.EventType, binlog, er.EventPos())
+			}
+		}
+		if fn(binlog, ev) {
+			return true, nil
+		}
+	}
+}
+
+// OpenBinlogFileToRead 打开 binlog 文件，压缩过的边读边解压，校验并跳过 4B 文件头
+func OpenBinlogFileToRead(file string) (io.Reader, func(), error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	r, _, closeReader, err := NewBinlogFileReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, errors.Annotatef(err, "fail to open %s", file)
+	}
+	closeFile := func() {
+		closeReader()
+		f.Close()
+	}
+
+	b := make([]byte, len(replication.BinLogFileHeader))
+	if _, err = io.ReadFull(r, b); err != nil {
+		closeFile()
+		return nil, nil, errors.Annotatef(err, "fail to read %s", file)
+	} else if !bytes.Equal(b, replication.BinLogFileHeader) {
+		closeFile()
+		return nil, nil, errors.Errorf("%s is not a valid binlog file, head 4 bytes must fe'bin' ", file)
+	}
+	return r, closeFile, nil
+}
@@ -3,11 +3,11 @@ package base
 import (
 	"database/sql"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/juju/errors"
+	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/siddontang/go-log/log"
-	toolkits "my2sql/toolkits"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -39,20 +39,27 @@ type FieldInfo struct {
 	FieldName	string `json:"column_name"`		// 字段名
 	FieldType	string `json:"column_type"`		// 字段类型
 	IsUnsigned	bool	`json:"is_unsigned"`	// 有符号
+	IsGenerated	bool	`json:"is_generated,omitempty"`	// 生成列，insert 和 update 时不能指定值
+	Charset		string	`json:"charset,omitempty"`	// 字符串列的字符集
+	Values		[]string	`json:"values,omitempty"`	// enum/set 列的取值，按定义的顺序
 }
 
 type TblInfoJson struct {
-	Database   string      `json:"database"`
-	Table      string      `json:"table"`
-	Columns    []FieldInfo `json:"columns"`
-	PrimaryKey KeyInfo     `json:"primary_key"`
-	UniqueKeys []KeyInfo   `json:"unique_keys"`
+	Database       string      `json:"database"`
+	Table          string      `json:"table"`
+	Columns        []FieldInfo `json:"columns"`
+	PrimaryKey     KeyInfo     `json:"primary_key"`
+	UniqueKeys     []KeyInfo   `json:"unique_keys"`
+	UniqueKeyNames []string    `json:"unique_key_names,omitempty"` // 和 UniqueKeys 一一对应的索引名，用于 DROP INDEX
+	Charset        string      `json:"charset,omitempty"`          // 表的默认字符集，ALTER TABLE 增加的字符串列没有指定字符集时使用
 	//	DdlInfo    DdlPosInfo  `json:"ddl_info"`
 }
 
 type TablesColumnsInfo struct {
-	//lock       *sync.RWMutex
-	tableInfos map[string]*TblInfoJson //{db.tb:TblInfoJson}}
+	lock       sync.RWMutex            // 保护 tableInfos ，读取 binlog 的协程修改，生成 sql 的多个协程读取
+	tableInfos map[string]*TblInfoJson //{db.tb:TblInfoJson}}，TblInfoJson 不修改，变化时替换为新的版本
+	fetchLock  sync.Mutex              // 同一时间只有一个协程查询 mysql ，保护 dbErr 和 cfg.FromDB 的创建
+	dbErr      error                   // 连接数据库失败的错误，不再重试
 }
 
 type column struct {
@@ -70,13 +77,23 @@ type table struct {
 	indexColumns map[string][]*column
 }
 
+// GetMysqlUrl 生成 go-sql-driver 的 DSN ，指定了 -ssl-* 参数时使用 TLS 连接
 func GetMysqlUrl(cfg *ConfCmd) string {
-	var urlStr string
-	urlStr = fmt.Sprintf(
-			"%s:%s@tcp(%s:%d)/?autocommit=true&charset=utf8mb4,utf8,latin1&loc=Local&parseTime=true",
-			cfg.User, cfg.Passwd, cfg.Host, cfg.Port)
-	return urlStr
-
+	dsnCfg := gomysql.NewConfig()
+	dsnCfg.User = cfg.User
+	dsnCfg.Passwd = cfg.Passwd
+	dsnCfg.Net = "tcp"
+	dsnCfg.Addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	dsnCfg.Loc = time.Local
+	dsnCfg.ParseTime = true
+	dsnCfg.Params = map[string]string{
+		"autocommit": "true",
+		"charset":    "utf8mb4,utf8,latin1",
+	}
+	if cfg.TLSConfig != nil {
+		dsnCfg.TLSConfig = C_tlsConfigName
+	}
+	return dsnCfg.FormatDSN()
 }
 
 // CreateMysqlCon 建立 Mysql 连接
@@ -102,265 +119,11 @@ func CreateMysqlCon(mysqlUrl string) (*sql.DB, error) {
 	return db, nil
 }
 
-// GetTbDefFromDb 查询 mysql 服务器，获取 db.tb 的表信息(字段、索引)
-func (this *TablesColumnsInfo) GetTbDefFromDb(cfg *ConfCmd, dbname string, tbname string) {
-	//get table columns from DB
-	var err error
-	if cfg.FromDB == nil {
-		// 构造 mysql 地址
-		sqlUrl := GetMysqlUrl(cfg)
-		// 创建 mysql 连接
-		cfg.FromDB, err = CreateMysqlCon(sqlUrl)
-		if err != nil {
-			log.Fatalf("fail to connect to mysql %v", err)
-		}
-	}
-
-	// 查询 mysql 服务器，获取 db.tb 的 columns 信息，保存到 this.tableInfos["db.tb"].Columns 上
-	this.GetTableColumns(cfg.FromDB, dbname, tbname)
-	// 查询 mysql 服务器，获取 db.tb 的 indexes 信息，保存到 this.tableInfos["db.tb"].PrimaryKey/UniqueKeys 上
-	this.GetTableKeysInfo(cfg.FromDB, dbname, tbname)
-}
-
-func (this *TablesColumnsInfo) GetTableKeysInfo(db *sql.DB, dbName string, tbName string) error {
-	var (
-		ok                    bool
-
-		// db => table =>
-		dbTbKeysInfo          map[string]map[string]map[string]KeyInfo = map[string]map[string]map[string]KeyInfo{}
-		primaryKeys           map[string]map[string]map[string]bool    = map[string]map[string]map[string]bool{}
-	)
-
-	if dbName == "" || tbName == "" {
-		er := "schema/table is empty"
-		log.Errorf(er)
-		return errors.New(er)
-	}
-
-	query := fmt.Sprintf("SHOW INDEX FROM `%s`.`%s`", dbName, tbName)
-	rows, err := db.Query(query)
-	if err != nil {
-		log.Errorf("%v fail to query mysql: "+query, err)
-		return err
-	}
-	defer rows.Close()
-
-	// 查询结果的列数
-	rowColumns, err := rows.Columns()
-	if err != nil {
-		log.Errorf("get columns name err %v",err)
-		return errors.Trace(err)
-	}
-
-	// Show an example.
-	/*
-		mysql> show index from test.t;
-		+-------+------------+----------+--------------+-------------+-----------+-------------+----------+--------+------+------------+---------+---------------+
-		| Table | Non_unique | Key_name | Seq_in_index | Column_name | Collation | Cardinality | Sub_part | Packed | Null | Index_type | Comment | Index_comment |
-		+-------+------------+----------+--------------+-------------+-----------+-------------+----------+--------+------+------------+---------+---------------+
-		| t     |          0 | PRIMARY  |            1 | a           | A         |           0 |     NULL | NULL   |      | BTREE      |         |               |
-		| t     |          0 | PRIMARY  |            2 | b           | A         |           0 |     NULL | NULL   |      | BTREE      |         |               |
-		| t     |          0 | ucd      |            1 | c           | A         |           0 |     NULL | NULL   | YES  | BTREE      |         |               |
-		| t     |          0 | ucd      |            2 | d           | A         |           0 |     NULL | NULL   | YES  | BTREE      |         |               |
-		+-------+------------+----------+--------------+-------------+-----------+-------------+----------+--------+------+------------+---------+---------------+
-	*/
-
-	// 每一行是一个索引
-	for rows.Next() {
-		// row => values
-		data := make([]sql.RawBytes, len(rowColumns))
-		values := make([]interface{}, len(rowColumns))
-		for i := range values {
-			values[i] = &data[i]
-		}
-
-		// 读取一行数据
-		err = rows.Scan(values...)
-		if err != nil {
-			log.Errorf("rows scan err %v",err)
-			return errors.Trace(err)
-		}
-
-		// 字段 `Non_unique`
-		nonUnique := string(data[1])
-		if nonUnique == "0" {
-			//if strings.ToLower(string(data[2])) == "PRIMARY" {
-			//}
-
-			_, ok = dbTbKeysInfo[dbName]
-			if !ok {
-				dbTbKeysInfo[dbName] = map[string]map[string]KeyInfo{}
-			}
-			_, ok = dbTbKeysInfo[dbName][tbName]
-			if !ok {
-				dbTbKeysInfo[dbName][tbName] = map[string]KeyInfo{}
-			}
-
-			// 字段 `Key_name`
-			kName := string(data[2])
-			_, ok = dbTbKeysInfo[dbName][tbName][kName]
-			if !ok {
-				dbTbKeysInfo[dbName][tbName][kName] = KeyInfo{}
-			}
-
-			// 字段 `Column_name`
-			colName := string(data[4])
-			if !toolkits.ContainsString(dbTbKeysInfo[dbName][tbName][kName], colName) {
-				dbTbKeysInfo[dbName][tbName][kName] = append(dbTbKeysInfo[dbName][tbName][kName], colName)
-			}
-
-			// 如果当前 `Key_name` 为 "primary" ，则把 primaryKeys[dbName][tbName][kName] 置为 true 。
-			if strings.Contains(strings.ToLower(kName), "primary") {
-				_, ok = primaryKeys[dbName]
-				if !ok {
-					primaryKeys[dbName] = map[string]map[string]bool{}
-				}
-				_, ok = primaryKeys[dbName][tbName]
-				if !ok {
-					primaryKeys[dbName][tbName] = map[string]bool{}
-				}
-				primaryKeys[dbName][tbName][kName] = true
-			}
-		}
-	}
-
-	var isPrimay bool = false
-
-	// 构造库表名 db.tb
-	tbKey := GetAbsTableName(dbName, tbName)
-
-	// 初始化本地缓存
-	if len(this.tableInfos) < 1 {
-		this.tableInfos = map[string]*TblInfoJson{}
-	}
-
-	// 若本地缓存不存在该库表，初始化一个空对象
-	_, ok = this.tableInfos[tbKey]
-	if !ok {
-		this.tableInfos[tbKey] = &TblInfoJson{
-			Database: dbName,
-			Table: tbName,
-			PrimaryKey: KeyInfo{},
-			UniqueKeys: []KeyInfo{},
-		}
-	}
-
-	// 主键
-	this.tableInfos[tbKey].PrimaryKey = KeyInfo{}
-	// 唯一键
-	this.tableInfos[tbKey].UniqueKeys = []KeyInfo{}
-
-	// 遍历
-	for kname, kcolumn := range dbTbKeysInfo[dbName][tbName] {
-		// 是否为主键
-		isPrimay = false
-		_, ok = primaryKeys[dbName]
-		if ok {
-			_, ok = primaryKeys[dbName][tbName]
-			if ok {
-				_, ok = primaryKeys[dbName][tbName][kname]
-				if ok && primaryKeys[dbName][tbName][kname] {
-					isPrimay = true
-				}
-			}
-		}
-
-		// 保存 db.tb 的主键列
-		if isPrimay {
-			this.tableInfos[tbKey].PrimaryKey = kcolumn
-		// 保存 db.tb 的唯一键列
-		} else {
-			this.tableInfos[tbKey].UniqueKeys = append(this.tableInfos[tbKey].UniqueKeys, kcolumn)
-		}
-	}
-	return nil
-}
-
-
-func  (this *TablesColumnsInfo) GetTableColumns(db *sql.DB, dbname string, tbname string) error{
-	var (
-		dbTbFieldsInfo map[string][]FieldInfo = map[string][]FieldInfo{}
-	)
-
-	if dbname == "" || tbname == "" {
-		er := "schema/table is empty"
-		log.Errorf(er)
-		return errors.New(er)
-	}
-
-	// 执行查询
-	query := fmt.Sprintf("SHOW COLUMNS FROM `%s`.`%s`", dbname, tbname)
-	rows, err := db.Query(query)
-	if err != nil {
-		log.Errorf("%v fail to query mysql: "+query, err)
-		return err
-	}
-	defer rows.Close()
-
-	// 查询结果的列数
-	rowColumns, err := rows.Columns()
-	if err != nil {
-		log.Errorf("get rows columns err %v",err)
-		return errors.Trace(err)
-	}
-
-	// Show an example.
-	/*
-	   mysql> show columns from test.tb;
-	   +-------+---------+------+-----+---------+-------+
-	   | Field | Type    | Null | Key | Default | Extra |
-	   +-------+---------+------+-----+---------+-------+
-	   | a     | int(11) | NO   | PRI | NULL    |       |
-	   | b     | int(11) | NO   | PRI | NULL    |       |
-	   | c     | int(11) | YES  | MUL | NULL    |       |
-	   | d     | int(11) | YES  |     | NULL    |       |
-	   +-------+---------+------+-----+---------+-------+
-	*/
-
-	// 库表名 db.tb
-	tbKey := GetAbsTableName(dbname, tbname)
-
-	// 遍历查询结果，每一行是一个 field 的信息，逐个追加保存到 dbTbFieldsInfo[tbKey] 中
-	for rows.Next() {
-		//err := rows.Scan(&colName, &dataType, &nullValue, &KeyValue, &defaultValue, &extraValue)
-		data := make([]sql.RawBytes, len(rowColumns))
-		values := make([]interface{}, len(rowColumns))
-		for i := range values {
-			values[i] = &data[i]
-		}
-		// 把 rows 转存到 values 中
-		err = rows.Scan(values...)
-		if err != nil {
-			log.Errorf("rows scan err %v",err)
-			return errors.Trace(err)
-		}
-		// 初始化
-		_, ok := dbTbFieldsInfo[tbKey]
-		if !ok {
-			dbTbFieldsInfo[tbKey] = []FieldInfo{} // 空列表
-		}
-		// 保存字段名、字段类型、符号类型
-		dbTbFieldsInfo[tbKey] = append(dbTbFieldsInfo[tbKey], FieldInfo{
-			FieldName: string(data[0]),
-			FieldType: GetFiledType(string(data[1])),
-			IsUnsigned: IsUnsigned(string(data[1])),
-		})
-	}
-
-	// 初始化
-	if len(this.tableInfos) < 1 {
-		this.tableInfos = map[string]*TblInfoJson{}
-	}
-
-	// 保存 db.tb 对应的 fields 信息
-	this.tableInfos[tbKey] = &TblInfoJson{
-		Database: dbname,
-		Table: tbname,
-		Columns: dbTbFieldsInfo[tbKey],
-	}
-
-	return nil
-
+// IsGeneratedColumnExtra COLUMNS.EXTRA 是否表示生成列，mysql 8.0 的 DEFAULT_GENERATED 是表达式默认值，不是生成列
+func IsGeneratedColumnExtra(extra string) bool {
+	extra = strings.ToUpper(extra)
+	return strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED") ||
+		strings.Contains(extra, "PERSISTENT GENERATED")
 }
 
 // GetTableInfoJson 查询 mysql 服务器，获取 db.tb 的表信息(字段、索引)
@@ -368,11 +131,17 @@ func (this *TablesColumnsInfo) GetTableInfoJson(schema string, table string) (*T
 	// 构造库表名 db.tb
 	tbKey := GetAbsTableName(schema, table)
 	// 获取缓存的 db.tb 元信息，不存在则查询 Mysql 服务器获取
-	tbDefsJson, ok := this.tableInfos[tbKey]
+	tbDefsJson, ok := this.getTableInfo(tbKey)
 	if !ok {
+		// -only-table-def-file 只使用 -table-def-file 中的表结构
+		if GConfCmd.OnlyColFromFile {
+			return &TblInfoJson{}, fmt.Errorf("table struct not found for %s in %s", tbKey, GConfCmd.ReadTblDefJsonFile)
+		}
 		// 查询 mysql 服务器，获取 db.tb 的表信息(字段、索引)
-		this.GetTbDefFromDb(GConfCmd, schema, table)
-		tbDefsJson, ok = this.tableInfos[tbKey]
+		if err := this.GetTbDefFromDb(GConfCmd, schema, table); err != nil {
+			log.Fatalf("%v", err)
+		}
+		tbDefsJson, ok = this.getTableInfo(tbKey)
 		if !ok {
 			return &TblInfoJson{}, fmt.Errorf("table struct not found for %s, maybe it was dropped. Skip it", tbKey)
 		}
@@ -381,6 +150,24 @@ func (this *TablesColumnsInfo) GetTableInfoJson(schema string, table string) (*T
 }
 
 
+// LookupTableInfo 和 GetTableInfoJson 一样，但是连接不上 mysql 时返回错误，不退出
+func (this *TablesColumnsInfo) LookupTableInfo(schema string, table string) (*TblInfoJson, error) {
+	tbKey := GetAbsTableName(schema, table)
+	if tbDefsJson, ok := this.getTableInfo(tbKey); ok {
+		return tbDefsJson, nil
+	}
+	if GConfCmd.OnlyColFromFile {
+		return nil, fmt.Errorf("table struct not found for %s in %s", tbKey, GConfCmd.ReadTblDefJsonFile)
+	}
+	if err := this.GetTbDefFromDb(GConfCmd, schema, table); err != nil {
+		return nil, err
+	}
+	if tbDefsJson, ok := this.getTableInfo(tbKey); ok {
+		return tbDefsJson, nil
+	}
+	return nil, fmt.Errorf("table struct not found for %s, maybe it was dropped", tbKey)
+}
+
 // GetOneUniqueKey 获取唯一键
 func (this *TblInfoJson) GetOneUniqueKey(uniqueFirst bool) KeyInfo {
 	// 第一个唯一键
@@ -402,6 +189,17 @@ func (this *TblInfoJson) GetOneUniqueKey(uniqueFirst bool) KeyInfo {
 	}
 }
 
+// GetGeneratedColIndex 生成列的下标，insert 和 update 时不能指定生成列的值
+func GetGeneratedColIndex(columns []FieldInfo) []int {
+	arr := []int{}
+	for i, f := range columns {
+		if f.IsGenerated {
+			arr = append(arr, i)
+		}
+	}
+	return arr
+}
+
 func GetColIndexFromKey(ki KeyInfo, columns []FieldInfo) []int {
 	arr := make([]int, len(ki))
 	// 遍历唯一键的各个列(联合键)，arr[i]=>j 表示第 i 个主键列对应库表的第 j 个列。
@@ -0,0 +1,189 @@
+package base
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/juju/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// binlog_transaction_compression=ON 时，mysql 8.0.20+ 把整个事务的事件压缩后写入一个 TRANSACTION_PAYLOAD_EVENT
+const (
+	C_transactionPayloadEvent replication.EventType = 40
+
+	// payload 事件头中的字段类型
+	C_payloadHeaderEndMark     uint64 = 0
+	C_payloadSizeField         uint64 = 1
+	C_payloadCompressionField  uint64 = 2
+	C_payloadUncompressedField uint64 = 3
+
+	// payload 的压缩算法
+	C_payloadCompressionZstd uint64 = 0
+	C_payloadCompressionNone uint64 = 255
+)
+
+// PayloadParser 解压 TRANSACTION_PAYLOAD_EVENT ，并解析其中的事件。
+//
+// payload 中的事件不带 checksum ，所以使用单独的 parser ，其 FORMAT_DESCRIPTION_EVENT 的 checksum 算法被改为 OFF ；
+// 外层 parser 在遇到 rows 事件的 STMT_END_F 时会清空 table map ，也不能和 payload 中的事件共用。
+type PayloadParser struct {
+	parser      *replication.BinlogParser
+	decoder     *zstd.Decoder
+	checksumAlg byte // 外层 binlog 的 checksum 算法
+}
+
+func NewPayloadParser(loc *time.Location) *PayloadParser {
+	psr := replication.NewBinlogParser()
+	psr.SetParseTime(false)  // do not parse mysql datetime/time column into go time structure, take it as string
+	psr.SetUseDecimal(true) // decimal 列解析为 decimal.Decimal ，按原样输出全部数字
+	if loc != nil {
+		psr.SetTimestampStringLocation(loc)
+	}
+	// 不指定 WithDecoderConcurrency 时会按 CPU 数启动后台 goroutine ，DecodeAll 用不到
+	decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	return &PayloadParser{
+		parser:      psr,
+		decoder:     decoder,
+		checksumAlg: replication.BINLOG_CHECKSUM_ALG_UNDEF,
+	}
+}
+
+// OnFormatDescriptionEvent 每个 binlog 文件开头的 FORMAT_DESCRIPTION_EVENT 都要传进来，rawData 包含事件头
+func (this *PayloadParser) OnFormatDescriptionEvent(rawData []byte) error {
+	if len(rawData) < replication.EventHeaderSize+5 {
+		return errors.Errorf("invalid format description event size %d", len(rawData))
+	}
+	data := make([]byte, len(rawData))
+	copy(data, rawData)
+
+	ev, err := this.parser.Parse(data)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fde := ev.Event.(*replication.FormatDescriptionEvent)
+	if fde.ChecksumAlgorithm == replication.BINLOG_CHECKSUM_ALG_UNDEF {
+		// 不支持 checksum 的老版本，也不会有 payload 事件
+		this.checksumAlg = replication.BINLOG_CHECKSUM_ALG_UNDEF
+		return nil
+	}
+	this.checksumAlg = fde.ChecksumAlgorithm
+
+	// 倒数第 5 个字节是 checksum 算法
+	data[len(data)-5] = replication.BINLOG_CHECKSUM_ALG_OFF
+	_, err = this.parser.Parse(data)
+	return errors.Trace(err)
+}
+
+// Decode 解压 payload 事件，返回其中的事件。
+// 这些事件的 LogPos 和 EventSize 被改为 payload 事件的值，这样输出中的位置都指向 payload 事件。
+func (this *PayloadParser) Decode(ev *replication.BinlogEvent) ([]*replication.BinlogEvent, error) {
+	ge, ok := ev.Event.(*replication.GenericEvent)
+	if !ok {
+		return nil, errors.Errorf("unexpected transaction payload event %T", ev.Event)
+	}
+
+	payload, compression, err := this.parsePayloadHeader(ge.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	switch compression {
+	case C_payloadCompressionZstd:
+		data, err = this.decoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, errors.Annotate(err, "fail to decompress transaction payload")
+		}
+	case C_payloadCompressionNone:
+		data = payload
+	default:
+		return nil, errors.Errorf("unsupported transaction payload compression type %d", compression)
+	}
+
+	var events []*replication.BinlogEvent
+	for len(data) > 0 {
+		if len(data) < replication.EventHeaderSize {
+			return nil, errors.Errorf("invalid event header size %d in transaction payload", len(data))
+		}
+		size := int(binary.LittleEndian.Uint32(data[9:13]))
+		if size < replication.EventHeaderSize || size > len(data) {
+			return nil, errors.Errorf("invalid event size %d in transaction payload, %d bytes left", size, len(data))
+		}
+		rawData := data[:size]
+		data = data[size:]
+
+		h, err := this.parser.ParseHeader(rawData)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		body := rawData[replication.EventHeaderSize:]
+		// 一般不带 checksum ，为了兼容带 checksum 的情况，校验通过时去掉
+		if this.checksumAlg == replication.BINLOG_CHECKSUM_ALG_CRC32 && len(body) >= replication.BinlogChecksumLength {
+			n := len(rawData) - replication.BinlogChecksumLength
+			if crc32.ChecksumIEEE(rawData[:n]) == binary.LittleEndian.Uint32(rawData[n:]) {
+				body = body[:len(body)-replication.BinlogChecksumLength]
+			}
+		}
+
+		e, err := this.parser.ParseEvent(h, body, rawData)
+		if err != nil {
+			return nil, errors.Annotatef(err, "fail to parse %s in transaction payload", h.EventType)
+		}
+
+		h.LogPos = ev.Header.LogPos
+		h.EventSize = ev.Header.EventSize
+		events = append(events, &replication.BinlogEvent{Header: h, Event: e})
+	}
+	return events, nil
+}
+
+// parsePayloadHeader 解析 payload 事件头中的字段，返回压缩后的数据和压缩算法。
+// 每个字段是 类型、长度、值 ，都是 length encoded int ，以 C_payloadHeaderEndMark 结束。
+func (this *PayloadParser) parsePayloadHeader(data []byte) ([]byte, uint64, error) {
+	var (
+		compression uint64 = C_payloadCompressionNone
+		size        uint64
+		hasSize     bool
+	)
+	pos := 0
+	for {
+		if pos >= len(data) {
+			return nil, 0, errors.Errorf("invalid transaction payload header, end mark not found")
+		}
+		tp, _, n := mysql.LengthEncodedInt(data[pos:])
+		pos += n
+		if tp == C_payloadHeaderEndMark {
+			break
+		}
+		if pos >= len(data) {
+			return nil, 0, errors.Errorf("invalid transaction payload header, field %d has no length", tp)
+		}
+		length, _, n := mysql.LengthEncodedInt(data[pos:])
+		pos += n
+		if pos+int(length) > len(data) {
+			return nil, 0, errors.Errorf("invalid transaction payload header, field %d length %d", tp, length)
+		}
+		var value uint64
+		if length > 0 {
+			value, _, _ = mysql.LengthEncodedInt(data[pos : pos+int(length)])
+		}
+		pos += int(length)
+
+		switch tp {
+		case C_payloadSizeField:
+			size, hasSize = value, true
+		case C_payloadCompressionField:
+			compression = value
+		}
+	}
+
+	payload := data[pos:]
+	if hasSize && size != uint64(len(payload)) {
+		return nil, 0, errors.Errorf("invalid transaction payload size %d, expected %d", len(payload), size)
+	}
+	return payload, compression, nil
+}
@@ -12,14 +12,19 @@ import (
 
 func ParserAllBinEventsFromRepl(cfg *ConfCmd) {
 	defer cfg.CloseChan()
-	cfg.BinlogStreamer = NewReplBinlogStreamer(cfg)
+	var err error
+	cfg.BinlogSyncer, cfg.BinlogStreamer, err = NewReplBinlogStreamer(cfg, nil)
+	if err != nil {
+		log.Fatalf(fmt.Sprintf("error replication from master %s:%d %v", cfg.Host, cfg.Port, err))
+	}
 	log.Info("start to get binlog from mysql")
 	SendBinlogEventRepl(cfg)
+	cfg.BinlogSyncer.Close()
 	log.Info("finish getting binlog from mysql")
 }
 
-// NewReplBinlogStreamer 创建一个 mysql syncer
-func NewReplBinlogStreamer(cfg *ConfCmd) *replication.BinlogStreamer {
+// NewReplBinlogSyncer 按连接参数创建一个 mysql syncer ，还没有开始拉取 binlog
+func NewReplBinlogSyncer(cfg *ConfCmd) *replication.BinlogSyncer {
 	replCfg := replication.BinlogSyncerConfig{
 		ServerID:                uint32(cfg.ServerId),
 		Flavor:                  cfg.MysqlType,
@@ -31,22 +36,56 @@ func NewReplBinlogStreamer(cfg *ConfCmd) *replication.BinlogStreamer {
 		SemiSyncEnabled:         false,
 		TimestampStringLocation: GBinlogTimeLocation,
 		ParseTime:               false, //donot parse mysql datetime/time column into go time structure, take it as string
-		UseDecimal:              false, // sqlbuilder not support decimal type
+		UseDecimal:              true, // decimal 列解析为 decimal.Decimal ，按原样输出全部数字
+		TLSConfig:               cfg.TLSConfig,
+		HeartbeatPeriod:         cfg.HeartbeatPeriod,
+		// syncer 自己重连时从断开的事件继续，事务中的 TABLE_MAP_EVENT 已经丢失，由 ReplPosTracker 从事务边界重连
+		DisableRetrySync: true,
 	}
 
-	replSyncer := replication.NewBinlogSyncer(replCfg)
+	return replication.NewBinlogSyncer(replCfg)
+}
 
-	syncPosition := mysql.Position{
-		Name: cfg.StartFile,
-		Pos: uint32(cfg.StartPos),
-	}
+// NewReplBinlogStreamer 创建一个 mysql syncer 并开始拉取 binlog 。
+// resume 不为空时是断线重连，从 resume 中的事务边界(位置或者 GTID 集合)开始拉取。
+func NewReplBinlogStreamer(cfg *ConfCmd, resume *Checkpoint) (*replication.BinlogSyncer, *replication.BinlogStreamer, error) {
+	replSyncer := NewReplBinlogSyncer(cfg)
+
+	var (
+		replStreamer *replication.BinlogStreamer
+		err          error
+	)
 
-	replStreamer, err := replSyncer.StartSync(syncPosition)
+	// 指定了 -start-gtid ，则基于 GTID 从主库获取 binlog
+	if cfg.GtidFilter != nil && cfg.StartGtid != "" {
+		gset, gErr := cfg.GtidFilter.GetSyncGtidSet()
+		// -resume 或者重连时从已完整解析的 GTID 集合之后开始
+		if resume != nil {
+			gset, gErr = mysql.ParseGTIDSet(cfg.MysqlType, resume.GtidSet)
+		} else if cfg.ResumeCheckpoint != nil && cfg.ResumeCheckpoint.GtidSet != "" {
+			gset, gErr = mysql.ParseGTIDSet(cfg.MysqlType, cfg.ResumeCheckpoint.GtidSet)
+		}
+		if gErr != nil {
+			log.Fatalf(fmt.Sprintf("invalid -start-gtid %s %v", cfg.StartGtid, gErr))
+		}
+		log.Infof("start to sync binlog from gtid set [%s]", gset.String())
+		replStreamer, err = replSyncer.StartSyncGTID(gset)
+	} else {
+		syncPosition := mysql.Position{
+			Name: cfg.StartFile,
+			Pos: uint32(cfg.StartPos),
+		}
+		if resume != nil {
+			syncPosition = mysql.Position{Name: resume.Binlog, Pos: resume.Pos}
+		}
+		replStreamer, err = replSyncer.StartSync(syncPosition)
+	}
 	if err != nil {
-		log.Fatalf(fmt.Sprintf("error replication from master %s:%d %v", cfg.Host, cfg.Port, err))
+		replSyncer.Close()
+		return nil, nil, err
 	}
 
-	return replStreamer
+	return replSyncer, replStreamer, nil
 }
 
 func SendBinlogEventRepl(cfg *ConfCmd) {
@@ -67,143 +106,190 @@ func SendBinlogEventRepl(cfg *ConfCmd) {
 		rowCnt  uint32 = 0
 
 		tbMapPos uint32 = 0
+		rowsQuery string = "" // 当前语句的原始 sql
+
+		payloadParser *PayloadParser = NewPayloadParser(GBinlogTimeLocation)
+		posTracker    *ReplPosTracker = NewReplPosTracker(cfg)
 
 		//justStart   bool = true
 		//orgSqlEvent *replication.RowsQueryEvent
 	)
 
 	for {
-		// 输出到屏幕
-		if cfg.OutputToScreen {
-			// 读取一个 Event (阻塞式）
-			ev, err = cfg.BinlogStreamer.GetEvent(context.Background())
-			if err != nil {
-				log.Fatalf(fmt.Sprintf("error to get binlog event"))
-				break
+		// 读取一个 Event ，心跳超时或者连接断开时从最后一个完整处理的事务边界重连
+		ev, err = posTracker.GetEvent(cfg)
+		if err == context.DeadlineExceeded {
+			log.Infof("deadline exceeded.")
+			break
+		} else if err != nil {
+			if cfg.ReconnectRetries == 0 {
+				log.Fatalf(fmt.Sprintf("error to get binlog event %v", err))
 			}
-		} else{
-			// 读取一个 Event ，指定超时时间
-			ctx, cancel := context.WithTimeout(context.Background(), EventTimeout)
-			ev, err = cfg.BinlogStreamer.GetEvent(ctx)
-			cancel()
-			if err == context.Canceled {
-				log.Infof("ready to quit! [%v]", err)
-				break
-			} else if err == context.DeadlineExceeded {
-				log.Infof("deadline exceeded.")
-				break
-			} else if err !=nil {
-				log.Fatalf(fmt.Sprintf("error to get binlog event %v",err))
-				break
+			if err = posTracker.Reconnect(cfg, err); err != nil {
+				log.Fatalf(fmt.Sprintf("error to reconnect to master %s:%d %v", cfg.Host, cfg.Port, err))
 			}
+			continue
+		}
+		// 重连后再次收到的已经处理过的事件
+		if posTracker.IsDuplicate(ev) {
+			continue
 		}
 
-		// 如果是 `TABLE_MAP_EVENT` 事件
-		if ev.Header.EventType == replication.TABLE_MAP_EVENT {
-			tbMapPos = ev.Header.LogPos - ev.Header.EventSize 
-			// avoid mysqlbing mask the row event as unknown table row event
+		// payload 中的事件要用当前 binlog 的 FORMAT_DESCRIPTION_EVENT 解析
+		if ev.Header.EventType == replication.FORMAT_DESCRIPTION_EVENT {
+			if err = payloadParser.OnFormatDescriptionEvent(ev.RawData); err != nil {
+				log.Fatalf(fmt.Sprintf("fail to parse format description event of %s %v", currentBinlog, err))
+			}
 		}
 
 		// 清空 RawData
 		ev.RawData = []byte{} // we donnot need raw data
 
-		// 转换
-		oneMyEvent := &MyBinEvent{
-			MyPos: mysql.Position{
-				Name: currentBinlog,
-				Pos: ev.Header.LogPos,
-			},
-			StartPos: tbMapPos,
+		// TRANSACTION_PAYLOAD_EVENT 解压后逐个处理其中的事件，其它事件只处理自身
+		evs := []*replication.BinlogEvent{ev}
+		if ev.Header.EventType == C_transactionPayloadEvent {
+			evs, err = payloadParser.Decode(ev)
+			if err != nil {
+				log.Fatalf(fmt.Sprintf("fail to decode transaction payload event of %s %d %v", currentBinlog, ev.Header.LogPos, err))
+			}
 		}
 
+		for _, ev := range evs {
+			// 如果是 `TABLE_MAP_EVENT` 事件
+			if ev.Header.EventType == replication.TABLE_MAP_EVENT {
+				tbMapPos = ev.Header.LogPos - ev.Header.EventSize 
+				// avoid mysqlbing mask the row event as unknown table row event
+			}
+
+			// 记录 rows 事件之前的原始语句
+			TrackRowsQuery(ev, &rowsQuery)
 
-		//
-		chkRe = oneMyEvent.CheckBinEvent(cfg, ev, &currentBinlog)
-		if chkRe == C_reContinue {
-			continue
-		} else if chkRe == C_reBreak {
-			break
-		} else if chkRe == C_reFileEnd {
-			continue
-		}
+			// 转换
+			oneMyEvent := &MyBinEvent{
+				MyPos: mysql.Position{
+					Name: currentBinlog,
+					Pos: ev.Header.LogPos,
+				},
+				StartPos: tbMapPos,
+			}
 
-		// 解析 event ，得到库、表、sql 语句、sql 类型、数据行数目
-		db, tb, sqlType, sql, rowCnt = GetDbTbAndQueryAndRowCntFromBinevent(ev)
-		//if find := strings.Contains(db, "#"); find {
-		//	log.Fatalf(fmt.Sprintf("Unsupported database name %s contains special character '#'", db))
-		//	break
-		//}
-		//if find := strings.Contains(tb, "#"); find {
-		//	log.Fatalf(fmt.Sprintf("Unsupported table name %s.%s contains special character '#'", db, tb))
-		//	break
-		//}
-
-		// 查询语句
-		if sqlType == "query" {
-			sqlLower = strings.ToLower(sql)
-			if sqlLower == "begin" {
-				trxStatus = C_trxBegin
-				trxIndex++
-			} else if sqlLower == "commit" {
-				trxStatus = C_trxCommit
-			} else if sqlLower == "rollback" {
-				trxStatus = C_trxRollback
-			} else if oneMyEvent.QuerySql != nil  {
-				trxStatus = C_trxProcess
-				rowCnt = 1
+
+			// 在过滤之前记录事务的 GTID 和事务边界
+			trxEnd := false
+			if cfg.TrxTracker != nil {
+				trxEnd = cfg.TrxTracker.ObserveEvent(ev)
 			}
-		} else {
-			trxStatus = C_trxProcess
-		}
+			posTracker.ObserveEvent(currentBinlog, ev)
 
-		// -work-type：指定工作类型（前滚、闪回、事务分析），合法值分别为：2sql（默认）、rollback、stats
-		if cfg.WorkType != "stats" {
-			ifSendEvent := false
-			if oneMyEvent.IfRowsEvent {
-				tbKey := GetAbsTableName(string(oneMyEvent.BinEvent.Table.Schema), string(oneMyEvent.BinEvent.Table.Table))
-				_, err = G_TablesColumnsInfo.GetTableInfoJson(string(oneMyEvent.BinEvent.Table.Schema), string(oneMyEvent.BinEvent.Table.Table))
-				if err != nil {
-					log.Fatalf(fmt.Sprintf("no table struct found for %s, it maybe dropped, skip it. RowsEvent position:%s",
-							tbKey, oneMyEvent.MyPos.String()))
+			//
+			chkRe = oneMyEvent.CheckBinEvent(cfg, ev, &currentBinlog)
+			if chkRe == C_reContinue {
+				// 被过滤的事务同样推进 checkpoint
+				if trxEnd && cfg.WorkType != "stats" {
+					binEventIdx++
+					cfg.EventChan <- *NewTrxEndEvent(cfg, binEventIdx, currentBinlog, ev.Header.LogPos, ev.Header.Timestamp)
 				}
-				ifSendEvent = true
+				continue
+			} else if chkRe == C_reBreak {
+				return
+			} else if chkRe == C_reFileEnd {
+				continue
 			}
-			if ifSendEvent {
-				binEventIdx++
-				oneMyEvent.EventIdx = binEventIdx
-				oneMyEvent.SqlType = sqlType
-				oneMyEvent.Timestamp = ev.Header.Timestamp
-				oneMyEvent.TrxIndex = trxIndex
-				oneMyEvent.TrxStatus = trxStatus
-				cfg.EventChan <- *oneMyEvent
-			}
-		} 
-		
-		//output analysis result whatever the WorkType is	
-		if sqlType != "" {
+
+			// 解析 event ，得到库、表、sql 语句、sql 类型、数据行数目
+			db, tb, sqlType, sql, rowCnt = GetDbTbAndQueryAndRowCntFromBinevent(ev)
+			//if find := strings.Contains(db, "#"); find {
+			//	log.Fatalf(fmt.Sprintf("Unsupported database name %s contains special character '#'", db))
+			//	break
+			//}
+			//if find := strings.Contains(tb, "#"); find {
+			//	log.Fatalf(fmt.Sprintf("Unsupported table name %s.%s contains special character '#'", db, tb))
+			//	break
+			//}
+
+			// 查询语句
 			if sqlType == "query" {
-				cfg.StatChan <- BinEventStats{
-					Timestamp: ev.Header.Timestamp,
-					Binlog: currentBinlog,
-					StartPos: ev.Header.LogPos - ev.Header.EventSize,
-					StopPos: ev.Header.LogPos,
-					Database: db,
-					Table: tb,
-					QuerySql: sql,
-					RowCnt: rowCnt,
-					QueryType: sqlType,
+				sqlLower = strings.ToLower(sql)
+				if sqlLower == "begin" {
+					trxStatus = C_trxBegin
+					trxIndex++
+				} else if sqlLower == "commit" {
+					trxStatus = C_trxCommit
+				} else if sqlLower == "rollback" {
+					trxStatus = C_trxRollback
+				} else if oneMyEvent.QuerySql != nil  {
+					trxStatus = C_trxProcess
+					rowCnt = 1
+				}
+
+				// ddl 修改缓存的表结构，之后的 rows 事件使用新的表结构
+				if cfg.WorkType != "stats" && sqlLower != "begin" && sqlLower != "commit" && sqlLower != "rollback" {
+					G_TablesColumnsInfo.ApplyDdl(&QueryDdl{Database: db, Sql: sql, Pos: oneMyEvent.MyPos})
 				}
 			} else {
-				cfg.StatChan <- BinEventStats{
-					Timestamp: ev.Header.Timestamp,
-					Binlog: currentBinlog,
-					StartPos: tbMapPos,
-					StopPos: ev.Header.LogPos,
-					Database: db,
-					Table: tb,
-					QuerySql: sql,
-					RowCnt: rowCnt,
-					QueryType: sqlType,
+				trxStatus = C_trxProcess
+			}
+
+			// -work-type：指定工作类型（前滚、闪回、事务分析），合法值分别为：2sql（默认）、rollback、stats
+			if cfg.WorkType != "stats" {
+				ifSendEvent := false
+				if oneMyEvent.IfRowsEvent {
+					tbKey := GetAbsTableName(string(oneMyEvent.BinEvent.Table.Schema), string(oneMyEvent.BinEvent.Table.Table))
+					oneMyEvent.TableInfo, err = G_TableMetaProvider.GetTableInfo(oneMyEvent.BinEvent.Table)
+					if err != nil {
+						log.Fatalf(fmt.Sprintf("no table struct found for %s, it maybe dropped, skip it. RowsEvent position:%s",
+								tbKey, oneMyEvent.MyPos.String()))
+					}
+					ifSendEvent = true
+				}
+				if ifSendEvent {
+					binEventIdx++
+					oneMyEvent.EventIdx = binEventIdx
+					oneMyEvent.SqlType = sqlType
+					oneMyEvent.Timestamp = ev.Header.Timestamp
+					oneMyEvent.TrxIndex = trxIndex
+					oneMyEvent.TrxStatus = trxStatus
+					oneMyEvent.RowsQuery = rowsQuery
+					cfg.EventChan <- *oneMyEvent
+				}
+
+				// 事务结束，发送事务结束事件。跟踪 checkpoint 时包括 DDL 等单独的语句
+				if cfg.TrxTracker == nil {
+					trxEnd = NeedTrxEndEvent(cfg) && sqlType == "query" && sqlLower == "commit"
+				}
+				if trxEnd {
+					binEventIdx++
+					cfg.EventChan <- *NewTrxEndEvent(cfg, binEventIdx, currentBinlog, ev.Header.LogPos, ev.Header.Timestamp)
+				}
+			} 
+		
+			//output analysis result whatever the WorkType is	
+			if sqlType != "" {
+				if sqlType == "query" {
+					cfg.StatChan <- BinEventStats{
+						Timestamp: ev.Header.Timestamp,
+						Binlog: currentBinlog,
+						StartPos: ev.Header.LogPos - ev.Header.EventSize,
+						StopPos: ev.Header.LogPos,
+						Database: db,
+						Table: tb,
+						QuerySql: sql,
+						RowCnt: rowCnt,
+						QueryType: sqlType,
+					}
+				} else {
+					cfg.StatChan <- BinEventStats{
+						Timestamp: ev.Header.Timestamp,
+						Binlog: currentBinlog,
+						StartPos: tbMapPos,
+						StopPos: ev.Header.LogPos,
+						Database: db,
+						Table: tb,
+						QuerySql: sql,
+						RowCnt: rowCnt,
+						QueryType: sqlType,
+						RowsQuery: rowsQuery,
+					}
 				}
 			}
 		}
@@ -0,0 +1,189 @@
+package base
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_heartbeatTimeoutPeriods = 3 // 超过这么多个心跳周期没有收到任何事件，认为连接已经断开
+)
+
+var (
+	ErrHeartbeatTimeout = errors.New("no binlog event or heartbeat received from master")
+)
+
+// ReplPosTracker repl 模式下跟踪最后一个完整处理的事务边界，连接断开时从这里重新拉取 binlog 。
+//
+// 从事务中间继续拉取时，事务开头的 TABLE_MAP_EVENT 已经丢失，所以总是从事务边界重连，
+// 再跳过重连后收到的、断开之前已经处理过的事件：
+// 基于位置拉取时跳过事务边界之后同样数量的事件；基于 GTID 拉取时(主从切换后位置会变)，
+// 跳过未完成事务的 GTID 事件开始同样数量的事件。
+type ReplPosTracker struct {
+	trx      *TrxPosTracker
+	useGtid  bool
+	boundary *Checkpoint // 最后一个完整处理的事务结束位置，以及已完整处理的 GTID 集合
+
+	trxEvents  int    // 事务边界之后已经处理的事件数
+	gtidEvents int    // 当前事务的 GTID 事件之后已经处理的事件数，包括 GTID 事件
+	waitGtid   string // 重连后等待该 GTID 的事务出现
+	skipEvents int    // 重连后还需要跳过的事件数
+
+	lastEventTime time.Time // 最后收到非心跳事件的时间
+	lastRecvTime  time.Time // 最后收到任何事件(包括心跳)的时间
+}
+
+func NewReplPosTracker(cfg *ConfCmd) *ReplPosTracker {
+	trx := NewTrxPosTracker(cfg)
+	now := time.Now()
+	return &ReplPosTracker{
+		trx:     trx,
+		useGtid: cfg.GtidFilter != nil && cfg.StartGtid != "",
+		boundary: &Checkpoint{
+			Binlog:  cfg.StartFile,
+			Pos:     uint32(cfg.StartPos),
+			GtidSet: trx.gtidSet.String(),
+		},
+		lastEventTime: now,
+		lastRecvTime:  now,
+	}
+}
+
+// GetEvent 读取下一个事件，心跳事件只用来确认连接正常，不返回。
+// 没有指定 -output-toScreen 时，EventTimeout 内没有新的事件认为 binlog 已经解析完成，返回 context.DeadlineExceeded ；
+// 指定了 -heartbeat-period 时，C_heartbeatTimeoutPeriods 个周期内没有收到任何事件返回 ErrHeartbeatTimeout 。
+func (this *ReplPosTracker) GetEvent(cfg *ConfCmd) (*replication.BinlogEvent, error) {
+	for {
+		var (
+			deadline time.Time
+			idle     bool
+		)
+		if !cfg.OutputToScreen {
+			deadline = this.lastEventTime.Add(EventTimeout)
+			idle = true
+		}
+		if cfg.HeartbeatPeriod > 0 {
+			hbDeadline := this.lastRecvTime.Add(cfg.HeartbeatPeriod * C_heartbeatTimeoutPeriods)
+			if deadline.IsZero() || hbDeadline.Before(deadline) {
+				deadline = hbDeadline
+				idle = false
+			}
+		}
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if !deadline.IsZero() {
+			ctx, cancel = context.WithDeadline(context.Background(), deadline)
+		}
+		ev, err := cfg.BinlogStreamer.GetEvent(ctx)
+		cancel()
+		if err == context.DeadlineExceeded && !idle {
+			return nil, errors.Annotatef(ErrHeartbeatTimeout, "in %v", cfg.HeartbeatPeriod*C_heartbeatTimeoutPeriods)
+		} else if err != nil {
+			return nil, err
+		}
+
+		this.lastRecvTime = time.Now()
+		if ev.Header.EventType == replication.HEARTBEAT_EVENT {
+			continue
+		}
+		this.lastEventTime = this.lastRecvTime
+		return ev, nil
+	}
+}
+
+// IsDuplicate 是否为重连后再次收到的、已经处理过的事件，需要在处理每个收到的事件之前调用
+func (this *ReplPosTracker) IsDuplicate(ev *replication.BinlogEvent) bool {
+	// 重连时 mysql 发送的 ROTATE_EVENT 、FORMAT_DESCRIPTION_EVENT 位置为 0 ，不是 binlog 中的事件
+	if ev.Header.LogPos == 0 || ev.Header.Flags&replication.LOG_EVENT_ARTIFICIAL_F != 0 {
+		return false
+	}
+
+	gtid, isGtidEvent := GetGtidOfEvent(ev)
+	if this.waitGtid != "" {
+		if !isGtidEvent || gtid != this.waitGtid {
+			return false
+		}
+		this.waitGtid = ""
+	}
+
+	this.trxEvents++
+	if isGtidEvent {
+		this.gtidEvents = 1
+	} else {
+		this.gtidEvents++
+	}
+
+	if this.skipEvents > 0 {
+		this.skipEvents--
+		return true
+	}
+	return false
+}
+
+// ObserveEvent 根据事件判断事务边界，payload 中的事件要逐个调用，需要在事件被过滤之前调用
+func (this *ReplPosTracker) ObserveEvent(binlog string, ev *replication.BinlogEvent) {
+	if this.trx.ObserveEvent(ev) {
+		this.boundary = this.trx.CommitTrx(binlog, ev.Header.LogPos, ev.Header.Timestamp)
+		this.trxEvents = 0
+		this.gtidEvents = 0
+		return
+	}
+
+	if ev.Header.EventType == replication.ROTATE_EVENT && !this.trx.inTrx {
+		rotateEvent := ev.Event.(*replication.RotateEvent)
+		this.boundary = &Checkpoint{
+			Binlog:  string(rotateEvent.NextLogName),
+			Pos:     uint32(rotateEvent.Position),
+			GtidSet: this.boundary.GtidSet,
+		}
+		// 重连后从新的边界开始，不会再收到这个 ROTATE_EVENT ，只计数新边界之后的事件
+		this.trxEvents = 0
+		this.gtidEvents = 0
+	}
+}
+
+// Reconnect 关闭当前的 syncer ，按 -reconnect-backoff 指数退避，从最后一个事务边界重新拉取 binlog
+func (this *ReplPosTracker) Reconnect(cfg *ConfCmd, cause error) error {
+	// 未完成的事务中已经处理过的事件，重连后跳过
+	if this.useGtid {
+		this.skipEvents = 0
+		if this.trx.currentGtid != "" && this.gtidEvents > 0 {
+			this.waitGtid = this.trx.currentGtid
+			this.skipEvents = this.gtidEvents
+		}
+		log.Warnf("binlog stream from master is broken, reconnect from gtid set [%s] %v", this.boundary.GtidSet, cause)
+	} else {
+		this.skipEvents = this.trxEvents
+		log.Warnf("binlog stream from master is broken, reconnect from %s:%d %v", this.boundary.Binlog, this.boundary.Pos, cause)
+	}
+	this.trxEvents = 0
+	this.gtidEvents = 0
+	this.trx.inTrx = false
+
+	cfg.BinlogSyncer.Close()
+
+	backoff := cfg.ReconnectBackoff
+	for retries := 1; ; retries++ {
+		syncer, streamer, err := NewReplBinlogStreamer(cfg, this.boundary)
+		if err == nil {
+			log.Infof("reconnect to master %s:%d after %d retries", cfg.Host, cfg.Port, retries)
+			cfg.BinlogSyncer, cfg.BinlogStreamer = syncer, streamer
+			this.lastEventTime = time.Now()
+			this.lastRecvTime = this.lastEventTime
+			return nil
+		}
+		if cfg.ReconnectRetries > 0 && retries >= cfg.ReconnectRetries {
+			return errors.Annotatef(err, "give up after %d retries", retries)
+		}
+		log.Warnf("fail to reconnect to master %s:%d, retry in %v %v", cfg.Host, cfg.Port, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > cfg.ReconnectMaxBackoff {
+			backoff = cfg.ReconnectMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/siddontang/go-log/log"
+	"my2sql/dsql"
+)
+
+// 表结构的版本
+//
+// 缓存的表结构是解析开始位置的快照(-table-def-file 或者数据库中的表结构)，之后按 binlog 中的顺序应用 ddl 。
+// ddl 不修改已有的 TblInfoJson ，而是生成新的版本替换缓存中的表结构。读取 rows 事件时记录当时的版本
+// (MyBinEvent.TableInfo)，生成 sql 的协程使用该版本，即使读取协程已经应用了之后的 ddl 。
+
+// QueryDdl QUERY_EVENT 中的语句，并行解析时按顺序交给转发结果的协程应用
+type QueryDdl struct {
+	Database string // 执行语句时的默认库
+	Sql      string
+	Pos      mysql.Position
+}
+
+// ApplyDdl 把 QUERY_EVENT 中的 ddl 应用到缓存的表结构上，只在读取 binlog 的协程中调用。
+// 没有缓存的表不需要修改，之后用到时再获取。ddl 不能应用到缓存的表结构上时(如删除的列不存在)，
+// 说明快照和 binlog 不一致，删除缓存，之后重新获取。
+func (this *TablesColumnsInfo) ApplyDdl(qd *QueryDdl) {
+	stmt, err := this.applyDdl(qd)
+	if err == nil {
+		return
+	}
+	if stmt == nil {
+		log.Warnf("fail to parse ddl at %s: %v", qd.Pos.String(), err)
+		return
+	}
+	for _, tb := range stmt.Tables {
+		this.forgetTable(tb, qd.Pos, err)
+	}
+}
+
+// applyDdl 应用 ddl ，不能解析或者不能应用到缓存的表结构上时返回错误，已经解析出表名时 stmt 不为空
+func (this *TablesColumnsInfo) applyDdl(qd *QueryDdl) (*dsql.DdlStmt, error) {
+	stmt, err := dsql.ParseDdl(qd.Sql, qd.Database)
+	if err != nil || stmt == nil {
+		return stmt, err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if len(this.tableInfos) < 1 {
+		this.tableInfos = map[string]*TblInfoJson{}
+	}
+	switch stmt.Type {
+	case dsql.DdlCreateTable:
+		tbKey := GetAbsTableName(stmt.Create.Table.Database, stmt.Create.Table.Table)
+		if _, ok := this.tableInfos[tbKey]; ok && stmt.IfNotExists {
+			return stmt, nil
+		}
+		tbInfo, err := NewTblInfoFromTableDef(stmt.Create)
+		if err != nil {
+			return stmt, err
+		}
+		this.tableInfos[tbKey] = tbInfo
+		log.Infof("create table structure of %s at %s", tbKey, qd.Pos.String())
+
+	case dsql.DdlCreateTableLike:
+		tbKey := GetAbsTableName(stmt.Tables[0].Database, stmt.Tables[0].Table)
+		if _, ok := this.tableInfos[tbKey]; ok && stmt.IfNotExists {
+			return stmt, nil
+		}
+		like, ok := this.tableInfos[GetAbsTableName(stmt.Like.Database, stmt.Like.Table)]
+		if !ok {
+			this.forgetTableLocked(stmt.Tables[0], qd.Pos, nil)
+			return stmt, nil
+		}
+		tbInfo := like.Clone()
+		tbInfo.Database, tbInfo.Table = stmt.Tables[0].Database, stmt.Tables[0].Table
+		this.tableInfos[tbKey] = tbInfo
+		log.Infof("create table structure of %s like %s at %s", tbKey, GetAbsTableName(like.Database, like.Table), qd.Pos.String())
+
+	case dsql.DdlAlterTable:
+		tbKey := GetAbsTableName(stmt.Tables[0].Database, stmt.Tables[0].Table)
+		old, ok := this.tableInfos[tbKey]
+		if !ok || len(stmt.Specs) == 0 {
+			return stmt, nil
+		}
+		tbInfo := old.Clone()
+		if err = tbInfo.ApplyAlterSpecs(stmt.Specs); err != nil {
+			return stmt, err
+		}
+		delete(this.tableInfos, tbKey)
+		this.tableInfos[GetAbsTableName(tbInfo.Database, tbInfo.Table)] = tbInfo
+		log.Infof("alter table structure of %s at %s", tbKey, qd.Pos.String())
+
+	case dsql.DdlRenameTable:
+		// 按顺序改名，支持 RENAME TABLE a TO tmp, b TO a, tmp TO b
+		for i, from := range stmt.Tables {
+			to := stmt.NewTables[i]
+			fromKey, toKey := GetAbsTableName(from.Database, from.Table), GetAbsTableName(to.Database, to.Table)
+			old, ok := this.tableInfos[fromKey]
+			if !ok {
+				this.forgetTableLocked(to, qd.Pos, nil)
+				continue
+			}
+			tbInfo := old.Clone()
+			tbInfo.Database, tbInfo.Table = to.Database, to.Table
+			delete(this.tableInfos, fromKey)
+			this.tableInfos[toKey] = tbInfo
+			log.Infof("rename table structure of %s to %s at %s", fromKey, toKey, qd.Pos.String())
+		}
+
+	case dsql.DdlDropTable:
+		for _, tb := range stmt.Tables {
+			delete(this.tableInfos, GetAbsTableName(tb.Database, tb.Table))
+		}
+
+	case dsql.DdlDropDatabase:
+		for tbKey, tbInfo := range this.tableInfos {
+			if tbInfo.Database == stmt.Database {
+				delete(this.tableInfos, tbKey)
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// forgetTable 无法跟踪表结构的变化，删除缓存，之后用到时重新获取
+func (this *TablesColumnsInfo) forgetTable(tb dsql.DbTable, pos mysql.Position, reason error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.forgetTableLocked(tb, pos, reason)
+}
+
+// forgetTableLocked 和 forgetTable 一样，调用时持有 this.lock
+func (this *TablesColumnsInfo) forgetTableLocked(tb dsql.DbTable, pos mysql.Position, reason error) {
+	tbKey := GetAbsTableName(tb.Database, tb.Table)
+	if _, ok := this.tableInfos[tbKey]; !ok {
+		return
+	}
+	delete(this.tableInfos, tbKey)
+	if reason != nil {
+		log.Warnf("fail to apply ddl at %s to table structure of %s, get it again when needed: %v", pos.String(), tbKey, reason)
+	}
+}
+
+// NewTblInfoFromTableDef CREATE TABLE 中的列和主键、唯一键
+func NewTblInfoFromTableDef(def *dsql.TableDef) (*TblInfoJson, error) {
+	tbInfo := &TblInfoJson{
+		Database:       def.Table.Database,
+		Table:          def.Table.Table,
+		Columns:        make([]FieldInfo, 0, len(def.Columns)),
+		PrimaryKey:     KeyInfo{},
+		UniqueKeys:     []KeyInfo{},
+		UniqueKeyNames: []string{},
+		Charset:        def.Charset,
+	}
+	for _, col := range def.Columns {
+		if tbInfo.GetColumnIndex(col.Name) >= 0 {
+			return nil, fmt.Errorf("duplicate column %s", col.Name)
+		}
+		tbInfo.Columns = append(tbInfo.Columns, NewFieldInfoFromColumnDef(col))
+	}
+	for _, idx := range def.Indexes {
+		if err := tbInfo.AddIndex(idx); err != nil {
+			return nil, err
+		}
+	}
+	return tbInfo, nil
+}
+
+func NewFieldInfoFromColumnDef(col *dsql.ColumnDef) FieldInfo {
+	fi := FieldInfo{
+		FieldName:   col.Name,
+		FieldType:   col.Type,
+		IsUnsigned:  col.Unsigned,
+		IsGenerated: col.Generated,
+		Charset:     col.Charset,
+	}
+	if IsEnumSetType(col.Type) {
+		fi.Values = col.Args
+	}
+	return fi
+}
+
+// newFieldInfo ALTER TABLE 中的列定义，没有指定字符集的字符串列使用表的默认字符集
+func (this *TblInfoJson) newFieldInfo(col *dsql.ColumnDef) FieldInfo {
+	fi := NewFieldInfoFromColumnDef(col)
+	if fi.Charset == "" && dsql.IsCharacterType(fi.FieldType) {
+		fi.Charset = this.Charset
+	}
+	return fi
+}
+
+// Clone 复制表结构，修改复制的表结构不影响原来的版本
+func (this *TblInfoJson) Clone() *TblInfoJson {
+	tbInfo := &TblInfoJson{
+		Database:       this.Database,
+		Table:          this.Table,
+		Columns:        append([]FieldInfo{}, this.Columns...),
+		PrimaryKey:     append(KeyInfo{}, this.PrimaryKey...),
+		UniqueKeys:     make([]KeyInfo, len(this.UniqueKeys)),
+		UniqueKeyNames: append([]string{}, this.UniqueKeyNames...),
+		Charset:        this.Charset,
+	}
+	for i, key := range this.UniqueKeys {
+		tbInfo.UniqueKeys[i] = append(KeyInfo{}, key...)
+	}
+	return tbInfo
+}
+
+// GetColumnIndex 列名不区分大小写，不存在时返回 -1
+func (this *TblInfoJson) GetColumnIndex(name string) int {
+	for i, col := range this.Columns {
+		if strings.EqualFold(col.FieldName, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// getUniqueKeyIndex 按索引名查找唯一键，不存在(或者没有记录索引名)时返回 -1
+func (this *TblInfoJson) getUniqueKeyIndex(name string) int {
+	for i, kname := range this.UniqueKeyNames {
+		if i < len(this.UniqueKeys) && strings.EqualFold(kname, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ApplyAlterSpecs 按顺序应用 ALTER TABLE 中的操作
+func (this *TblInfoJson) ApplyAlterSpecs(specs []*dsql.AlterSpec) error {
+	for _, spec := range specs {
+		var err error
+		switch spec.Action {
+		case dsql.AlterAddColumn:
+			if this.GetColumnIndex(spec.Column.Name) >= 0 {
+				return fmt.Errorf("column %s already exists", spec.Column.Name)
+			}
+			err = this.insertColumn(this.newFieldInfo(spec.Column), spec)
+
+		case dsql.AlterChangeColumn:
+			ci := this.GetColumnIndex(spec.Name)
+			if ci < 0 {
+				return fmt.Errorf("column %s not found", spec.Name)
+			}
+			if ni := this.GetColumnIndex(spec.Column.Name); ni >= 0 && ni != ci {
+				return fmt.Errorf("column %s already exists", spec.Column.Name)
+			}
+			this.renameKeyColumn(this.Columns[ci].FieldName, spec.Column.Name)
+			if spec.First || spec.After != "" {
+				this.Columns = append(this.Columns[:ci], this.Columns[ci+1:]...)
+				err = this.insertColumn(this.newFieldInfo(spec.Column), spec)
+			} else {
+				this.Columns[ci] = this.newFieldInfo(spec.Column)
+			}
+
+		case dsql.AlterRenameColumn:
+			ci := this.GetColumnIndex(spec.Name)
+			if ci < 0 {
+				return fmt.Errorf("column %s not found", spec.Name)
+			}
+			if ni := this.GetColumnIndex(spec.NewName); ni >= 0 && ni != ci {
+				return fmt.Errorf("column %s already exists", spec.NewName)
+			}
+			this.renameKeyColumn(this.Columns[ci].FieldName, spec.NewName)
+			this.Columns[ci].FieldName = spec.NewName
+
+		case dsql.AlterDropColumn:
+			ci := this.GetColumnIndex(spec.Name)
+			if ci < 0 {
+				return fmt.Errorf("column %s not found", spec.Name)
+			}
+			this.dropKeyColumn(this.Columns[ci].FieldName)
+			this.Columns = append(this.Columns[:ci], this.Columns[ci+1:]...)
+
+		case dsql.AlterAddIndex:
+			err = this.AddIndex(spec.Index)
+
+		case dsql.AlterDropIndex:
+			if strings.EqualFold(spec.Name, dsql.PrimaryKeyName) {
+				this.PrimaryKey = KeyInfo{}
+			} else if ki := this.getUniqueKeyIndex(spec.Name); ki >= 0 {
+				this.UniqueKeys = append(this.UniqueKeys[:ki], this.UniqueKeys[ki+1:]...)
+				this.UniqueKeyNames = append(this.UniqueKeyNames[:ki], this.UniqueKeyNames[ki+1:]...)
+			}
+			// 找不到时是普通索引
+
+		case dsql.AlterRenameIndex:
+			if ki := this.getUniqueKeyIndex(spec.Name); ki >= 0 {
+				this.UniqueKeyNames[ki] = spec.NewName
+			}
+
+		case dsql.AlterRenameTable:
+			this.Database, this.Table = spec.NewTable.Database, spec.NewTable.Table
+
+		case dsql.AlterTableCharset:
+			this.Charset = spec.Charset
+
+		case dsql.AlterConvertCharset:
+			this.Charset = spec.Charset
+			for ci := range this.Columns {
+				if dsql.IsCharacterType(this.Columns[ci].FieldType) {
+					this.Columns[ci].Charset = spec.Charset
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(this.Columns) == 0 {
+		return fmt.Errorf("no column left")
+	}
+	return nil
+}
+
+// insertColumn 按 FIRST/AFTER 插入列，没有指定时加在最后
+func (this *TblInfoJson) insertColumn(col FieldInfo, spec *dsql.AlterSpec) error {
+	ci := len(this.Columns)
+	if spec.First {
+		ci = 0
+	} else if spec.After != "" {
+		ci = this.GetColumnIndex(spec.After)
+		if ci < 0 {
+			return fmt.Errorf("column %s not found", spec.After)
+		}
+		ci++
+	}
+	this.Columns = append(this.Columns, FieldInfo{})
+	copy(this.Columns[ci+1:], this.Columns[ci:])
+	this.Columns[ci] = col
+	return nil
+}
+
+// AddIndex 增加主键或者唯一键，没有索引名的唯一键和 mysql 一样以第一列命名
+func (this *TblInfoJson) AddIndex(idx *dsql.IndexDef) error {
+	key := KeyInfo{}
+	for _, colName := range idx.Columns {
+		ci := this.GetColumnIndex(colName)
+		if ci < 0 {
+			return fmt.Errorf("key column %s not found", colName)
+		}
+		key = append(key, this.Columns[ci].FieldName)
+	}
+
+	if idx.Primary {
+		if len(this.PrimaryKey) > 0 {
+			return fmt.Errorf("multiple primary key defined")
+		}
+		this.PrimaryKey = key
+		return nil
+	}
+
+	name := idx.Name
+	if name == "" {
+		name = key[0]
+		for i := 2; this.getUniqueKeyIndex(name) >= 0; i++ {
+			name = fmt.Sprintf("%s_%d", key[0], i)
+		}
+	}
+	// 索引名和 UniqueKeys 一一对应，来自旧版本 json 文件的表结构没有索引名
+	for len(this.UniqueKeyNames) < len(this.UniqueKeys) {
+		this.UniqueKeyNames = append(this.UniqueKeyNames, "")
+	}
+	this.UniqueKeys = append(this.UniqueKeys, key)
+	this.UniqueKeyNames = append(this.UniqueKeyNames, name)
+	return nil
+}
+
+func (this *TblInfoJson) renameKeyColumn(oldName string, newName string) {
+	keys := append([]KeyInfo{this.PrimaryKey}, this.UniqueKeys...)
+	for _, key := range keys {
+		for i, colName := range key {
+			if strings.EqualFold(colName, oldName) {
+				key[i] = newName
+			}
+		}
+	}
+}
+
+// dropKeyColumn 删除列时 mysql 也从索引中删除该列，索引中没有列时删除索引
+func (this *TblInfoJson) dropKeyColumn(name string) {
+	dropCol := func(key KeyInfo) KeyInfo {
+		newKey := KeyInfo{}
+		for _, colName := range key {
+			if !strings.EqualFold(colName, name) {
+				newKey = append(newKey, colName)
+			}
+		}
+		return newKey
+	}
+
+	this.PrimaryKey = dropCol(this.PrimaryKey)
+	for ki := len(this.UniqueKeys) - 1; ki >= 0; ki-- {
+		this.UniqueKeys[ki] = dropCol(this.UniqueKeys[ki])
+		if len(this.UniqueKeys[ki]) == 0 {
+			this.UniqueKeys = append(this.UniqueKeys[:ki], this.UniqueKeys[ki+1:]...)
+			if ki < len(this.UniqueKeyNames) {
+				this.UniqueKeyNames = append(this.UniqueKeyNames[:ki], this.UniqueKeyNames[ki+1:]...)
+			}
+		}
+	}
+}
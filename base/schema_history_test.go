@@ -0,0 +1,134 @@
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"my2sql/dsql"
+)
+
+// describeTblInfo 把表结构转换为一行文本，便于比较
+func describeTblInfo(tbInfo *TblInfoJson) string {
+	var cols []string
+	for _, col := range tbInfo.Columns {
+		s := col.FieldName + " " + col.FieldType
+		if col.IsUnsigned {
+			s += " unsigned"
+		}
+		if col.IsGenerated {
+			s += " generated"
+		}
+		if col.Charset != "" {
+			s += " " + col.Charset
+		}
+		cols = append(cols, s)
+	}
+	s := tbInfo.Database + "." + tbInfo.Table + ": " + strings.Join(cols, ", ")
+	if len(tbInfo.PrimaryKey) > 0 {
+		s += "; pk(" + strings.Join(tbInfo.PrimaryKey, ",") + ")"
+	}
+	for i, key := range tbInfo.UniqueKeys {
+		name := ""
+		if i < len(tbInfo.UniqueKeyNames) {
+			name = tbInfo.UniqueKeyNames[i]
+		}
+		s += "; uk " + name + "(" + strings.Join(key, ",") + ")"
+	}
+	return s
+}
+
+func TestApplyDdl(t *testing.T) {
+	const createSql = "CREATE TABLE t (id int NOT NULL, name varchar(10), code int, PRIMARY KEY (id), " +
+		"UNIQUE KEY uk_code (code, id)) DEFAULT CHARSET=gbk"
+	cases := []struct {
+		name string
+		ddl  []string
+		want string
+	}{
+		{
+			name: "rename primary key column",
+			ddl:  []string{"ALTER TABLE t CHANGE COLUMN id new_id bigint unsigned NOT NULL"},
+			want: "db.t: new_id bigint unsigned, name varchar gbk, code int; pk(new_id); uk uk_code(code,new_id)",
+		},
+		{
+			name: "rename and move primary key column",
+			ddl:  []string{"ALTER TABLE t CHANGE id new_id int NOT NULL AFTER code, RENAME COLUMN code TO c"},
+			want: "db.t: name varchar gbk, c int, new_id int; pk(new_id); uk uk_code(c,new_id)",
+		},
+		{
+			name: "replace primary key",
+			ddl: []string{
+				"ALTER TABLE t DROP PRIMARY KEY, ADD PRIMARY KEY (code)",
+				"ALTER TABLE t DROP INDEX uk_code, ADD COLUMN email varchar(30) CHARACTER SET utf8mb4 FIRST, ADD UNIQUE uk_email (email)",
+			},
+			want: "db.t: email varchar utf8mb4, id int, name varchar gbk, code int; pk(code); uk uk_email(email)",
+		},
+		{
+			name: "rename table",
+			ddl:  []string{"RENAME TABLE t TO tmp, tmp TO t2", "ALTER TABLE t2 MODIFY name text"},
+			want: "db.t2: id int, name text gbk, code int; pk(id); uk uk_code(code,id)",
+		},
+	}
+
+	for _, c := range cases {
+		tbColsInfo := &TablesColumnsInfo{}
+		for i, sql := range append([]string{createSql}, c.ddl...) {
+			qd := &QueryDdl{Database: "db", Sql: sql, Pos: mysql.Position{Name: "mysql-bin.000001", Pos: uint32(i + 1)}}
+			if _, err := tbColsInfo.applyDdl(qd); err != nil {
+				t.Fatalf("%s: %s: %v", c.name, sql, err)
+			}
+		}
+		if len(tbColsInfo.tableInfos) != 1 {
+			t.Errorf("%s: got %d tables, want 1", c.name, len(tbColsInfo.tableInfos))
+		}
+		for _, tbInfo := range tbColsInfo.tableInfos {
+			if got := describeTblInfo(tbInfo); got != c.want {
+				t.Errorf("%s:\n got: %s\nwant: %s", c.name, got, c.want)
+			}
+		}
+	}
+}
+
+func TestApplyAlterSpecs(t *testing.T) {
+	tbInfo := &TblInfoJson{
+		Database:       "db",
+		Table:          "t",
+		Columns:        []FieldInfo{{FieldName: "id", FieldType: "int"}, {FieldName: "name", FieldType: "varchar", Charset: "utf8mb4"}},
+		PrimaryKey:     KeyInfo{"id"},
+		UniqueKeys:     []KeyInfo{{"name", "id"}},
+		UniqueKeyNames: []string{"uk_name"},
+	}
+	stmt, err := dsql.ParseDdl("ALTER TABLE t CHANGE `ID` `new_id` bigint FIRST", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newInfo := tbInfo.Clone()
+	if err = newInfo.ApplyAlterSpecs(stmt.Specs); err != nil {
+		t.Fatal(err)
+	}
+	want := "db.t: new_id bigint, name varchar utf8mb4; pk(new_id); uk uk_name(name,new_id)"
+	if got := describeTblInfo(newInfo); got != want {
+		t.Errorf("got: %s\nwant: %s", got, want)
+	}
+	// 原来的版本不变
+	want = "db.t: id int, name varchar utf8mb4; pk(id); uk uk_name(name,id)"
+	if got := describeTblInfo(tbInfo); got != want {
+		t.Errorf("original changed: %s", got)
+	}
+
+	// 不能应用的 ddl
+	for _, sql := range []string{
+		"ALTER TABLE t DROP COLUMN x",
+		"ALTER TABLE t ADD COLUMN name int",
+		"ALTER TABLE t CHANGE x y int",
+	} {
+		stmt, err := dsql.ParseDdl(sql, "db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = tbInfo.Clone().ApplyAlterSpecs(stmt.Specs); err == nil {
+			t.Errorf("expect error for %s", sql)
+		}
+	}
+}
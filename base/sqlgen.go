@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/shopspring/decimal"
 	"github.com/siddontang/go-log/log"
 	SQL "my2sql/sqlbuilder"
 	toolkits "my2sql/toolkits"
@@ -123,7 +124,7 @@ func GetMysqlDataTypeNameAndSqlColumn(
 		return "bigint", SQL.IntColumn(colName, SQL.NotNullable)
 
 	case mysql.MYSQL_TYPE_NEWDECIMAL:
-		return "decimal", SQL.DoubleColumn(colName, SQL.NotNullable)
+		return "decimal", SQL.DecimalColumn(colName, SQL.NotNullable)
 
 	case mysql.MYSQL_TYPE_FLOAT:
 		return "float", SQL.DoubleColumn(colName, SQL.NotNullable)
@@ -153,9 +154,9 @@ func GetMysqlDataTypeNameAndSqlColumn(
 	case mysql.MYSQL_TYPE_YEAR:
 		return "year", SQL.IntColumn(colName, SQL.NotNullable)
 	case mysql.MYSQL_TYPE_ENUM:
-		return "enum", SQL.IntColumn(colName, SQL.NotNullable)
+		return "enum", SQL.StrColumn(colName, SQL.UTF8, SQL.UTF8CaseInsensitive, SQL.NotNullable)
 	case mysql.MYSQL_TYPE_SET:
-		return "set", SQL.IntColumn(colName, SQL.NotNullable)
+		return "set", SQL.StrColumn(colName, SQL.UTF8, SQL.UTF8CaseInsensitive, SQL.NotNullable)
 	case mysql.MYSQL_TYPE_BLOB:
 		//text is stored as blob
 		if strings.Contains(strings.ToLower(tpDef), "text") {
@@ -179,28 +180,31 @@ func GetMysqlDataTypeNameAndSqlColumn(
 	}
 }
 
-func GenInsertSqlsForOneRowsEvent(
+// InsertRows 一个 rows 事件生成的 insert 语句，拆分成语句头和各行的值，
+// 用于按 -insert-rows 、-max-statement-bytes 拆分或者合并成多行 insert
+type InsertRows struct {
+	head string   // INSERT INTO `db`.`tb` (`a`,`b`) VALUES
+	rows []string // 每行的值，如 (1,'a')
+	tail string   // 各行之后的部分，如 ON DUPLICATE KEY UPDATE ...
+}
+
+// GenInsertRowsForOneRowsEvent 生成 rows 事件中所有行的 insert 语句，用 InsertRows.Sqls 拆分成多条 sql
+func GenInsertRowsForOneRowsEvent(
 	posStr string,
 	rEv *replication.RowsEvent,
 	colDefs []SQL.NonAliasColumn,
-	rowsPerSql int,						// 一条 sql 负责几个 rows 的插入
 	ifRollback bool,
 	ifprefixDb bool,
 	ifIgnorePrimary bool,
 	primaryIdx []int,
-) []string {
+	generatedIdx []int,					// 生成列不能指定值
+	insertMode string,					// -insert-mode: plain, replace, ignore, upsert
+) *InsertRows {
 
 	var (
-		insertSql  SQL.InsertStatement
-		oneSql     string
-		err        error
-		i          int
-		endIndex   int
 		newColDefs []SQL.NonAliasColumn = colDefs[:]
-		rowCnt     int                  = len(rEv.Rows)
 		schema     string               = string(rEv.Table.Schema)
 		table      string               = string(rEv.Table.Table)
-		sqlArr     []string
 		sqlType    string
 	)
 
@@ -216,56 +220,89 @@ func GenInsertSqlsForOneRowsEvent(
 		ifIgnorePrimary = false
 	}
 
-	// 忽略主键
+	// 忽略主键和生成列
+	ignoredIdx := generatedIdx
 	if ifIgnorePrimary {
-		// 移除主键对应的 ColDefs
-		newColDefs = GetColDefIgnorePrimary(colDefs, primaryIdx)
+		ignoredIdx = append(append([]int{}, primaryIdx...), generatedIdx...)
+	}
+	ifIgnorePrimary = len(ignoredIdx) > 0
+	if ifIgnorePrimary {
+		// 移除主键、生成列对应的 ColDefs
+		newColDefs = GetColDefIgnorePrimary(colDefs, ignoredIdx)
 	}
 
 	// INSERT INTO table_name (column1,column2,column3,...)
 	// VALUES (value1,value2,value3,...);
 
-	// 每次处理 rowsPerSql 个 rows ，生成一条插入语句
-	for i = 0; i < rowCnt; i += rowsPerSql {
-		// 构造插入语句: `INSERT INTO table_name (column1,column2,column3,...) VALUES `
-		insertSql = SQL.NewTable(table, newColDefs...).Insert(newColDefs...)
-
-		// 边界处理，最后一批 rows
-		endIndex = GetMinValue(rowCnt, i+rowsPerSql)
-
-		// 把 rEv.Rows[i:endIndex] 填入到 insertSql 中，生成插入语句
-		oneSql, err = GenInsertSqlForRows(
-			rEv.Rows[i:endIndex], 	// (value1,value2,value3,...), (value1,value2,value3,...), ...
-			insertSql,				//
-			schema,					// database
-			ifprefixDb,				//
-			ifIgnorePrimary,		//
-			primaryIdx,				//
-		)
-		if err != nil {
-			log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %v\n\trows data:%v",
-				sqlType, GetAbsTableName(schema, table), posStr, err, rEv.Rows[i:endIndex]))
-		} else {
-			// 保存生成的语句
-			sqlArr = append(sqlArr, oneSql)
-		}
+	// 构造插入语句: `INSERT INTO table_name (column1,column2,column3,...) VALUES `
+	insertSql := SQL.NewTable(table, newColDefs...).Insert(newColDefs...)
+	SetInsertMode(insertSql, newColDefs, insertMode)
+	// 把 rEv.Rows 填入到 insertSql 中，得到语句头和每行的值
+	insRows, err := GenInsertRows(
+		rEv.Rows, 				// (value1,value2,value3,...), (value1,value2,value3,...), ...
+		insertSql,				//
+		schema,					// database
+		ifprefixDb,				//
+		ifIgnorePrimary,		//
+		ignoredIdx,				//
+	)
+	if err != nil {
+		log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %v\n\trows data:%v",
+			sqlType, GetAbsTableName(schema, table), posStr, err, rEv.Rows))
 	}
+	return insRows
+}
 
-	// 剩余 rows 处理一下 （应该不会出现?)
-	if endIndex < rowCnt {
-		insertSql = SQL.NewTable(table, newColDefs...).Insert(newColDefs...)
-		oneSql, err = GenInsertSqlForRows(rEv.Rows[endIndex:rowCnt], insertSql, schema, ifprefixDb, ifIgnorePrimary, primaryIdx)
-		if err != nil {
-			log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %s\n\trows data:%v",
-				sqlType, GetAbsTableName(schema, table), posStr, err, rEv.Rows[endIndex:rowCnt]))
-		} else {
-			sqlArr = append(sqlArr, oneSql)
+// SetInsertMode 按 -insert-mode 设置 insert 语句遇到重复的主键、唯一键时的处理方式：
+// replace 生成 REPLACE INTO ，ignore 生成 INSERT IGNORE ，upsert 用新的值更新所有列(ON DUPLICATE KEY UPDATE `a`=VALUES(`a`))
+func SetInsertMode(insertSql SQL.InsertStatement, colDefs []SQL.NonAliasColumn, insertMode string) {
+	switch insertMode {
+	case C_insertModeReplace:
+		insertSql.ReplaceDuplicates(true)
+	case C_insertModeIgnore:
+		insertSql.IgnoreDuplicates(true)
+	case C_insertModeUpsert:
+		for _, col := range colDefs {
+			insertSql.AddOnDuplicateKeyUpdate(col, SQL.ColumnValue(col))
 		}
 	}
+}
 
-	//fmt.Println("one insert sqlArr", sqlArr)
+// Sqls 把各行拆分成多条 insert 语句，每条最多 rowsPerSql 行，并且不超过 maxBytes 字节(一行就超过时该行单独一条)。
+// reverse 时每条语句中的行倒序：rollback 的结果文件会逐行倒序，这样各行的顺序和逐行生成语句时一致
+func (this *InsertRows) Sqls(rowsPerSql int, maxBytes int, reverse bool) []string {
+	var sqlArr []string
+	for start := 0; start < len(this.rows); {
+		end := this.batchEnd(start, rowsPerSql, maxBytes)
+		sqlArr = append(sqlArr, this.joinRows(this.rows[start:end], reverse))
+		start = end
+	}
 	return sqlArr
+}
 
+// batchEnd 从第 start 行开始的一条语句的结束位置
+func (this *InsertRows) batchEnd(start int, rowsPerSql int, maxBytes int) int {
+	size := len(this.head) + len(this.tail) + len(this.rows[start])
+	end := start + 1
+	for ; end < len(this.rows) && end-start < rowsPerSql; end++ {
+		// 各行之间用 ", " 分隔
+		size += len(this.rows[end]) + 2
+		if size > maxBytes {
+			break
+		}
+	}
+	return end
+}
+
+func (this *InsertRows) joinRows(rows []string, reverse bool) string {
+	if reverse && len(rows) > 1 {
+		reversed := make([]string, len(rows))
+		for i, row := range rows {
+			reversed[len(rows)-1-i] = row
+		}
+		rows = reversed
+	}
+	return this.head + strings.Join(rows, ", ") + this.tail
 }
 
 func GetColDefIgnorePrimary(colDefs []SQL.NonAliasColumn, primaryIdx []int) []SQL.NonAliasColumn {
@@ -297,7 +334,7 @@ func ConvertRowToExpressRow(row []interface{}, ifIgnorePrimary bool, primaryIdx
 	return valueInserted
 }
 
-func GenInsertSqlForRows(
+func GenInsertRows(
 	rows [][]interface{},
 	insertSql SQL.InsertStatement,
 	schema string,
@@ -305,7 +342,7 @@ func GenInsertSqlForRows(
 	ifIgnorePrimary bool,
 	primaryIdx []int,
 ) (
-	string,
+	*InsertRows,
 	error,
 ) {
 
@@ -319,7 +356,11 @@ func GenInsertSqlForRows(
 		schema = ""
 	}
 
-	return insertSql.String(schema)
+	head, rowStrs, tail, err := insertSql.StringParts(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &InsertRows{head: head, rows: rowStrs, tail: tail}, nil
 
 }
 
@@ -381,12 +422,14 @@ func GenDeleteSqlsForOneRowsEvent(
 		sqlType = "delete"
 	}
 
+	delSqls := make([]SQL.DeleteStatement, rowCnt)
 	// 遍历每个 row
 	for i, row := range rEv.Rows {
 		// 生成 WHERE 子句中的相等条件表达式集合，用 AND 组合起来
 		whereCond := SQL.And(GenEqualConditions(row, colDefs, uniKey, ifFullImage)...)
+		delSqls[i] = SQL.NewTable(table, colDefs...).Delete().Where(whereCond)
 		// 调用 String(schema) 方法将生成的 SQL 语句转换为字符串表示形式
-		sql, err := SQL.NewTable(table, colDefs...).Delete().Where(whereCond).String(schemaInSql)
+		sql, err := delSqls[i].String(schemaInSql)
 		if err != nil {
 			log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %s\n\trows data:%v", sqlType, GetAbsTableName(schema, table), posStr, err, row))
 			//continue
@@ -396,9 +439,43 @@ func GenDeleteSqlsForOneRowsEvent(
 		//sqlArr = append(sqlArr, sql)
 	}
 
+	// 没有主键和唯一键时，相同的行只生成一条 sql ，用 LIMIT 限制删除的行数
+	if len(uniKey) == 0 {
+		firstIdx, counts := GroupIdenticalSqls(sqlArr)
+		sqlArr = make([]string, len(firstIdx))
+		for k, i := range firstIdx {
+			sql, err := delSqls[i].Limit(counts[k]).String(schemaInSql)
+			if err != nil {
+				log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %s\n\trows data:%v", sqlType, GetAbsTableName(schema, table), posStr, err, rEv.Rows[i]))
+			}
+			sqlArr[k] = sql
+		}
+	}
+
 	return sqlArr
 }
 
+// GroupIdenticalSqls 找出完全相同的 sql ，按第一次出现的顺序返回每种 sql 第一次出现的下标和出现的次数。
+// 用于没有主键和唯一键的表：binlog 中相同的行只能用所有字段作为条件，生成一条 LIMIT 为行数的 sql ，
+// 否则表中有重复行时每条 sql 都会影响所有相同的行。
+func GroupIdenticalSqls(sqls []string) ([]int, []int64) {
+	var (
+		firstIdx []int
+		counts   []int64
+		groups   = map[string]int{} // {sql: firstIdx 中的下标}
+	)
+	for i, sql := range sqls {
+		if k, ok := groups[sql]; ok {
+			counts[k]++
+			continue
+		}
+		groups[sql] = len(firstIdx)
+		firstIdx = append(firstIdx, i)
+		counts = append(counts, 1)
+	}
+	return firstIdx, counts
+}
+
 func GenEqualConditions(row []interface{}, colDefs []SQL.NonAliasColumn, uniKey []int, ifFullImage bool) []SQL.BoolExpression {
 	// 如果指定了 uniKey 且无需生成 full image ，就根据 uniKey 生成 where 条件，即可唯一定位到 row 。
 	if !ifFullImage && len(uniKey) > 0 {
@@ -420,8 +497,74 @@ func GenEqualConditions(row []interface{}, colDefs []SQL.NonAliasColumn, uniKey
 	return expArrs
 }
 
-func GenInsertSqlsForOneRowsEventRollbackDelete(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, rowsPerSql int, ifprefixDb bool) []string {
-	return GenInsertSqlsForOneRowsEvent(posStr, rEv, colDefs, rowsPerSql, true, ifprefixDb, false, []int{})
+func GenInsertRowsForOneRowsEventRollbackDelete(posStr string, rEv *replication.RowsEvent, colDefs []SQL.NonAliasColumn, ifprefixDb bool, generatedIdx []int, insertMode string) *InsertRows {
+	return GenInsertRowsForOneRowsEvent(posStr, rEv, colDefs, true, ifprefixDb, false, []int{}, generatedIdx, insertMode)
+}
+
+// GenUpsertForOneRowsEventUpdate -update-as-upsert ，把 update 事件转换为用完整的 after 镜像(回滚时是 before 镜像)
+// 执行 INSERT ... ON DUPLICATE KEY UPDATE ，重复执行时结果不变。
+// 唯一键的值发生变化的行，还要先删除旧的唯一键对应的行，返回这些 delete 语句，按行的顺序排列。
+// 所有 delete 都要在 upsert 之前执行，否则交换唯一键的值(如 update t set id=3-id)时会删除 upsert 之后的行
+func GenUpsertForOneRowsEventUpdate(
+	posStr string,
+	rEv *replication.RowsEvent,
+	colDefs []SQL.NonAliasColumn,
+	uniKey []int,
+	ifRollback bool,
+	ifprefixDb bool,
+	generatedIdx []int,
+) ([]string, *InsertRows) {
+
+	var (
+		schema      string = string(rEv.Table.Schema)
+		table       string = string(rEv.Table.Table)
+		schemaInSql string = schema
+		sqlType     string = "delete_for_update_upsert"
+		delSqls     []string
+		images      [][]interface{}
+	)
+
+	if !ifprefixDb {
+		schemaInSql = ""
+	}
+
+	// 唯一键的条件
+	keyCondition := func(row []interface{}) string {
+		whereCond := SQL.And(GenEqualConditions(row, colDefs, uniKey, false)...)
+		sql, err := SQL.NewTable(table, colDefs...).Delete().Where(whereCond).String(schemaInSql)
+		if err != nil {
+			log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %s\n\trows data:%v", sqlType, GetAbsTableName(schema, table), posStr, err, row))
+		}
+		return sql
+	}
+
+	for i := 0; i+1 < len(rEv.Rows); i += 2 {
+		rowBefore, rowAfter := rEv.Rows[i], rEv.Rows[i+1]
+		if ifRollback {
+			rowBefore, rowAfter = rowAfter, rowBefore
+		}
+		images = append(images, rowAfter)
+		if delSql := keyCondition(rowBefore); delSql != keyCondition(rowAfter) {
+			delSqls = append(delSqls, delSql)
+		}
+	}
+
+	upsertEv := *rEv
+	upsertEv.Rows = images
+	return delSqls, GenInsertRowsForOneRowsEvent(posStr, &upsertEv, colDefs, false, ifprefixDb, false, []int{}, generatedIdx, C_insertModeUpsert)
+}
+
+// JoinUpsertSqls 按执行顺序排列 GenUpsertForOneRowsEventUpdate 的结果：所有 delete 在 upsert 之前。
+// 回滚时结果文件逐行倒序，所以 upsert 在前、delete 在后，并且都倒序
+func JoinUpsertSqls(delSqls []string, upsertRows *InsertRows, rowsPerSql int, maxBytes int, ifRollback bool) []string {
+	if !ifRollback {
+		return append(append([]string{}, delSqls...), upsertRows.Sqls(rowsPerSql, maxBytes, false)...)
+	}
+	sqlArr := upsertRows.Sqls(rowsPerSql, maxBytes, true)
+	for j := len(delSqls) - 1; j >= 0; j-- {
+		sqlArr = append(sqlArr, delSqls[j])
+	}
+	return sqlArr
 }
 
 func GenUpdateSqlsForOneRowsEvent(
@@ -434,6 +577,7 @@ func GenUpdateSqlsForOneRowsEvent(
 	ifFullImage bool,
 	ifRollback bool,  // 如果为 true ，则意味着生成 update 的回滚语句
 	ifprefixDb bool,
+	generatedIdx []int, // 生成列不能 SET
 ) []string {
 
 	//colsTypeNameFromMysql: for text type, which is stored as blob
@@ -443,6 +587,8 @@ func GenUpdateSqlsForOneRowsEvent(
 		table       string = string(rEv.Table.Table)
 		schemaInSql string = schema
 		sqlArr      []string
+		upSqls      []SQL.UpdateStatement
+		rowIdx      []int // sqlArr 中每条 sql 对应的 before 行
 		sql         string
 		err         error
 		sqlType     string
@@ -469,10 +615,10 @@ func GenUpdateSqlsForOneRowsEvent(
 	for i := 0; i < rowCnt; i += 2 {
 		upSql := SQL.NewTable(table, colDefs...).Update() // ... UPDATE table_name ...
 		if ifRollback {
-			upSql = GenUpdateSetPart(colsTypeNameFromMysql, colsTypeName, upSql, colDefs, rEv.Rows[i], rEv.Rows[i+1], ifFullImage)
+			upSql = GenUpdateSetPart(colsTypeNameFromMysql, colsTypeName, upSql, colDefs, rEv.Rows[i], rEv.Rows[i+1], ifFullImage, generatedIdx)
 			wherePart = GenEqualConditions(rEv.Rows[i+1], colDefs, uniKey, ifFullImage)
 		} else {
-			upSql = GenUpdateSetPart(colsTypeNameFromMysql, colsTypeName, upSql, colDefs, rEv.Rows[i+1], rEv.Rows[i], ifFullImage)
+			upSql = GenUpdateSetPart(colsTypeNameFromMysql, colsTypeName, upSql, colDefs, rEv.Rows[i+1], rEv.Rows[i], ifFullImage, generatedIdx)
 			wherePart = GenEqualConditions(rEv.Rows[i], colDefs, uniKey, ifFullImage)
 		}
 		// 设置 where 条件
@@ -484,7 +630,23 @@ func GenUpdateSqlsForOneRowsEvent(
 				sqlType, GetAbsTableName(schema, table), posStr, err, rEv.Rows[i], rEv.Rows[i+1]))
 		} else {
 			sqlArr = append(sqlArr, sql)
+			upSqls = append(upSqls, upSql)
+			rowIdx = append(rowIdx, i)
+		}
+	}
+
+	// 没有主键和唯一键时，相同的行(修改前后都相同)只生成一条 sql ，用 LIMIT 限制更新的行数
+	if len(uniKey) == 0 {
+		firstIdx, counts := GroupIdenticalSqls(sqlArr)
+		grouped := make([]string, len(firstIdx))
+		for k, j := range firstIdx {
+			grouped[k], err = upSqls[j].Limit(counts[k]).String(schemaInSql)
+			if err != nil {
+				log.Fatalf(fmt.Sprintf("Fail to generate %s sql for %s %s \n\terror: %s\n\trows data:%v\n%v",
+					sqlType, GetAbsTableName(schema, table), posStr, err, rEv.Rows[rowIdx[j]], rEv.Rows[rowIdx[j]+1]))
+			}
 		}
+		sqlArr = grouped
 	}
 	//fmt.Println(sqlArr)
 	return sqlArr
@@ -492,6 +654,16 @@ func GenUpdateSqlsForOneRowsEvent(
 }
 
 
+// IsSameColumnValue update 前后的列值是否相同。decimal.Decimal 包含指针，不能用 "==" 比较
+func IsSameColumnValue(after interface{}, before interface{}) bool {
+	// sqltypes.Json 是规范化的 json 文本，sqltypes.Geometry 是 srid 和 WKB ，可以直接用 == 比较
+	if a, ok := after.(decimal.Decimal); ok {
+		b, ok := before.(decimal.Decimal)
+		return ok && a.Equal(b) && a.Exponent() == b.Exponent()
+	}
+	return after == before
+}
+
 // GenUpdateSetPart 根据 rowAfter 和 rowBefore 中有差异的 columns 列值，生成 update 语句，用于将 row 更新为 after 。
 func GenUpdateSetPart(
 	colsTypeNameFromMysql []string,	// 列类型名集合
@@ -501,12 +673,17 @@ func GenUpdateSetPart(
 	rowAfter []interface{},			//
 	rowBefore []interface{},		//
 	ifFullImage bool,				// 如果为 true ，就不考虑具体发生变更的 cols ，而是直接根据 rowAfter 生成完整的 sql 语句。
+	generatedIdx []int,				// 生成列的值由 mysql 计算，不能 SET
 ) SQL.UpdateStatement {
 
 	ifColUpdated := false
 
 	for colIdx, colVal := range rowAfter {
 
+		if toolkits.ContainsInt(generatedIdx, colIdx) {
+			continue
+		}
+
 		// 当前 col 值在 before/after 中是否发生改变
 		ifColUpdated = false
 
@@ -534,14 +711,13 @@ func GenUpdateSetPart(
 						//fmt.Println("bytes compare unequal")
 					}
 				} else {
-					//fmt.Println("error to convert to []byte")
-					//should update the column
-					ifColUpdated = true
+					// json 、geometry 已经转换为 sqltypes.Json 、sqltypes.Geometry ，按语义比较
+					ifColUpdated = !IsSameColumnValue(colVal, rowBefore[colIdx])
 				}
 
 			// 否则，直接用 "==" 来笔记
 			} else {
-				if colVal == rowBefore[colIdx] {
+				if IsSameColumnValue(colVal, rowBefore[colIdx]) {
 					//fmt.Println("compare equal")
 					ifColUpdated = false
 				} else {
@@ -0,0 +1,210 @@
+package base
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	SQL "my2sql/sqlbuilder"
+)
+
+// newTestTable 表 db.t (id int, name varchar(20))
+func newTestTable() (*replication.TableMapEvent, []SQL.NonAliasColumn, []string) {
+	tbMap := &replication.TableMapEvent{
+		Schema:     []byte("db"),
+		Table:      []byte("t"),
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta: []uint16{0, 80},
+	}
+	colNames := []FieldInfo{{FieldName: "id", FieldType: "int"}, {FieldName: "name", FieldType: "varchar"}}
+	colDefs, colTypeNames := GetSqlFieldsEXpressions(len(colNames), colNames, tbMap)
+	return tbMap, colDefs, colTypeNames
+}
+
+func TestInsertRowsSqls(t *testing.T) {
+	rows := []string{"(1)", "(22)", "(333)", "(4444)"}
+	cases := []struct {
+		name       string
+		tail       string
+		rowsPerSql int
+		maxBytes   int
+		reverse    bool
+		want       []string
+	}{
+		{
+			name:       "row count limit",
+			rowsPerSql: 2,
+			maxBytes:   1024,
+			want:       []string{"I (1), (22)", "I (333), (4444)"},
+		},
+		{
+			name:       "one row per sql",
+			rowsPerSql: 1,
+			maxBytes:   1024,
+			want:       []string{"I (1)", "I (22)", "I (333)", "I (4444)"},
+		},
+		{
+			// 2+3, +4+2, +5+2 = 18
+			name:       "byte limit reached exactly",
+			rowsPerSql: 10,
+			maxBytes:   18,
+			want:       []string{"I (1), (22), (333)", "I (4444)"},
+		},
+		{
+			// 不计算 ", " 时 (1),(22),(333) 只有 14 字节
+			name:       "byte limit counts separators",
+			rowsPerSql: 10,
+			maxBytes:   17,
+			want:       []string{"I (1), (22)", "I (333), (4444)"},
+		},
+		{
+			name:       "byte limit counts tail",
+			tail:       "T",
+			rowsPerSql: 10,
+			maxBytes:   18,
+			want:       []string{"I (1), (22)T", "I (333), (4444)T"},
+		},
+		{
+			name:       "row longer than max bytes",
+			rowsPerSql: 10,
+			maxBytes:   4,
+			want:       []string{"I (1)", "I (22)", "I (333)", "I (4444)"},
+		},
+		{
+			name:       "reversed for rollback",
+			rowsPerSql: 3,
+			maxBytes:   1024,
+			reverse:    true,
+			want:       []string{"I (333), (22), (1)", "I (4444)"},
+		},
+	}
+
+	for _, c := range cases {
+		insRows := &InsertRows{head: "I ", rows: rows, tail: c.tail}
+		if got := insRows.Sqls(c.rowsPerSql, c.maxBytes, c.reverse); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\n got: %q\nwant: %q", c.name, got, c.want)
+		}
+	}
+	if got := (&InsertRows{head: "I "}).Sqls(2, 1024, false); len(got) != 0 {
+		t.Errorf("got %q for no rows", got)
+	}
+}
+
+func TestGroupIdenticalSqls(t *testing.T) {
+	firstIdx, counts := GroupIdenticalSqls([]string{"b", "a", "b", "c", "a", "b"})
+	if !reflect.DeepEqual(firstIdx, []int{0, 1, 3}) || !reflect.DeepEqual(counts, []int64{3, 2, 1}) {
+		t.Errorf("got %v %v", firstIdx, counts)
+	}
+	if firstIdx, counts = GroupIdenticalSqls(nil); len(firstIdx) != 0 || len(counts) != 0 {
+		t.Errorf("got %v %v for no sql", firstIdx, counts)
+	}
+}
+
+func TestGenSqlsForKeylessTable(t *testing.T) {
+	tbMap, colDefs, colTypeNames := newTestTable()
+
+	// 没有主键和唯一键，相同的行合并为一条 LIMIT n 的 sql ，按第一次出现的顺序
+	delEv := &replication.RowsEvent{Table: tbMap, Rows: [][]interface{}{
+		{int32(2), "b"}, {int32(1), "a"}, {int32(2), "b"}, {int32(3), "c"}, {int32(2), "b"},
+	}}
+	got := GenDeleteSqlsForOneRowsEvent("pos", delEv, colDefs, nil, false, false, true)
+	want := []string{
+		"DELETE FROM `db`.`t` WHERE (`id`=2 AND `name`='b') LIMIT 3",
+		"DELETE FROM `db`.`t` WHERE (`id`=1 AND `name`='a') LIMIT 1",
+		"DELETE FROM `db`.`t` WHERE (`id`=3 AND `name`='c') LIMIT 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delete:\n got: %q\nwant: %q", got, want)
+	}
+
+	// 回滚 insert
+	insEv := &replication.RowsEvent{Table: tbMap, Rows: [][]interface{}{{int32(1), "a"}, {int32(1), "a"}}}
+	got = GenDeleteSqlsForOneRowsEventRollbackInsert("pos", insEv, colDefs, nil, false, false)
+	want = []string{"DELETE FROM `t` WHERE (`id`=1 AND `name`='a') LIMIT 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rollback insert:\n got: %q\nwant: %q", got, want)
+	}
+
+	// update 修改前后都相同才合并
+	updEv := &replication.RowsEvent{Table: tbMap, Rows: [][]interface{}{
+		{int32(1), "a"}, {int32(1), "z"},
+		{int32(2), "a"}, {int32(2), "z"},
+		{int32(1), "a"}, {int32(1), "z"},
+		{int32(1), "a"}, {int32(1), "y"},
+		{int32(1), "a"}, {int32(1), "z"},
+	}}
+	got = GenUpdateSqlsForOneRowsEvent("pos", colTypeNames, colTypeNames, updEv, colDefs, nil, false, false, false, nil)
+	want = []string{
+		"UPDATE `t` SET `name`='z' WHERE (`id`=1 AND `name`='a') LIMIT 3",
+		"UPDATE `t` SET `name`='z' WHERE (`id`=2 AND `name`='a') LIMIT 1",
+		"UPDATE `t` SET `name`='y' WHERE (`id`=1 AND `name`='a') LIMIT 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("update:\n got: %q\nwant: %q", got, want)
+	}
+
+	got = GenUpdateSqlsForOneRowsEvent("pos", colTypeNames, colTypeNames, updEv, colDefs, nil, false, true, false, nil)
+	want = []string{
+		"UPDATE `t` SET `name`='a' WHERE (`id`=1 AND `name`='z') LIMIT 3",
+		"UPDATE `t` SET `name`='a' WHERE (`id`=2 AND `name`='z') LIMIT 1",
+		"UPDATE `t` SET `name`='a' WHERE (`id`=1 AND `name`='y') LIMIT 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rollback update:\n got: %q\nwant: %q", got, want)
+	}
+
+	// 有主键时每行一条 sql ，没有 LIMIT
+	got = GenDeleteSqlsForOneRowsEvent("pos", delEv, colDefs, []int{0}, false, false, false)
+	if len(got) != len(delEv.Rows) || got[0] != "DELETE FROM `t` WHERE `id`=2" {
+		t.Errorf("delete with primary key: %q", got)
+	}
+}
+
+func TestGenUpsertForOneRowsEventUpdate(t *testing.T) {
+	tbMap := &replication.TableMapEvent{
+		Schema:     []byte("db"),
+		Table:      []byte("t"),
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta: []uint16{0, 80, 80},
+	}
+	colNames := []FieldInfo{
+		{FieldName: "id", FieldType: "int"},
+		{FieldName: "name", FieldType: "varchar"},
+		{FieldName: "full", FieldType: "varchar", IsGenerated: true},
+	}
+	colDefs, _ := GetSqlFieldsEXpressions(len(colNames), colNames, tbMap)
+
+	// update t set id=3-id ：前两行交换了主键，第三行主键不变
+	ev := &replication.RowsEvent{Table: tbMap, Rows: [][]interface{}{
+		{int32(1), "a", "1a"}, {int32(2), "a", "2a"},
+		{int32(2), "b", "2b"}, {int32(1), "b", "1b"},
+		{int32(3), "c", "3c"}, {int32(3), "d", "3d"},
+	}}
+
+	// 主键变化的行先 delete 旧主键，再 upsert ；生成列不出现在列和 ON DUPLICATE KEY UPDATE 中
+	delSqls, upsertRows := GenUpsertForOneRowsEventUpdate("pos", ev, colDefs, []int{0}, false, false, []int{2})
+	got := JoinUpsertSqls(delSqls, upsertRows, 2, 1024, false)
+	want := []string{
+		"DELETE FROM `t` WHERE `id`=1",
+		"DELETE FROM `t` WHERE `id`=2",
+		"INSERT INTO `t` (`id`,`name`) VALUES (2,'a'), (1,'b') ON DUPLICATE KEY UPDATE `id`=VALUES(`id`), `name`=VALUES(`name`)",
+		"INSERT INTO `t` (`id`,`name`) VALUES (3,'d') ON DUPLICATE KEY UPDATE `id`=VALUES(`id`), `name`=VALUES(`name`)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("upsert:\n got: %q\nwant: %q", got, want)
+	}
+
+	// 回滚：结果文件逐行倒序后，仍然是 delete 在 upsert 之前
+	delSqls, upsertRows = GenUpsertForOneRowsEventUpdate("pos", ev, colDefs, []int{0}, true, false, []int{2})
+	got = JoinUpsertSqls(delSqls, upsertRows, 2, 1024, true)
+	want = []string{
+		"INSERT INTO `t` (`id`,`name`) VALUES (2,'b'), (1,'a') ON DUPLICATE KEY UPDATE `id`=VALUES(`id`), `name`=VALUES(`name`)",
+		"INSERT INTO `t` (`id`,`name`) VALUES (3,'c') ON DUPLICATE KEY UPDATE `id`=VALUES(`id`), `name`=VALUES(`name`)",
+		"DELETE FROM `t` WHERE `id`=1",
+		"DELETE FROM `t` WHERE `id`=2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rollback upsert:\n got: %q\nwant: %q", got, want)
+	}
+}
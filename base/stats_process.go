@@ -6,6 +6,7 @@ import (
 	"github.com/go-mysql-org/go-mysql/replication"
 	"github.com/siddontang/go-log/log"
 	constvar "my2sql/constvar"
+	"strconv"
 	"strings"
 	"sync"
 	//"path/filepath"
@@ -21,10 +22,14 @@ var (
 		"datetime", "binlog", "startpos", "stoppos", "sql",
 	}
 	Stats_BigLongTrx_Header_Column_names []string = []string{
-		"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows", "duration", "tables",
+		"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows", "duration", "tables", "rows_queries",
 	}
 )
 
+const (
+	C_bigLongTrxMaxRowsQueries = 10 // biglong_trx.txt 中每个事务最多输出的原始语句数
+)
+
 // BinEventStats 事件统计
 type BinEventStats struct {
 	Timestamp     uint32		// 事件时间
@@ -37,6 +42,7 @@ type BinEventStats struct {
 	RowCnt        uint32		// 当前事件包含多少行
 	QuerySql      string        // for type=query
 	ParsedSqlInfo *dsql.SqlInfo // for ddl
+	RowsQuery     string        // 产生 rows 事件的原始语句，没有开启 binlog_rows_query_log_events 时为空
 }
 
 // OrgSqlPrint 原始语句
@@ -84,11 +90,16 @@ type BigLongTrxInfo struct {
 	// }
 	//
 	Statements map[string]map[string]uint32
+
+	// 事务中的原始语句(ROWS_QUERY_EVENT/MARIADB_ANNOTATE_ROWS_EVENT)，最多 C_bigLongTrxMaxRowsQueries 条
+	RowsQueries    []string
+	RowsQueriesCnt int    // 原始语句总数
+	lastRowsQuery  string // 最后一条原始语句，RowsQueries 满了之后仍然用来去掉连续相同的语句
 }
 
 func GetBigLongTrxPrintHeaderLine(headers []string) string {
-	//{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows","duration", "tables"}
-	return fmt.Sprintf("%-17s %-19s %-19s %-10s %-10s %-8s %-10s %s\n", ConvertStrArrToIntferfaceArrForPrint(headers)...)
+	//{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows","duration", "tables", "rows_queries"}
+	return fmt.Sprintf("%-17s %-19s %-19s %-10s %-10s %-8s %-10s %s %s\n", ConvertStrArrToIntferfaceArrForPrint(headers)...)
 }
 
 func GetStatsPrintHeaderLine(headers []string) string {
@@ -254,6 +265,8 @@ func ProcessBinEventStats(cfg *ConfCmd, wg *sync.WaitGroup) {
 			if oneBigLong.StartTime == 0 {
 				oneBigLong.StartTime = st.Timestamp
 			}
+			// 原始语句，同一条语句会产生多个 rows 事件
+			oneBigLong.AddRowsQuery(st.RowsQuery)
 			// 保存 db.tb
 			dbtbKeyes = append(dbtbKeyes, dbtbKey)
 		}
@@ -332,8 +345,8 @@ func GetStatsPrintContentLine(st *BinEventStatsPrint) string {
 }
 
 func GetBigLongTrxContentLine(blTrx BigLongTrxInfo) string {
-	//{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows", "duration", "tables"}
-	return fmt.Sprintf("%-17s %-19s %-19s %-10d %-10d %-8d %-10d %s\n", blTrx.Binlog,
+	//{"binlog", "starttime", "stoptime", "startpos", "stoppos", "rows", "duration", "tables", "rows_queries"}
+	return fmt.Sprintf("%-17s %-19s %-19s %-10d %-10d %-8d %-10d %s %s\n", blTrx.Binlog,
 		GetDatetimeStr(int64(blTrx.StartTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
 		GetDatetimeStr(int64(blTrx.StopTime), int64(0), constvar.DATETIME_FORMAT_NOSPACE),
 		blTrx.StartPos,
@@ -341,9 +354,36 @@ func GetBigLongTrxContentLine(blTrx BigLongTrxInfo) string {
 		blTrx.RowCnt,
 		blTrx.Duration,
 		GetBigLongTrxStatementsStr(blTrx.Statements),
+		GetBigLongTrxRowsQueriesStr(blTrx),
 	)
 }
 
+// AddRowsQuery 记录事务中的原始语句，连续相同的语句只记录一次
+func (this *BigLongTrxInfo) AddRowsQuery(query string) {
+	if query == "" {
+		return
+	}
+	if query == this.lastRowsQuery {
+		return
+	}
+	this.lastRowsQuery = query
+	this.RowsQueriesCnt++
+	if len(this.RowsQueries) < C_bigLongTrxMaxRowsQueries {
+		this.RowsQueries = append(this.RowsQueries, query)
+	}
+}
+
+func GetBigLongTrxRowsQueriesStr(blTrx BigLongTrxInfo) string {
+	strArr := make([]string, 0, len(blTrx.RowsQueries)+1)
+	for _, query := range blTrx.RowsQueries {
+		strArr = append(strArr, strconv.Quote(query))
+	}
+	if more := blTrx.RowsQueriesCnt - len(blTrx.RowsQueries); more > 0 {
+		strArr = append(strArr, fmt.Sprintf("...(%d more)", more))
+	}
+	return fmt.Sprintf("[%s]", strings.Join(strArr, " "))
+}
+
 func GetBigLongTrxStatementsStr(st map[string]map[string]uint32) string {
 	strArr := make([]string, len(st))
 	var i int = 0
@@ -0,0 +1,256 @@
+package base
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+	"my2sql/dsql"
+	toolkits "my2sql/toolkits"
+)
+
+// 表结构缓存
+//
+// G_TablesColumnsInfo 由读取 binlog 的协程修改(应用 ddl、查询不在缓存中的表)，生成 sql 的多个协程读取，用读写锁保护。
+// 启动时用两次 information_schema 查询加载所有需要解析的表，几万个表时也比逐个表查询快得多；
+// 之后不在缓存中的表(如新建的表)用同样的查询逐个获取。
+
+const (
+	C_tableColumnsQuery = "SELECT c.TABLE_SCHEMA, c.TABLE_NAME, c.COLUMN_NAME, c.COLUMN_TYPE, c.EXTRA, c.CHARACTER_SET_NAME " +
+		"FROM information_schema.COLUMNS c JOIN information_schema.TABLES t ON t.TABLE_SCHEMA = c.TABLE_SCHEMA AND t.TABLE_NAME = c.TABLE_NAME " +
+		"WHERE t.TABLE_TYPE = 'BASE TABLE' AND %s ORDER BY c.TABLE_SCHEMA, c.TABLE_NAME, c.ORDINAL_POSITION"
+	C_tableUniqueKeysQuery = "SELECT s.TABLE_SCHEMA, s.TABLE_NAME, s.INDEX_NAME, s.COLUMN_NAME FROM information_schema.STATISTICS s " +
+		"WHERE s.NON_UNIQUE = 0 AND %s ORDER BY s.TABLE_SCHEMA, s.TABLE_NAME, s.INDEX_NAME, s.SEQ_IN_INDEX"
+)
+
+// getTableInfo 缓存的表结构
+func (this *TablesColumnsInfo) getTableInfo(tbKey string) (*TblInfoJson, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	tbInfo, ok := this.tableInfos[tbKey]
+	return tbInfo, ok
+}
+
+// setTableInfos 保存表结构，overwrite 为 false 时不覆盖已经缓存的表，返回保存的数量
+func (this *TablesColumnsInfo) setTableInfos(tbDefs []*TblInfoJson, overwrite bool) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.tableInfos == nil {
+		this.tableInfos = map[string]*TblInfoJson{}
+	}
+	cnt := 0
+	for _, tbInfo := range tbDefs {
+		tbKey := GetAbsTableName(tbInfo.Database, tbInfo.Table)
+		if _, ok := this.tableInfos[tbKey]; ok && !overwrite {
+			continue
+		}
+		this.tableInfos[tbKey] = tbInfo
+		cnt++
+	}
+	return cnt
+}
+
+// connectDb 需要时才连接 mysql ，连接失败后不再重试。调用时持有 fetchLock
+func (this *TablesColumnsInfo) connectDb(cfg *ConfCmd) error {
+	if cfg.FromDB != nil {
+		return nil
+	}
+	if this.dbErr != nil {
+		return this.dbErr
+	}
+	db, err := CreateMysqlCon(GetMysqlUrl(cfg))
+	if err != nil {
+		this.dbErr = errors.Annotatef(err, "fail to connect to mysql")
+		return this.dbErr
+	}
+	cfg.FromDB = db
+	return nil
+}
+
+// GetTbDefFromDb 查询 mysql 服务器，获取 db.tb 的表信息(字段、索引)，替换缓存中的版本。
+// 只在连接不上 mysql 时返回错误，查询失败(如表不存在)时缓存不变
+func (this *TablesColumnsInfo) GetTbDefFromDb(cfg *ConfCmd, dbname string, tbname string) error {
+	this.fetchLock.Lock()
+	defer this.fetchLock.Unlock()
+	if err := this.connectDb(cfg); err != nil {
+		return err
+	}
+	tbDefs, err := QueryTableDefs(cfg, cfg.FromDB, dbname, tbname)
+	if err != nil {
+		log.Errorf("fail to get table struct of %s: %v", GetAbsTableName(dbname, tbname), err)
+		return nil
+	}
+	this.setTableInfos(tbDefs, true)
+	return nil
+}
+
+// RefreshTableInfo 重新查询 mysql 获取 db.tb 的表结构，用于缓存的表结构已经过时(如 rows 事件的列比缓存的多)
+func (this *TablesColumnsInfo) RefreshTableInfo(schema string, table string) (*TblInfoJson, error) {
+	tbKey := GetAbsTableName(schema, table)
+	if GConfCmd.OnlyColFromFile {
+		return nil, fmt.Errorf("can not refresh table struct of %s with -only-table-def-file", tbKey)
+	}
+	if err := this.GetTbDefFromDb(GConfCmd, schema, table); err != nil {
+		return nil, err
+	}
+	if tbInfo, ok := this.getTableInfo(tbKey); ok {
+		return tbInfo, nil
+	}
+	return nil, fmt.Errorf("table struct not found for %s, maybe it was dropped", tbKey)
+}
+
+// PreloadTableDefs 启动时一次性加载需要解析的所有表(按 -databases/-tables/-ignore-* 过滤)，
+// 已经在缓存中的表(来自 -table-def-file)不覆盖
+func (this *TablesColumnsInfo) PreloadTableDefs(cfg *ConfCmd) error {
+	this.fetchLock.Lock()
+	defer this.fetchLock.Unlock()
+	if err := this.connectDb(cfg); err != nil {
+		return err
+	}
+	start := time.Now()
+	tbDefs, err := QueryTableDefs(cfg, cfg.FromDB, "", "")
+	if err != nil {
+		return err
+	}
+	cnt := this.setTableInfos(tbDefs, false)
+	log.Infof("preload %d table definitions from mysql in %s", cnt, time.Since(start).String())
+	return nil
+}
+
+// IsTableInFilter db.tb 是否满足 -databases/-tables/-ignore-databases/-ignore-tables
+func IsTableInFilter(cfg *ConfCmd, db string, tb string) bool {
+	if len(cfg.Databases) > 0 && !toolkits.ContainsString(cfg.Databases, db) {
+		return false
+	}
+	if len(cfg.Tables) > 0 && !toolkits.ContainsString(cfg.Tables, tb) {
+		return false
+	}
+	return !toolkits.ContainsString(cfg.IgnoreDatabases, db) && !toolkits.ContainsString(cfg.IgnoreTables, tb)
+}
+
+// tableDefsCondition 查询 information_schema 的条件，dbname 为空时是需要解析的所有库(没有 -databases 时是系统库之外的所有库)
+func tableDefsCondition(cfg *ConfCmd, alias string, dbname string, tbname string) (string, []interface{}) {
+	if dbname != "" {
+		return fmt.Sprintf("%s.TABLE_SCHEMA = ? AND %s.TABLE_NAME = ?", alias, alias), []interface{}{dbname, tbname}
+	}
+	dbs, op := cfg.Databases, "IN"
+	if len(dbs) == 0 {
+		dbs, op = GSystemDatabases, "NOT IN"
+	}
+	args := make([]interface{}, len(dbs))
+	for i, db := range dbs {
+		args[i] = db
+	}
+	return fmt.Sprintf("%s.TABLE_SCHEMA %s (%s)", alias, op, strings.TrimSuffix(strings.Repeat("?,", len(dbs)), ",")), args
+}
+
+// QueryTableDefs 查询 information_schema 获取表的字段、主键和唯一键，按库名、表名排序。
+// dbname 和 tbname 为空时获取需要解析的所有表，否则只获取 dbname.tbname ，表不存在时返回空
+func QueryTableDefs(cfg *ConfCmd, db *sql.DB, dbname string, tbname string) ([]*TblInfoJson, error) {
+	var (
+		tbDefs []*TblInfoJson
+		tables = map[string]*TblInfoJson{}
+		last   *TblInfoJson
+	)
+
+	cond, args := tableDefsCondition(cfg, "c", dbname, tbname)
+	query := fmt.Sprintf(C_tableColumnsQuery, cond)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Annotatef(err, "fail to query mysql: %s", query)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			dbName, tbName, colName, colType, extra string
+			charset                                 sql.NullString
+		)
+		if err = rows.Scan(&dbName, &tbName, &colName, &colType, &extra, &charset); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if dbname == "" && !IsTableInFilter(cfg, dbName, tbName) {
+			continue
+		}
+		if last == nil || last.Database != dbName || last.Table != tbName {
+			last = &TblInfoJson{
+				Database:       dbName,
+				Table:          tbName,
+				Columns:        []FieldInfo{},
+				PrimaryKey:     KeyInfo{},
+				UniqueKeys:     []KeyInfo{},
+				UniqueKeyNames: []string{},
+			}
+			tbDefs = append(tbDefs, last)
+			tables[GetAbsTableName(dbName, tbName)] = last
+		}
+		last.Columns = append(last.Columns, FieldInfo{
+			FieldName:   colName,
+			FieldType:   GetFiledType(colType),
+			IsUnsigned:  IsUnsigned(colType),
+			IsGenerated: IsGeneratedColumnExtra(extra),
+			Charset:     charset.String,
+			Values:      GetEnumSetValues(colType),
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(tbDefs) == 0 {
+		return tbDefs, nil
+	}
+
+	cond, args = tableDefsCondition(cfg, "s", dbname, tbname)
+	query = fmt.Sprintf(C_tableUniqueKeysQuery, cond)
+	keyRows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Annotatef(err, "fail to query mysql: %s", query)
+	}
+	defer keyRows.Close()
+
+	var (
+		keyTable   *TblInfoJson
+		keyName    string
+		keyCols    KeyInfo
+		functional bool
+	)
+	// 一个索引的各列是连续的行，读完一个索引后保存
+	addKey := func() {
+		if keyTable == nil || functional || len(keyCols) == 0 {
+			return
+		}
+		if strings.EqualFold(keyName, dsql.PrimaryKeyName) {
+			keyTable.PrimaryKey = keyCols
+		} else {
+			keyTable.UniqueKeys = append(keyTable.UniqueKeys, keyCols)
+			keyTable.UniqueKeyNames = append(keyTable.UniqueKeyNames, keyName)
+		}
+	}
+	for keyRows.Next() {
+		var (
+			dbName, tbName, idxName string
+			colName                 sql.NullString
+		)
+		if err = keyRows.Scan(&dbName, &tbName, &idxName, &colName); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tbInfo, ok := tables[GetAbsTableName(dbName, tbName)]
+		if !ok {
+			continue
+		}
+		if tbInfo != keyTable || idxName != keyName {
+			addKey()
+			keyTable, keyName, keyCols, functional = tbInfo, idxName, KeyInfo{}, false
+		}
+		// 函数索引的列名是 NULL ，不能用作 where 条件
+		if !colName.Valid {
+			functional = true
+			continue
+		}
+		keyCols = append(keyCols, colName.String)
+	}
+	addKey()
+	return tbDefs, errors.Trace(keyRows.Err())
+}
@@ -0,0 +1,300 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	C_binaryCollationId = 63 // binary 字符集，区分 blob/text、varbinary/varchar、binary/char
+)
+
+var (
+	G_TableMetaProvider TableMetaProvider
+
+	// GeometryTypeMap 中的类型编号对应的类型名
+	geometryTypeNames []string = []string{"geometry", "point", "linestring", "polygon", "multipoint",
+		"multilinestring", "multipolygon", "geometrycollection"}
+)
+
+type tableMapInfo struct {
+	tbMap  *replication.TableMapEvent
+	tbInfo *TblInfoJson
+}
+
+// TableMetaProvider 提供 rows 事件的表结构。
+//
+// mysql 8.0 binlog_row_metadata=FULL 时 TABLE_MAP_EVENT 中有字段名、符号、字符集、主键，优先使用，
+// 这和写 binlog 时的表结构一致，不受之后 DDL 的影响；TABLE_MAP_EVENT 中没有唯一键，
+// 唯一键仍然取自 G_TablesColumnsInfo(-table-def-file 或者数据库)，查不到时只用主键。
+// 没有完整元数据时使用 G_TablesColumnsInfo 。
+// 不是并发安全的，只在读取事件的协程中调用。
+type TableMetaProvider struct {
+	tables    map[string]tableMapInfo // {db.tb: 最近一个 TABLE_MAP_EVENT 生成的表结构}
+	warned    map[string]string       // {db.tb: 最近一次打印的警告}，同样的警告只打印一次
+	refreshed map[string]uint64       // {db.tb: 重新查询表结构时 rows 事件的列数}，同样的列数只重新查询一次
+}
+
+// GetTableInfo 获取 rows 事件 tbMap 对应的表结构
+func (this *TableMetaProvider) GetTableInfo(tbMap *replication.TableMapEvent) (*TblInfoJson, error) {
+	schema, table := string(tbMap.Schema), string(tbMap.Table)
+	if len(tbMap.ColumnName) == 0 || uint64(len(tbMap.ColumnName)) != tbMap.ColumnCount {
+		tbInfo, err := G_TablesColumnsInfo.GetTableInfoJson(schema, table)
+		if err == nil && uint64(len(tbInfo.Columns)) < tbMap.ColumnCount {
+			tbInfo = this.refreshTableInfo(tbMap, tbInfo)
+		}
+		return tbInfo, err
+	}
+
+	tbKey := GetAbsTableName(schema, table)
+	// 同一个 TABLE_MAP_EVENT 后面的多个 rows 事件不需要重复生成
+	if cached, ok := this.tables[tbKey]; ok && cached.tbMap == tbMap {
+		return cached.tbInfo, nil
+	}
+
+	tbInfo := NewTblInfoFromTableMap(tbMap)
+	fallback, err := G_TablesColumnsInfo.LookupTableInfo(schema, table)
+	if err != nil {
+		this.warnOnce(tbKey, fmt.Sprintf("use table struct of %s from TABLE_MAP_EVENT without unique keys: %v", tbKey, err))
+	} else {
+		if diffs := CompareTableInfo(tbInfo, fallback); len(diffs) > 0 {
+			this.warnOnce(tbKey, fmt.Sprintf("table struct of %s in TABLE_MAP_EVENT differs from %s, use the one in TABLE_MAP_EVENT: %s",
+				tbKey, GetTableDefSource(), strings.Join(diffs, "; ")))
+		}
+		// TABLE_MAP_EVENT 中没有生成列的信息，未知编号的字符集、没有的 enum/set 取值也取自 fallback
+		for ci := range tbInfo.Columns {
+			if fi := fallback.GetColumnIndex(tbInfo.Columns[ci].FieldName); fi >= 0 {
+				tbInfo.Columns[ci].IsGenerated = fallback.Columns[fi].IsGenerated
+				if tbInfo.Columns[ci].Charset == "" {
+					tbInfo.Columns[ci].Charset = fallback.Columns[fi].Charset
+				}
+				if len(tbInfo.Columns[ci].Values) == 0 {
+					tbInfo.Columns[ci].Values = fallback.Columns[fi].Values
+				}
+			}
+		}
+		// 只保留所有字段都还在的唯一键
+		for i, uk := range fallback.UniqueKeys {
+			if len(GetColIndexFromKey(uk, tbInfo.Columns)) != len(uk) {
+				continue
+			}
+			tbInfo.UniqueKeys = append(tbInfo.UniqueKeys, uk)
+			if i < len(fallback.UniqueKeyNames) {
+				tbInfo.UniqueKeyNames = append(tbInfo.UniqueKeyNames, fallback.UniqueKeyNames[i])
+			}
+		}
+		if len(tbInfo.UniqueKeyNames) != len(tbInfo.UniqueKeys) {
+			tbInfo.UniqueKeyNames = nil
+		}
+	}
+
+	if this.tables == nil {
+		this.tables = map[string]tableMapInfo{}
+	}
+	this.tables[tbKey] = tableMapInfo{tbMap: tbMap, tbInfo: tbInfo}
+	return tbInfo, nil
+}
+
+// refreshTableInfo 缓存的表结构比 rows 事件的列少，已经过时，重新查询 mysql
+func (this *TableMetaProvider) refreshTableInfo(tbMap *replication.TableMapEvent, tbInfo *TblInfoJson) *TblInfoJson {
+	tbKey := GetAbsTableName(string(tbMap.Schema), string(tbMap.Table))
+	if cnt, ok := this.refreshed[tbKey]; ok && cnt == tbMap.ColumnCount {
+		return tbInfo
+	}
+	if this.refreshed == nil {
+		this.refreshed = map[string]uint64{}
+	}
+	this.refreshed[tbKey] = tbMap.ColumnCount
+
+	log.Warnf("table struct of %s has %d columns, less than %d in rows event, query it from mysql again",
+		tbKey, len(tbInfo.Columns), tbMap.ColumnCount)
+	newInfo, err := G_TablesColumnsInfo.RefreshTableInfo(string(tbMap.Schema), string(tbMap.Table))
+	if err != nil {
+		log.Warnf("fail to refresh table struct of %s: %v", tbKey, err)
+		return tbInfo
+	}
+	return newInfo
+}
+
+func (this *TableMetaProvider) warnOnce(tbKey string, msg string) {
+	if this.warned[tbKey] == msg {
+		return
+	}
+	if this.warned == nil {
+		this.warned = map[string]string{}
+	}
+	this.warned[tbKey] = msg
+	log.Warnf("%s", msg)
+}
+
+// GetTableDefSource 表结构来源，用于打印日志
+func GetTableDefSource() string {
+	if GConfCmd.ReadTblDefJsonFile != "" {
+		return GConfCmd.ReadTblDefJsonFile
+	}
+	return "mysql"
+}
+
+// NewTblInfoFromTableMap 用 binlog_row_metadata=FULL 的 TABLE_MAP_EVENT 生成表结构，没有唯一键
+func NewTblInfoFromTableMap(tbMap *replication.TableMapEvent) *TblInfoJson {
+	var (
+		names      = tbMap.ColumnNameString()
+		unsigned   = tbMap.UnsignedMap()
+		collations = tbMap.CollationMap()
+		geoTypes   = tbMap.GeometryTypeMap()
+		enumSets   = tbMap.EnumSetCollationMap()
+		enumValues = tbMap.EnumStrValueMap()
+		setValues  = tbMap.SetStrValueMap()
+	)
+	tbInfo := &TblInfoJson{
+		Database:   string(tbMap.Schema),
+		Table:      string(tbMap.Table),
+		Columns:    make([]FieldInfo, len(names)),
+		PrimaryKey: KeyInfo{},
+		UniqueKeys: []KeyInfo{},
+	}
+	for i, name := range names {
+		tbInfo.Columns[i] = FieldInfo{
+			FieldName:  name,
+			FieldType:  GetColumnTypeFromTableMap(tbMap, i, collations, geoTypes),
+			IsUnsigned: unsigned[i],
+		}
+		if coll, ok := collations[i]; ok {
+			tbInfo.Columns[i].Charset = GetCharsetOfCollationId(coll)
+		}
+		// enum/set 的取值
+		values, ok := enumValues[i]
+		if !ok {
+			values, ok = setValues[i]
+		}
+		if ok {
+			tbInfo.Columns[i].Values = DecodeEnumSetValues(values, GetCharsetOfCollationId(enumSets[i]))
+		}
+	}
+	for _, ci := range tbMap.PrimaryKey {
+		if int(ci) < len(names) {
+			tbInfo.PrimaryKey = append(tbInfo.PrimaryKey, names[ci])
+		}
+	}
+	return tbInfo
+}
+
+// GetColumnTypeFromTableMap 第 i 列的类型名，和 SHOW COLUMNS 的类型去掉长度之后一致，例如 int、varchar、text
+func GetColumnTypeFromTableMap(tbMap *replication.TableMapEvent, i int, collations map[int]uint64, geoTypes map[int]uint64) string {
+	// char 列的类型是 MYSQL_TYPE_STRING ，enum/set 的真实类型在 meta 中
+	if tbMap.IsEnumColumn(i) {
+		return "enum"
+	}
+	if tbMap.IsSetColumn(i) {
+		return "set"
+	}
+	binary := false
+	if coll, ok := collations[i]; ok {
+		binary = coll == C_binaryCollationId
+	}
+
+	switch tbMap.ColumnType[i] {
+	case mysql.MYSQL_TYPE_TINY:
+		return "tinyint"
+	case mysql.MYSQL_TYPE_SHORT:
+		return "smallint"
+	case mysql.MYSQL_TYPE_INT24:
+		return "mediumint"
+	case mysql.MYSQL_TYPE_LONG:
+		return "int"
+	case mysql.MYSQL_TYPE_LONGLONG:
+		return "bigint"
+	case mysql.MYSQL_TYPE_DECIMAL, mysql.MYSQL_TYPE_NEWDECIMAL:
+		return "decimal"
+	case mysql.MYSQL_TYPE_FLOAT:
+		return "float"
+	case mysql.MYSQL_TYPE_DOUBLE:
+		return "double"
+	case mysql.MYSQL_TYPE_BIT:
+		return "bit"
+	case mysql.MYSQL_TYPE_YEAR:
+		return "year"
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE:
+		return "date"
+	case mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_TIME2:
+		return "time"
+	case mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_DATETIME2:
+		return "datetime"
+	case mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIMESTAMP2:
+		return "timestamp"
+	case mysql.MYSQL_TYPE_JSON:
+		return "json"
+	case mysql.MYSQL_TYPE_GEOMETRY:
+		if gt, ok := geoTypes[i]; ok && int(gt) < len(geometryTypeNames) {
+			return geometryTypeNames[gt]
+		}
+		return "geometry"
+	case mysql.MYSQL_TYPE_STRING:
+		if binary {
+			return "binary"
+		}
+		return "char"
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING:
+		if binary {
+			return "varbinary"
+		}
+		return "varchar"
+	case mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_TINY_BLOB, mysql.MYSQL_TYPE_MEDIUM_BLOB, mysql.MYSQL_TYPE_LONG_BLOB:
+		// meta 是长度占用的字节数
+		prefix := ""
+		switch tbMap.ColumnMeta[i] {
+		case 1:
+			prefix = "tiny"
+		case 3:
+			prefix = "medium"
+		case 4:
+			prefix = "long"
+		}
+		if binary {
+			return prefix + "blob"
+		}
+		return prefix + "text"
+	}
+	return C_unknownColType
+}
+
+// CompareTableInfo 比较字段名、字段类型、符号、主键，返回不一致的地方
+func CompareTableInfo(tbInfo *TblInfoJson, other *TblInfoJson) []string {
+	var diffs []string
+	if len(tbInfo.Columns) != len(other.Columns) {
+		diffs = append(diffs, fmt.Sprintf("column count %d != %d", len(tbInfo.Columns), len(other.Columns)))
+	}
+	for i := 0; i < len(tbInfo.Columns) && i < len(other.Columns); i++ {
+		col, otherCol := tbInfo.Columns[i], other.Columns[i]
+		if !strings.EqualFold(col.FieldName, otherCol.FieldName) {
+			diffs = append(diffs, fmt.Sprintf("column %d name %s != %s", i+1, col.FieldName, otherCol.FieldName))
+			continue
+		}
+		if !strings.EqualFold(col.FieldType, otherCol.FieldType) {
+			diffs = append(diffs, fmt.Sprintf("column %s type %s != %s", col.FieldName, col.FieldType, otherCol.FieldType))
+		} else if col.IsUnsigned != otherCol.IsUnsigned {
+			diffs = append(diffs, fmt.Sprintf("column %s unsigned %t != %t", col.FieldName, col.IsUnsigned, otherCol.IsUnsigned))
+		}
+	}
+	if !keyInfoEqualFold(tbInfo.PrimaryKey, other.PrimaryKey) {
+		diffs = append(diffs, fmt.Sprintf("primary key (%s) != (%s)",
+			strings.Join(tbInfo.PrimaryKey, ","), strings.Join(other.PrimaryKey, ",")))
+	}
+	return diffs
+}
+
+func keyInfoEqualFold(a KeyInfo, b KeyInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
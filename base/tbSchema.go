@@ -1,11 +1,165 @@
 package base
 
 import (
-//	"github.com/openark/golib/log"
- // "github.com/siddontang/go-log/log"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+	"my2sql/dsql"
+)
+
+const (
+	C_cmdDumpSchema = "dump-schema" // my2sql dump-schema -table-def-file ... ，把表结构导出到 json 文件
+)
+
+var (
+	// 没有指定 -databases 时，dump-schema 和预加载表结构不包括的系统库
+	GSystemDatabases []string = []string{"mysql", "information_schema", "performance_schema", "sys"}
 )
 
+// LoadTableDefsFromFile 读取 dump-schema 导出的表结构，保存到本地缓存，之后解析这些表的 rows 事件时不需要查询 mysql
+func (this *TablesColumnsInfo) LoadTableDefsFromFile(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return errors.Annotatef(err, "fail to read table definition file %s", fileName)
+	}
+	var tbDefs []*TblInfoJson
+	if err = json.Unmarshal(data, &tbDefs); err != nil {
+		return errors.Annotatef(err, "fail to parse table definition file %s", fileName)
+	}
+
+	for _, tbDef := range tbDefs {
+		if tbDef.Database == "" || tbDef.Table == "" || len(tbDef.Columns) == 0 {
+			return errors.Errorf("invalid table definition in %s: database, table and columns are required", fileName)
+		}
+		if tbDef.PrimaryKey == nil {
+			tbDef.PrimaryKey = KeyInfo{}
+		}
+		if tbDef.UniqueKeys == nil {
+			tbDef.UniqueKeys = []KeyInfo{}
+		}
+	}
+	this.setTableInfos(tbDefs, true)
+	log.Infof("load %d table definitions from %s", len(tbDefs), fileName)
+	return nil
+}
+
+// IsSqlTableDefPath -table-def-file 是 .sql 文件或者目录时，从其中的 CREATE TABLE 语句获取表结构
+func IsSqlTableDefPath(path string) bool {
+	if strings.HasSuffix(strings.ToLower(path), ".sql") {
+		return true
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// GetSqlFilesOfPath path 是目录时返回其中按文件名排序的 *.sql 文件(如迁移脚本 V1__init.sql、V2__add_col.sql)
+func GetSqlFilesOfPath(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(strings.ToLower(entry.Name()), ".sql") {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("no .sql file found in %s", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// LoadTableDefsFromSql 从 mysqldump --no-data 导出的文件，或者迁移脚本目录中的 *.sql 文件获取表结构，
+// 按顺序应用其中的 CREATE/ALTER/RENAME/DROP TABLE 等 ddl ，其它语句忽略。
+// USE db 切换默认库，没有 USE 时默认库是 -databases 中唯一的库。
+func (this *TablesColumnsInfo) LoadTableDefsFromSql(path string) error {
+	files, err := GetSqlFilesOfPath(path)
+	if err != nil {
+		return errors.Annotatef(err, "fail to read table definition sql %s", path)
+	}
+
+	useDb := ""
+	if len(GConfCmd.Databases) == 1 {
+		useDb = GConfCmd.Databases[0]
+	}
+	for _, fileName := range files {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return errors.Annotatef(err, "fail to read table definition sql %s", fileName)
+		}
+		stmts, err := dsql.SplitStatements(string(data))
+		if err != nil {
+			return errors.Annotatef(err, "fail to split statements of %s", fileName)
+		}
+		for _, stmt := range stmts {
+			if db, ok := dsql.ParseUse(stmt.Sql); ok {
+				useDb = db
+				continue
+			}
+			qd := &QueryDdl{Database: useDb, Sql: stmt.Sql, Pos: mysql.Position{Name: fileName, Pos: uint32(stmt.Line)}}
+			if _, err = this.applyDdl(qd); err != nil {
+				return errors.Annotatef(err, "fail to apply ddl at line %d of %s", stmt.Line, fileName)
+			}
+		}
+	}
 
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for tbKey, tbInfo := range this.tableInfos {
+		if tbInfo.Database == "" {
+			return errors.Errorf("no database for table %s in %s, add USE db to the sql or specify one database by -databases", tbKey, path)
+		}
+	}
+	log.Infof("load %d table definitions from %s", len(this.tableInfos), path)
+	return nil
+}
 
+// DumpTableDefsToFile 查询 mysql 中的所有表(按 -databases/-tables/-ignore-* 过滤)的字段和索引，以 json 格式写入 fileName
+func (this *TablesColumnsInfo) DumpTableDefsToFile(cfg *ConfCmd, fileName string) (int, error) {
+	tbDefs, err := QueryTableDefs(cfg, cfg.FromDB, "", "")
+	if err != nil {
+		return 0, err
+	}
+	if tbDefs == nil {
+		tbDefs = []*TblInfoJson{}
+	}
 
+	data, err := json.MarshalIndent(tbDefs, "", "  ")
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	// 先写临时文件再改名，避免中途失败留下不完整的文件
+	tmpFile := fileName + ".tmp"
+	if err = ioutil.WriteFile(tmpFile, append(data, '\n'), 0644); err != nil {
+		return 0, errors.Annotatef(err, "fail to write table definition file %s", tmpFile)
+	}
+	if err = os.Rename(tmpFile, fileName); err != nil {
+		return 0, errors.Annotatef(err, "fail to rename %s to %s", tmpFile, fileName)
+	}
+	return len(tbDefs), nil
+}
 
+// DumpSchema my2sql dump-schema ，导出表结构，之后可以用 -table-def-file 在没有数据库的情况下解析 binlog 文件
+func DumpSchema(cfg *ConfCmd) {
+	cnt, err := G_TablesColumnsInfo.DumpTableDefsToFile(cfg, cfg.DumpTblDefToFile)
+	if err != nil {
+		log.Fatalf("fail to dump table definitions %v", err)
+	}
+	log.Infof("dump %d table definitions into %s", cnt, cfg.DumpTblDefToFile)
+}
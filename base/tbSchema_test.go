@@ -0,0 +1,68 @@
+package base
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTableDefsFromSql(t *testing.T) {
+	dir, err := ioutil.TempDir("", "my2sql_tbschema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// mysqldump --no-data --databases db1 db2 导出的文件
+	dump := "-- MySQL dump 10.13  Distrib 8.0.32\n" +
+		"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+		"/*!40101 SET NAMES utf8mb4 */;\n" +
+		"/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;\n" +
+		"\n" +
+		"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `db1` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;\n" +
+		"USE `db1`;\n" +
+		"DROP TABLE IF EXISTS `t1`;\n" +
+		"/*!40101 SET @saved_cs_client     = @@character_set_client */;\n" +
+		"CREATE TABLE `t1` (\n" +
+		"  `id` int unsigned NOT NULL,\n" +
+		"  `note` varchar(20) DEFAULT 'a;b' COMMENT 'x; -- y',\n" +
+		"  PRIMARY KEY (`id`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=latin1 COMMENT='semi;colon';\n" +
+		"/*!40101 SET character_set_client = @saved_cs_client */;\n" +
+		"\n" +
+		"USE `db2`;\n" +
+		"/* t2; */\n" +
+		"CREATE TABLE `t1` (\n" +
+		"  `a` int NOT NULL, -- a;\n" +
+		"  `b` varchar(10) NOT NULL, # b;\n" +
+		"  UNIQUE KEY `uk_b` (`b`)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n" +
+		"/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;\n"
+	path := filepath.Join(dir, "dump.sql")
+	if err = ioutil.WriteFile(path, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tbColsInfo := &TablesColumnsInfo{}
+	if err = tbColsInfo.LoadTableDefsFromSql(path); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		GetAbsTableName("db1", "t1"): "db1.t1: id int unsigned, note varchar latin1; pk(id)",
+		GetAbsTableName("db2", "t1"): "db2.t1: a int, b varchar utf8mb4; uk uk_b(b)",
+	}
+	if len(tbColsInfo.tableInfos) != len(want) {
+		t.Errorf("got %d tables, want %d", len(tbColsInfo.tableInfos), len(want))
+	}
+	for tbKey, desc := range want {
+		tbInfo, ok := tbColsInfo.tableInfos[tbKey]
+		if !ok {
+			t.Errorf("table %s not found", tbKey)
+			continue
+		}
+		if got := describeTblInfo(tbInfo); got != desc {
+			t.Errorf("%s:\n got: %s\nwant: %s", tbKey, got, desc)
+		}
+	}
+}
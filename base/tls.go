@@ -0,0 +1,90 @@
+package base
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/juju/errors"
+)
+
+const (
+	C_tlsConfigName = "my2sql" // 注册到 go-sql-driver 的 TLS 配置名，DSN 中 tls=my2sql
+	C_passwordEnv   = "MYSQL_PWD"
+)
+
+// IfSetTLS 指定了任何一个 -ssl-* 参数就使用 TLS 连接
+func (this *ConfCmd) IfSetTLS() bool {
+	return this.SslCa != "" || this.SslCert != "" || this.SslKey != "" || this.SslServerName != "" || this.SslSkipVerify
+}
+
+// NewTLSConfig 根据 -ssl-* 参数生成 TLS 配置，repl 模式的 syncer 和查询表结构的连接共用。
+// 同时注册到 go-sql-driver ，GetMysqlUrl 生成的 DSN 中引用。
+func NewTLSConfig(cfg *ConfCmd) (*tls.Config, error) {
+	if !cfg.IfSetTLS() {
+		return nil, nil
+	}
+
+	// go-mysql 的 client 不会自动设置 ServerName ，不跳过校验时必须指定
+	tlsCfg := &tls.Config{
+		ServerName:         cfg.SslServerName,
+		InsecureSkipVerify: cfg.SslSkipVerify,
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = cfg.Host
+	}
+
+	if cfg.SslCa != "" {
+		caPem, err := ioutil.ReadFile(cfg.SslCa)
+		if err != nil {
+			return nil, errors.Annotatef(err, "fail to read -ssl-ca %s", cfg.SslCa)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			return nil, errors.Errorf("no valid certificate found in -ssl-ca %s", cfg.SslCa)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.SslCert != "" || cfg.SslKey != "" {
+		if cfg.SslCert == "" || cfg.SslKey == "" {
+			return nil, errors.Errorf("-ssl-cert and -ssl-key must be specified together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.SslCert, cfg.SslKey)
+		if err != nil {
+			return nil, errors.Annotatef(err, "fail to load -ssl-cert %s -ssl-key %s", cfg.SslCert, cfg.SslKey)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if err := gomysql.RegisterTLSConfig(C_tlsConfigName, tlsCfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return tlsCfg, nil
+}
+
+// LoadPassword 密码优先级：-password > -password-file > 环境变量 MYSQL_PWD ，避免密码出现在进程列表中
+func (this *ConfCmd) LoadPassword() error {
+	if this.Passwd != "" {
+		if this.PasswdFile != "" {
+			return errors.Errorf("-password and -password-file can not be specified at the same time")
+		}
+		return nil
+	}
+
+	if this.PasswdFile != "" {
+		data, err := ioutil.ReadFile(this.PasswdFile)
+		if err != nil {
+			return errors.Annotatef(err, "fail to read -password-file %s", this.PasswdFile)
+		}
+		// 去掉文件末尾的换行
+		this.Passwd = strings.TrimRight(string(data), "\r\n")
+		return nil
+	}
+
+	this.Passwd = os.Getenv(C_passwordEnv)
+	return nil
+}
@@ -0,0 +1,957 @@
+package dsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ddl 语句类型，DdlStmt.Type
+const (
+	DdlCreateTable     = iota + 1 // CREATE TABLE t (...)
+	DdlCreateTableLike            // CREATE TABLE t LIKE s
+	DdlAlterTable                 // ALTER TABLE, CREATE INDEX, DROP INDEX
+	DdlRenameTable                // RENAME TABLE a TO b, c TO d
+	DdlDropTable                  // DROP TABLE a, b
+	DdlDropDatabase               // DROP DATABASE db
+)
+
+// ALTER TABLE 中修改列和唯一键的操作，AlterSpec.Action
+const (
+	AlterAddColumn      = iota + 1 // ADD COLUMN
+	AlterChangeColumn              // CHANGE COLUMN, MODIFY COLUMN
+	AlterRenameColumn              // RENAME COLUMN a TO b
+	AlterDropColumn                // DROP COLUMN
+	AlterAddIndex                  // ADD PRIMARY KEY, ADD UNIQUE KEY
+	AlterDropIndex                 // DROP PRIMARY KEY, DROP INDEX
+	AlterRenameIndex               // RENAME INDEX a TO b
+	AlterRenameTable               // RENAME TO
+	AlterTableCharset              // [DEFAULT] CHARACTER SET ，修改表的默认字符集
+	AlterConvertCharset            // CONVERT TO CHARACTER SET ，修改表和所有字符串列的字符集
+)
+
+// PrimaryKeyName 主键的索引名
+const PrimaryKeyName = "PRIMARY"
+
+// ColumnDef 列定义
+type ColumnDef struct {
+	Name      string
+	Type      string   // 小写的类型名，如 int varchar enum ，同义词转换为 SHOW COLUMNS 中的名字
+	Args      []string // 类型参数，如 varchar(20) 的 20 ，enum('a','b') 的 a 和 b
+	Unsigned  bool
+	Primary   bool   // 列定义中的 PRIMARY KEY
+	Unique    bool   // 列定义中的 UNIQUE
+	Generated bool   // 生成列 [GENERATED ALWAYS] AS (expr)
+	Charset   string // 小写的字符集，CREATE TABLE 中没有指定时取自 COLLATE 或者表的默认字符集
+	Collate   string // 小写的 COLLATE
+}
+
+// IndexDef 主键或者唯一键，普通索引不需要
+type IndexDef struct {
+	Name    string
+	Primary bool
+	Columns []string
+}
+
+// TableDef CREATE TABLE 中的列和唯一键
+type TableDef struct {
+	Table   DbTable
+	Columns []*ColumnDef
+	Indexes []*IndexDef
+	Charset string // 表的默认字符集，没有指定时为空
+}
+
+// AlterSpec ALTER TABLE 中的一个操作，不影响列和唯一键的操作(如 ADD INDEX、ENGINE=)不返回
+type AlterSpec struct {
+	Action   int
+	Name     string     // 修改、删除的列名或者索引名
+	Column   *ColumnDef // ADD/CHANGE/MODIFY 的列定义
+	First    bool       // FIRST
+	After    string     // AFTER 列名
+	Index    *IndexDef  // ADD PRIMARY KEY/UNIQUE
+	NewName  string     // RENAME COLUMN/INDEX 的新名字
+	NewTable DbTable    // RENAME TO
+	Charset  string     // AlterTableCharset、AlterConvertCharset 的字符集
+}
+
+// DdlStmt 修改表结构的 ddl
+type DdlStmt struct {
+	Type        int
+	Tables      []DbTable    // 修改、删除的表，RENAME TABLE 的原表
+	NewTables   []DbTable    // RENAME TABLE 的新表，和 Tables 一一对应
+	Create      *TableDef    // CREATE TABLE
+	Like        DbTable      // CREATE TABLE t LIKE s 的 s
+	Specs       []*AlterSpec // ALTER TABLE
+	IfNotExists bool         // CREATE TABLE IF NOT EXISTS
+	Database    string       // DROP DATABASE
+}
+
+// ParseDdl 解析修改表结构的 ddl ，useDb 是语句执行时的默认库。
+// 不是 CREATE/ALTER/RENAME/DROP TABLE、CREATE/DROP INDEX 和 DROP DATABASE 时返回 nil, nil 。
+// 返回 error 时如果已经解析出了表名，stmt 不为空，其中只有 Type 和 Tables 。
+func ParseDdl(sql string, useDb string) (*DdlStmt, error) {
+	p := &ddlParser{lex: NewLexer(sql), useDb: useDb}
+	stmt, err := p.parse()
+	if err == nil {
+		err = p.lexErr
+	}
+	if err != nil {
+		err = fmt.Errorf("%v, sql: %s", err, sql)
+	}
+	return stmt, err
+}
+
+// ParseColumnType 解析 information_schema.COLUMNS.COLUMN_TYPE ，如 enum('a','b')、int(10) unsigned
+func ParseColumnType(colType string) (*ColumnDef, error) {
+	p := &ddlParser{lex: NewLexer(colType)}
+	col := &ColumnDef{}
+	err := p.parseDataType(col)
+	col.Unsigned = col.Unsigned || p.accept("UNSIGNED")
+	if err == nil {
+		err = p.lexErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v, column type: %s", err, colType)
+	}
+	return col, nil
+}
+
+type ddlParser struct {
+	lex    *Lexer
+	toks   []Token // 预读的 token
+	useDb  string
+	stmt   *DdlStmt
+	lexErr error // 引号不完整等词法错误，解析完成后返回
+}
+
+func (this *ddlParser) peekN(n int) Token {
+	for len(this.toks) <= n {
+		tok, err := this.lex.Next()
+		if err != nil {
+			// 引号不完整，当作语句结束，解析完成后返回这个错误
+			tok = Token{Kind: TokEOF}
+			if this.lexErr == nil {
+				this.lexErr = err
+			}
+		}
+		this.toks = append(this.toks, tok)
+	}
+	return this.toks[n]
+}
+
+func (this *ddlParser) peek() Token {
+	return this.peekN(0)
+}
+
+func (this *ddlParser) next() Token {
+	tok := this.peek()
+	if tok.Kind != TokEOF {
+		this.toks = this.toks[1:]
+	}
+	return tok
+}
+
+// accept 接下来的 token 是关键字 keywords 时跳过它们，返回 true
+func (this *ddlParser) accept(keywords ...string) bool {
+	for i, kw := range keywords {
+		if !this.peekN(i).Is(kw) {
+			return false
+		}
+	}
+	this.toks = this.toks[len(keywords):]
+	return true
+}
+
+func (this *ddlParser) acceptPunct(p string) bool {
+	if this.peek().IsPunct(p) {
+		this.next()
+		return true
+	}
+	return false
+}
+
+func (this *ddlParser) expectPunct(p string) error {
+	if !this.acceptPunct(p) {
+		return this.errorf("expect %s", p)
+	}
+	return nil
+}
+
+func (this *ddlParser) errorf(format string, args ...interface{}) error {
+	tok := this.peek()
+	if tok.Kind == TokEOF {
+		return fmt.Errorf(format+" at end of statement", args...)
+	}
+	return fmt.Errorf(format+" near %q", append(args, tok.Val)...)
+}
+
+func (this *ddlParser) name() (string, error) {
+	tok := this.peek()
+	if !tok.IsName() {
+		return "", this.errorf("expect name")
+	}
+	this.next()
+	return tok.Val, nil
+}
+
+// tableName db.tb 或者 tb
+func (this *ddlParser) tableName() (DbTable, error) {
+	name, err := this.name()
+	if err != nil {
+		return DbTable{}, err
+	}
+	if this.acceptPunct(".") {
+		tb, err := this.name()
+		if err != nil {
+			return DbTable{}, err
+		}
+		return DbTable{Database: name, Table: tb}, nil
+	}
+	return DbTable{Database: this.useDb, Table: name}, nil
+}
+
+// skipGroup 跳过括号中的内容，当前 token 是 (
+func (this *ddlParser) skipGroup() error {
+	depth := 0
+	for {
+		tok := this.next()
+		switch {
+		case tok.Kind == TokEOF:
+			return this.errorf("unbalanced parentheses")
+		case tok.IsPunct("("):
+			depth++
+		case tok.IsPunct(")"):
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// skipItem 跳过当前的项，直到同一层的 , 或者 ) 或者语句结束，不跳过 , 和 )
+func (this *ddlParser) skipItem() error {
+	for {
+		tok := this.peek()
+		switch {
+		case tok.Kind == TokEOF || tok.IsPunct(",") || tok.IsPunct(")") || tok.IsPunct(";"):
+			return nil
+		case tok.IsPunct("("):
+			if err := this.skipGroup(); err != nil {
+				return err
+			}
+		default:
+			this.next()
+		}
+	}
+}
+
+func (this *ddlParser) atEnd() bool {
+	tok := this.peek()
+	return tok.Kind == TokEOF || tok.IsPunct(";")
+}
+
+func (this *ddlParser) parse() (*DdlStmt, error) {
+	switch {
+	case this.accept("CREATE"):
+		this.accept("OR", "REPLACE")
+		if this.accept("TEMPORARY") {
+			// 临时表在 row 格式下不会产生 rows 事件
+			return nil, nil
+		}
+		if this.accept("TABLE") {
+			return this.parseCreateTable()
+		}
+		this.accept("ONLINE") // mariadb
+		this.accept("OFFLINE")
+		if this.accept("UNIQUE") {
+			if this.accept("INDEX") || this.accept("KEY") {
+				return this.parseCreateIndex()
+			}
+		}
+		return nil, nil
+	case this.accept("ALTER"):
+		this.accept("ONLINE")
+		this.accept("IGNORE")
+		if !this.accept("TABLE") {
+			return nil, nil
+		}
+		return this.parseAlterTable()
+	case this.accept("RENAME"):
+		if !this.accept("TABLE") && !this.accept("TABLES") {
+			return nil, nil
+		}
+		return this.parseRenameTable()
+	case this.accept("DROP"):
+		if this.accept("TEMPORARY") {
+			return nil, nil
+		}
+		if this.accept("TABLE") || this.accept("TABLES") {
+			return this.parseDropTable()
+		}
+		this.accept("ONLINE")
+		this.accept("OFFLINE")
+		if this.accept("INDEX") {
+			return this.parseDropIndex()
+		}
+		if this.accept("DATABASE") || this.accept("SCHEMA") {
+			this.accept("IF", "EXISTS")
+			db, err := this.name()
+			if err != nil {
+				return nil, err
+			}
+			return &DdlStmt{Type: DdlDropDatabase, Database: db}, nil
+		}
+	}
+	return nil, nil
+}
+
+// CREATE TABLE [IF NOT EXISTS] t (...) | LIKE s | (LIKE s)
+func (this *ddlParser) parseCreateTable() (*DdlStmt, error) {
+	stmt := &DdlStmt{Type: DdlCreateTable}
+	stmt.IfNotExists = this.accept("IF", "NOT", "EXISTS")
+	tb, err := this.tableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Tables = []DbTable{tb}
+
+	if this.accept("LIKE") || this.peek().IsPunct("(") && this.peekN(1).Is("LIKE") && this.acceptPunct("(") && this.accept("LIKE") {
+		stmt.Type = DdlCreateTableLike
+		if stmt.Like, err = this.tableName(); err != nil {
+			return stmt, err
+		}
+		return stmt, nil
+	}
+
+	// CREATE TABLE ... SELECT 没有列定义，或者只有部分列定义
+	if !this.acceptPunct("(") {
+		return stmt, this.errorf("column definitions not found")
+	}
+	stmt.Create = &TableDef{Table: tb}
+	for {
+		if err = this.parseCreateDefinition(stmt.Create); err != nil {
+			return stmt, err
+		}
+		if this.acceptPunct(",") {
+			continue
+		}
+		if err = this.expectPunct(")"); err != nil {
+			return stmt, err
+		}
+		break
+	}
+	if this.skipTableOptions(stmt.Create) {
+		return stmt, this.errorf("CREATE TABLE ... SELECT is not supported")
+	}
+	// 没有指定字符集的字符串列使用表的默认字符集
+	for _, col := range stmt.Create.Columns {
+		if col.Charset == "" && IsCharacterType(col.Type) {
+			col.Charset = stmt.Create.Charset
+		}
+	}
+	return stmt, nil
+}
+
+// skipTableOptions 跳过表选项和分区定义，记录表的默认字符集，返回是否有 SELECT
+func (this *ddlParser) skipTableOptions(def *TableDef) bool {
+	collate := ""
+	for !this.atEnd() {
+		tok := this.peek()
+		if tok.Is("SELECT") || tok.Is("AS") || tok.Is("IGNORE") || tok.Is("REPLACE") {
+			return true
+		}
+		if tok.IsPunct("(") {
+			this.skipGroup()
+			continue
+		}
+		if charset, isCollate, ok := this.acceptCharset(); ok {
+			if isCollate {
+				collate = charset
+			} else {
+				def.Charset = charset
+			}
+			continue
+		}
+		this.next()
+	}
+	if def.Charset == "" {
+		def.Charset = GetCharsetOfCollation(collate)
+	}
+	return false
+}
+
+// acceptCharset 跳过 CHARACTER SET [=] x、CHARSET [=] x 或者 COLLATE [=] x ，返回小写的 x 和是否是 COLLATE
+func (this *ddlParser) acceptCharset() (string, bool, bool) {
+	isCollate := this.peek().Is("COLLATE")
+	if !isCollate && !this.accept("CHARACTER", "SET") && !this.accept("CHARSET") && !this.accept("CHAR", "SET") {
+		return "", false, false
+	}
+	if isCollate {
+		this.next()
+	}
+	this.acceptPunct("=")
+	tok := this.next()
+	return strings.ToLower(tok.Val), isCollate, true
+}
+
+// IsCharacterType 是否是有字符集的类型
+func IsCharacterType(tp string) bool {
+	switch tp {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set":
+		return true
+	}
+	return false
+}
+
+// GetCharsetOfCollation 校对规则所属的字符集，如 utf8mb4_general_ci 的 utf8mb4
+func GetCharsetOfCollation(collate string) string {
+	if i := strings.IndexByte(collate, '_'); i > 0 {
+		return collate[:i]
+	}
+	return collate
+}
+
+// parseCreateDefinition CREATE TABLE 括号中的一项：列定义或者索引、约束
+func (this *ddlParser) parseCreateDefinition(def *TableDef) error {
+	idx, isIndex, err := this.parseIndexDefinition()
+	if err != nil {
+		return err
+	}
+	if isIndex {
+		if idx != nil {
+			def.Indexes = append(def.Indexes, idx)
+		}
+		return nil
+	}
+
+	col, err := this.parseColumnDefinition(nil)
+	if err != nil {
+		return err
+	}
+	def.Columns = append(def.Columns, col)
+	if col.Primary {
+		def.Indexes = append(def.Indexes, &IndexDef{Name: PrimaryKeyName, Primary: true, Columns: []string{col.Name}})
+	} else if col.Unique {
+		def.Indexes = append(def.Indexes, &IndexDef{Columns: []string{col.Name}})
+	}
+	return nil
+}
+
+// parseIndexDefinition 索引或者约束定义，返回是否是索引或约束，不是主键和唯一键时返回的 IndexDef 为空
+func (this *ddlParser) parseIndexDefinition() (*IndexDef, bool, error) {
+	symbol := ""
+	if this.accept("CONSTRAINT") {
+		// 约束名，CONSTRAINT PRIMARY KEY 时没有
+		if !this.peek().Is("PRIMARY") && !this.peek().Is("UNIQUE") && !this.peek().Is("FOREIGN") && !this.peek().Is("CHECK") {
+			symbol = this.next().Val
+		}
+	}
+
+	switch {
+	case this.accept("PRIMARY", "KEY"):
+		idx, err := this.parseKeyParts(&IndexDef{Name: PrimaryKeyName, Primary: true})
+		return idx, true, err
+	case this.accept("UNIQUE"):
+		if !this.accept("INDEX") {
+			this.accept("KEY")
+		}
+		// 没有索引名时，用约束名作为索引名
+		idx := &IndexDef{Name: symbol}
+		if this.peek().IsName() && !this.peek().Is("USING") {
+			idx.Name = this.next().Val
+		}
+		idx, err := this.parseKeyParts(idx)
+		return idx, true, err
+	case this.peek().Is("INDEX") || this.peek().Is("KEY") || this.peek().Is("FULLTEXT") || this.peek().Is("SPATIAL") ||
+		this.peek().Is("FOREIGN") || this.peek().Is("CHECK"):
+		// 普通索引、外键和检查约束不影响 where 条件
+		return nil, true, this.skipItem()
+	}
+	return nil, false, nil
+}
+
+// parseKeyParts [USING BTREE] (col1, col2(10) DESC, ...) [索引选项]，有函数索引时返回 nil
+func (this *ddlParser) parseKeyParts(idx *IndexDef) (*IndexDef, error) {
+	if this.accept("USING") {
+		this.next()
+	}
+	if err := this.expectPunct("("); err != nil {
+		return nil, err
+	}
+	functional := false
+	for {
+		if this.peek().IsPunct("(") {
+			// 函数索引 ((expr))
+			functional = true
+			if err := this.skipGroup(); err != nil {
+				return nil, err
+			}
+		} else {
+			col, err := this.name()
+			if err != nil {
+				return nil, err
+			}
+			idx.Columns = append(idx.Columns, col)
+			// 前缀索引 col(10)
+			if this.peek().IsPunct("(") {
+				if err = this.skipGroup(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !this.accept("ASC") {
+			this.accept("DESC")
+		}
+		if this.acceptPunct(",") {
+			continue
+		}
+		if err := this.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		break
+	}
+	if err := this.skipItem(); err != nil {
+		return nil, err
+	}
+	if functional {
+		return nil, nil
+	}
+	return idx, nil
+}
+
+// parseColumnDefinition 列名 类型 [属性]，spec 不为空时解析 ALTER TABLE 中的 FIRST 和 AFTER
+func (this *ddlParser) parseColumnDefinition(spec *AlterSpec) (*ColumnDef, error) {
+	name, err := this.name()
+	if err != nil {
+		return nil, err
+	}
+	col := &ColumnDef{Name: name}
+	if err = this.parseDataType(col); err != nil {
+		return nil, err
+	}
+
+	// 属性：UNSIGNED NOT NULL DEFAULT ... COMMENT ... GENERATED ALWAYS AS (...) PRIMARY KEY ...
+	for {
+		tok := this.peek()
+		switch {
+		case tok.Kind == TokEOF || tok.IsPunct(",") || tok.IsPunct(")") || tok.IsPunct(";"):
+			if col.Charset == "" {
+				col.Charset = GetCharsetOfCollation(col.Collate)
+			}
+			return col, nil
+		case tok.IsPunct("("):
+			if err = this.skipGroup(); err != nil {
+				return nil, err
+			}
+			continue
+		case tok.Is("UNSIGNED") || tok.Is("ZEROFILL"):
+			col.Unsigned = true
+		case tok.Is("PRIMARY"):
+			col.Primary = true
+		case tok.Is("KEY"):
+			// 列定义中单独的 KEY 表示主键
+			if !col.Unique {
+				col.Primary = true
+			}
+		case tok.Is("UNIQUE"):
+			col.Unique = true
+		case tok.Is("GENERATED") || tok.Is("AS"):
+			col.Generated = true
+		case tok.Is("CHARACTER") || tok.Is("CHARSET") || tok.Is("CHAR") || tok.Is("COLLATE"):
+			if charset, isCollate, ok := this.acceptCharset(); ok {
+				if isCollate {
+					col.Collate = charset
+				} else {
+					col.Charset = charset
+				}
+				continue
+			}
+		case tok.Is("DEFAULT") || tok.Is("COMMENT"):
+			// 跳过值，避免把值当作关键字
+			this.next()
+			this.acceptPunct("=")
+			if this.peek().IsPunct("(") {
+				if err = this.skipGroup(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		case tok.Is("REFERENCES"):
+			// REFERENCES t (col) ...，后面的 ON DELETE 等没有影响
+		case spec != nil && tok.Is("FIRST"):
+			spec.First = true
+		case spec != nil && tok.Is("AFTER"):
+			this.next()
+			if spec.After, err = this.name(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		this.next()
+	}
+}
+
+// dataTypeAliases 类型同义词，转换为 SHOW COLUMNS 中的类型名
+var dataTypeAliases = map[string]string{
+	"integer":   "int",
+	"int1":      "tinyint",
+	"int2":      "smallint",
+	"int3":      "mediumint",
+	"int4":      "int",
+	"int8":      "bigint",
+	"middleint": "mediumint",
+	"bool":      "tinyint",
+	"boolean":   "tinyint",
+	"dec":       "decimal",
+	"numeric":   "decimal",
+	"fixed":     "decimal",
+	"real":      "double",
+	"float4":    "float",
+	"float8":    "double",
+	"nchar":     "char",
+	"nvarchar":  "varchar",
+	"character": "char",
+	"serial":    "bigint",
+}
+
+// parseDataType 类型名和参数，如 varchar(20)、double precision、enum('a','b')
+func (this *ddlParser) parseDataType(col *ColumnDef) error {
+	tok := this.peek()
+	if tok.Kind != TokIdent {
+		return this.errorf("expect data type of column %s", col.Name)
+	}
+	this.next()
+	tp := strings.ToLower(tok.Val)
+
+	// 多个单词的类型
+	switch tp {
+	case "national":
+		if tok = this.next(); tok.Kind != TokIdent {
+			return this.errorf("expect data type of column %s", col.Name)
+		}
+		tp = strings.ToLower(tok.Val)
+		if this.accept("VARYING") {
+			tp = "varchar"
+		}
+	case "double":
+		this.accept("PRECISION")
+	case "char", "character":
+		if this.accept("VARYING") {
+			tp = "varchar"
+		}
+	case "long":
+		if this.accept("VARBINARY") {
+			tp = "mediumblob"
+		} else {
+			this.accept("VARCHAR")
+			tp = "mediumtext"
+		}
+	case "serial":
+		col.Unsigned = true
+		col.Unique = true
+	}
+	if alias, ok := dataTypeAliases[tp]; ok {
+		tp = alias
+	}
+	col.Type = tp
+
+	// 类型参数
+	if this.acceptPunct("(") {
+		for {
+			tok = this.next()
+			switch {
+			case tok.Kind == TokEOF:
+				return this.errorf("unbalanced parentheses")
+			case tok.IsPunct(")"):
+				return nil
+			case tok.Kind == TokString || tok.Kind == TokNumber:
+				col.Args = append(col.Args, tok.Val)
+			}
+		}
+	}
+	return nil
+}
+
+// CREATE [UNIQUE] INDEX name ON t (...)，只需要唯一索引
+func (this *ddlParser) parseCreateIndex() (*DdlStmt, error) {
+	idx := &IndexDef{}
+	var err error
+	if idx.Name, err = this.name(); err != nil {
+		return nil, err
+	}
+	if this.accept("USING") {
+		this.next()
+	}
+	if !this.accept("ON") {
+		return nil, this.errorf("expect ON")
+	}
+	tb, err := this.tableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &DdlStmt{Type: DdlAlterTable, Tables: []DbTable{tb}}
+	if idx, err = this.parseKeyParts(idx); err != nil {
+		return stmt, err
+	}
+	if idx != nil {
+		stmt.Specs = append(stmt.Specs, &AlterSpec{Action: AlterAddIndex, Index: idx})
+	}
+	return stmt, nil
+}
+
+// DROP INDEX name ON t
+func (this *ddlParser) parseDropIndex() (*DdlStmt, error) {
+	this.accept("IF", "EXISTS")
+	name, err := this.name()
+	if err != nil {
+		return nil, err
+	}
+	if !this.accept("ON") {
+		return nil, this.errorf("expect ON")
+	}
+	tb, err := this.tableName()
+	if err != nil {
+		return nil, err
+	}
+	return &DdlStmt{
+		Type:   DdlAlterTable,
+		Tables: []DbTable{tb},
+		Specs:  []*AlterSpec{{Action: AlterDropIndex, Name: name}},
+	}, nil
+}
+
+// RENAME TABLE a TO b [, c TO d]
+func (this *ddlParser) parseRenameTable() (*DdlStmt, error) {
+	stmt := &DdlStmt{Type: DdlRenameTable}
+	for {
+		from, err := this.tableName()
+		if err != nil {
+			return nil, err
+		}
+		if !this.accept("TO") {
+			return nil, this.errorf("expect TO")
+		}
+		to, err := this.tableName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Tables = append(stmt.Tables, from)
+		stmt.NewTables = append(stmt.NewTables, to)
+		if !this.acceptPunct(",") {
+			return stmt, nil
+		}
+	}
+}
+
+// DROP TABLE [IF EXISTS] a [, b]
+func (this *ddlParser) parseDropTable() (*DdlStmt, error) {
+	stmt := &DdlStmt{Type: DdlDropTable}
+	this.accept("IF", "EXISTS")
+	for {
+		tb, err := this.tableName()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Tables = append(stmt.Tables, tb)
+		if !this.acceptPunct(",") {
+			return stmt, nil
+		}
+	}
+}
+
+// ALTER TABLE t spec [, spec]
+func (this *ddlParser) parseAlterTable() (*DdlStmt, error) {
+	tb, err := this.tableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &DdlStmt{Type: DdlAlterTable, Tables: []DbTable{tb}}
+	for !this.atEnd() {
+		specs, err := this.parseAlterSpec()
+		if err != nil {
+			return stmt, err
+		}
+		stmt.Specs = append(stmt.Specs, specs...)
+		if !this.acceptPunct(",") {
+			// 分区操作等不以 , 分隔
+			if err = this.skipItem(); err != nil {
+				return stmt, err
+			}
+			if !this.acceptPunct(",") && !this.atEnd() {
+				this.next()
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// parseAlterSpec ALTER TABLE 中的一个操作，不影响列和唯一键的操作返回空
+func (this *ddlParser) parseAlterSpec() ([]*AlterSpec, error) {
+	switch {
+	case this.accept("ADD"):
+		idx, isIndex, err := this.parseIndexDefinition()
+		if err != nil {
+			return nil, err
+		}
+		if isIndex {
+			if idx == nil {
+				return nil, nil
+			}
+			return []*AlterSpec{{Action: AlterAddIndex, Index: idx}}, nil
+		}
+		if this.peek().Is("PARTITION") {
+			return nil, this.skipItem()
+		}
+		this.accept("COLUMN")
+		this.accept("IF", "NOT", "EXISTS")
+		// ADD COLUMN (a int, b int)
+		if this.acceptPunct("(") {
+			var specs []*AlterSpec
+			for {
+				spec := &AlterSpec{Action: AlterAddColumn}
+				if spec.Column, err = this.parseColumnDefinition(spec); err != nil {
+					return nil, err
+				}
+				specs = append(specs, withColumnKeys(spec)...)
+				if this.acceptPunct(",") {
+					continue
+				}
+				return specs, this.expectPunct(")")
+			}
+		}
+		spec := &AlterSpec{Action: AlterAddColumn}
+		if spec.Column, err = this.parseColumnDefinition(spec); err != nil {
+			return nil, err
+		}
+		return withColumnKeys(spec), nil
+
+	case this.accept("CHANGE"):
+		this.accept("COLUMN")
+		this.accept("IF", "EXISTS")
+		spec := &AlterSpec{Action: AlterChangeColumn}
+		var err error
+		if spec.Name, err = this.name(); err != nil {
+			return nil, err
+		}
+		if spec.Column, err = this.parseColumnDefinition(spec); err != nil {
+			return nil, err
+		}
+		return withColumnKeys(spec), nil
+
+	case this.accept("MODIFY"):
+		this.accept("COLUMN")
+		this.accept("IF", "EXISTS")
+		spec := &AlterSpec{Action: AlterChangeColumn}
+		var err error
+		if spec.Column, err = this.parseColumnDefinition(spec); err != nil {
+			return nil, err
+		}
+		spec.Name = spec.Column.Name
+		return withColumnKeys(spec), nil
+
+	case this.accept("DROP"):
+		switch {
+		case this.accept("PRIMARY", "KEY"):
+			return []*AlterSpec{{Action: AlterDropIndex, Name: PrimaryKeyName}}, nil
+		case this.accept("INDEX") || this.accept("KEY") || this.accept("CONSTRAINT"):
+			this.accept("IF", "EXISTS")
+			name, err := this.name()
+			if err != nil {
+				return nil, err
+			}
+			return []*AlterSpec{{Action: AlterDropIndex, Name: name}}, nil
+		case this.peek().Is("FOREIGN") || this.peek().Is("CHECK") || this.peek().Is("PARTITION") || this.peek().Is("DEFAULT"):
+			return nil, this.skipItem()
+		}
+		this.accept("COLUMN")
+		this.accept("IF", "EXISTS")
+		name, err := this.name()
+		if err != nil {
+			return nil, err
+		}
+		return []*AlterSpec{{Action: AlterDropColumn, Name: name}}, this.skipItem()
+
+	case this.accept("RENAME"):
+		switch {
+		case this.accept("COLUMN"):
+			spec := &AlterSpec{Action: AlterRenameColumn}
+			var err error
+			if spec.Name, err = this.name(); err != nil {
+				return nil, err
+			}
+			if !this.accept("TO") {
+				return nil, this.errorf("expect TO")
+			}
+			spec.NewName, err = this.name()
+			return []*AlterSpec{spec}, err
+		case this.accept("INDEX") || this.accept("KEY"):
+			spec := &AlterSpec{Action: AlterRenameIndex}
+			var err error
+			if spec.Name, err = this.name(); err != nil {
+				return nil, err
+			}
+			if !this.accept("TO") {
+				return nil, this.errorf("expect TO")
+			}
+			spec.NewName, err = this.name()
+			return []*AlterSpec{spec}, err
+		}
+		if !this.accept("TO") {
+			this.accept("AS")
+		}
+		tb, err := this.tableName()
+		if err != nil {
+			return nil, err
+		}
+		return []*AlterSpec{{Action: AlterRenameTable, NewTable: tb}}, nil
+
+	case this.accept("CONVERT", "TO"):
+		if charset := this.parseCharsetOptions(); charset != "" {
+			return []*AlterSpec{{Action: AlterConvertCharset, Charset: charset}}, this.skipItem()
+		}
+
+	case this.peek().Is("DEFAULT") || this.peek().Is("CHARACTER") || this.peek().Is("CHARSET") || this.peek().Is("COLLATE"):
+		this.accept("DEFAULT")
+		if charset := this.parseCharsetOptions(); charset != "" {
+			return []*AlterSpec{{Action: AlterTableCharset, Charset: charset}}, this.skipItem()
+		}
+	}
+
+	// ALTER COLUMN、ENGINE= 等
+	return nil, this.skipItem()
+}
+
+// parseCharsetOptions CHARACTER SET x [COLLATE y] 或者 COLLATE y ，返回字符集，DEFAULT 或者没有时返回空
+func (this *ddlParser) parseCharsetOptions() string {
+	charset, collate := "", ""
+	for {
+		val, isCollate, ok := this.acceptCharset()
+		if !ok {
+			break
+		}
+		if isCollate {
+			collate = val
+		} else {
+			charset = val
+		}
+	}
+	if charset == "" {
+		charset = GetCharsetOfCollation(collate)
+	}
+	if charset == "default" {
+		return ""
+	}
+	return charset
+}
+
+// withColumnKeys 列定义中有 PRIMARY KEY 或者 UNIQUE 时，增加对应的索引
+func withColumnKeys(spec *AlterSpec) []*AlterSpec {
+	specs := []*AlterSpec{spec}
+	if spec.Column.Primary {
+		specs = append(specs, &AlterSpec{Action: AlterAddIndex,
+			Index: &IndexDef{Name: PrimaryKeyName, Primary: true, Columns: []string{spec.Column.Name}}})
+	} else if spec.Column.Unique {
+		specs = append(specs, &AlterSpec{Action: AlterAddIndex, Index: &IndexDef{Columns: []string{spec.Column.Name}}})
+	}
+	return specs
+}
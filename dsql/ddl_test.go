@@ -0,0 +1,309 @@
+package dsql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func describeTable(tb DbTable) string {
+	return tb.Database + "." + tb.Table
+}
+
+func describeColumn(col *ColumnDef) string {
+	s := col.Name + " " + col.Type
+	if len(col.Args) > 0 {
+		s += "(" + strings.Join(col.Args, "|") + ")"
+	}
+	if col.Unsigned {
+		s += " unsigned"
+	}
+	if col.Primary {
+		s += " primary"
+	}
+	if col.Unique {
+		s += " unique"
+	}
+	if col.Generated {
+		s += " generated"
+	}
+	if col.Charset != "" {
+		s += " charset=" + col.Charset
+	}
+	return s
+}
+
+func describeIndex(idx *IndexDef) string {
+	return idx.Name + "(" + strings.Join(idx.Columns, ",") + ")"
+}
+
+func describeSpec(spec *AlterSpec) string {
+	switch spec.Action {
+	case AlterAddColumn, AlterChangeColumn:
+		action := "add"
+		if spec.Action == AlterChangeColumn {
+			action = "change " + spec.Name + " to"
+		}
+		s := action + " " + describeColumn(spec.Column)
+		if spec.First {
+			s += " first"
+		}
+		if spec.After != "" {
+			s += " after " + spec.After
+		}
+		return s
+	case AlterRenameColumn:
+		return "rename column " + spec.Name + " to " + spec.NewName
+	case AlterDropColumn:
+		return "drop column " + spec.Name
+	case AlterAddIndex:
+		return "add index " + describeIndex(spec.Index)
+	case AlterDropIndex:
+		return "drop index " + spec.Name
+	case AlterRenameIndex:
+		return "rename index " + spec.Name + " to " + spec.NewName
+	case AlterRenameTable:
+		return "rename to " + describeTable(spec.NewTable)
+	case AlterTableCharset:
+		return "charset " + spec.Charset
+	case AlterConvertCharset:
+		return "convert " + spec.Charset
+	}
+	return fmt.Sprintf("action %d", spec.Action)
+}
+
+// describeStmt 把解析结果转换为一行文本，便于比较
+func describeStmt(stmt *DdlStmt) string {
+	if stmt == nil {
+		return "<nil>"
+	}
+	var parts []string
+	switch stmt.Type {
+	case DdlCreateTable:
+		parts = append(parts, "create "+describeTable(stmt.Tables[0]))
+		for _, col := range stmt.Create.Columns {
+			parts = append(parts, "col "+describeColumn(col))
+		}
+		for _, idx := range stmt.Create.Indexes {
+			parts = append(parts, "index "+describeIndex(idx))
+		}
+	case DdlCreateTableLike:
+		parts = append(parts, "create "+describeTable(stmt.Tables[0])+" like "+describeTable(stmt.Like))
+	case DdlAlterTable:
+		parts = append(parts, "alter "+describeTable(stmt.Tables[0]))
+		for _, spec := range stmt.Specs {
+			parts = append(parts, describeSpec(spec))
+		}
+	case DdlRenameTable:
+		var arr []string
+		for i := range stmt.Tables {
+			arr = append(arr, describeTable(stmt.Tables[i])+" to "+describeTable(stmt.NewTables[i]))
+		}
+		parts = append(parts, "rename "+strings.Join(arr, ", "))
+	case DdlDropTable:
+		var arr []string
+		for _, tb := range stmt.Tables {
+			arr = append(arr, describeTable(tb))
+		}
+		parts = append(parts, "drop "+strings.Join(arr, ", "))
+	case DdlDropDatabase:
+		parts = append(parts, "drop database "+stmt.Database)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func TestParseDdl(t *testing.T) {
+	cases := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "mysqldump create table with executable comments",
+			sql: "CREATE TABLE `t1` (\n" +
+				"  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,\n" +
+				"  `name` varchar(20) NOT NULL DEFAULT 'a,b)c''d' COMMENT 'it''s (x, y)',\n" +
+				"  `price` decimal(10,2) DEFAULT NULL,\n" +
+				"  `full` varchar(41) GENERATED ALWAYS AS (concat(`name`,',')) VIRTUAL,\n" +
+				"  PRIMARY KEY (`id`),\n" +
+				"  UNIQUE KEY `uk_name` (`name`(10)),\n" +
+				"  KEY `idx_price` (`price`)\n" +
+				") ENGINE=InnoDB DEFAULT CHARSET=latin1\n" +
+				"/*!50100 PARTITION BY HASH (`id`) PARTITIONS 4 */",
+			want: "create db.t1; col id int(10) unsigned; col name varchar(20) charset=latin1; col price decimal(10|2); " +
+				"col full varchar(41) generated charset=latin1; index PRIMARY(id); index uk_name(name)",
+		},
+		{
+			name: "quoted identifiers and column keys",
+			sql:  "create table if not exists `my db`.`a``b` (`c,1` int primary key, `c)2` char(3) unique collate utf8mb4_bin)",
+			want: "create my db.a`b; col c,1 int primary; col c)2 char(3) unique charset=utf8mb4; index PRIMARY(c,1); index (c)2)",
+		},
+		{
+			name: "executable comment inside column definition",
+			sql:  "CREATE TABLE t (id bigint /*!50606 unsigned */ not null, ts timestamp /*!50700 DEFAULT CURRENT_TIMESTAMP */, primary key (id))",
+			want: "create db.t; col id bigint unsigned; col ts timestamp; index PRIMARY(id)",
+		},
+		{
+			name: "comments",
+			sql:  "-- leading comment\nCREATE TABLE t ( # hash comment\n id int, /* block, ) */ name text --\tcomment with tab\n) --",
+			want: "create db.t; col id int; col name text",
+		},
+		{
+			name: "create like",
+			sql:  "CREATE TABLE IF NOT EXISTS t2 LIKE other.t1",
+			want: "create db.t2 like other.t1",
+		},
+		{
+			name: "create like in parentheses",
+			sql:  "CREATE TABLE t2 (LIKE t1)",
+			want: "create db.t2 like db.t1",
+		},
+		{
+			name: "alter add column first and after",
+			sql:  "ALTER TABLE t ADD COLUMN a int FIRST, ADD b varchar(10) CHARACTER SET gbk AFTER `id`, ADD (c int, d int unique)",
+			want: "alter db.t; add a int first; add b varchar(10) charset=gbk after id; add c int; add d int unique; add index (d)",
+		},
+		{
+			name: "alter change modify rename drop column",
+			sql: "ALTER TABLE db2.t CHANGE COLUMN `old` `new` bigint unsigned NOT NULL AFTER x, MODIFY name varchar(30) FIRST, " +
+				"RENAME COLUMN a TO b, DROP COLUMN c, DROP d",
+			want: "alter db2.t; change old to new bigint unsigned after x; change name to name varchar(30) first; " +
+				"rename column a to b; drop column c; drop column d",
+		},
+		{
+			name: "alter indexes and primary key",
+			sql: "ALTER TABLE t ADD INDEX idx_a (a), ADD UNIQUE KEY uk_b (b, c), DROP INDEX idx_x, DROP PRIMARY KEY, " +
+				"ADD PRIMARY KEY (id, b), ADD CONSTRAINT uk_d UNIQUE (d), RENAME INDEX uk_b TO uk_bc",
+			want: "alter db.t; add index uk_b(b,c); drop index idx_x; drop index PRIMARY; add index PRIMARY(id,b); " +
+				"add index uk_d(d); rename index uk_b to uk_bc",
+		},
+		{
+			name: "alter options without effect on columns",
+			sql:  "ALTER TABLE t ENGINE=InnoDB, ALTER COLUMN a SET DEFAULT 'x,y', ADD KEY (b), COMMENT 'a, b'",
+			want: "alter db.t",
+		},
+		{
+			name: "alter rename table and charset",
+			sql:  "ALTER TABLE t RENAME TO db2.t2, DEFAULT CHARSET = utf8mb4, CONVERT TO CHARACTER SET latin1 COLLATE latin1_bin",
+			want: "alter db.t; rename to db2.t2; charset utf8mb4; convert latin1",
+		},
+		{
+			name: "create unique index",
+			sql:  "CREATE UNIQUE INDEX uk ON t (a, b)",
+			want: "alter db.t; add index uk(a,b)",
+		},
+		{
+			name: "drop index",
+			sql:  "DROP INDEX `PRIMARY` ON db2.t",
+			want: "alter db2.t; drop index PRIMARY",
+		},
+		{
+			name: "rename tables",
+			sql:  "RENAME TABLE a TO b, db2.c TO db3.d",
+			want: "rename db.a to db.b, db2.c to db3.d",
+		},
+		{
+			name: "drop tables",
+			sql:  "DROP TABLE IF EXISTS a, `db2`.`b`, c /* generated by server */",
+			want: "drop db.a, db2.b, db.c",
+		},
+		{
+			name: "drop database",
+			sql:  "DROP DATABASE IF EXISTS db2",
+			want: "drop database db2",
+		},
+		{
+			name: "temporary table",
+			sql:  "CREATE TEMPORARY TABLE t (id int)",
+			want: "<nil>",
+		},
+		{
+			name: "not ddl",
+			sql:  "insert into t values (1)",
+			want: "<nil>",
+		},
+	}
+
+	for _, c := range cases {
+		stmt, err := ParseDdl(c.sql, "db")
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := describeStmt(stmt); got != c.want {
+			t.Errorf("%s:\n got: %s\nwant: %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseDdlError(t *testing.T) {
+	cases := []string{
+		"CREATE TABLE t SELECT * FROM s",
+		"CREATE TABLE t (id int",
+		"ALTER TABLE t ADD COLUMN c varchar(10) DEFAULT 'unterminated",
+		"RENAME TABLE a b",
+	}
+	for _, sql := range cases {
+		if _, err := ParseDdl(sql, "db"); err == nil {
+			t.Errorf("expect error for %s", sql)
+		}
+	}
+}
+
+func TestParseColumnType(t *testing.T) {
+	cases := []struct {
+		colType string
+		want    string
+	}{
+		{"enum('a','b,c','it''s')", "enum(a|b,c|it's)"},
+		{`set('x','\'y\'','(z)')`, "set(x|'y'|(z))"},
+		{"int(10) unsigned", "int(10) unsigned"},
+		{"decimal(20,6)", "decimal(20|6)"},
+		{"double precision", "double"},
+		{"character varying(5)", "varchar(5)"},
+	}
+	for _, c := range cases {
+		col, err := ParseColumnType(c.colType)
+		if err != nil {
+			t.Errorf("%s: %v", c.colType, err)
+			continue
+		}
+		if got := strings.TrimSpace(describeColumn(col)); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.colType, got, c.want)
+		}
+	}
+}
+
+func TestLexerComments(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want string
+	}{
+		{"a -- comment\nb", "a b"},
+		{"a --\tcomment\nb", "a b"},
+		{"a --\r\nb", "a b"},
+		{"a --\nb", "a b"},
+		{"a --", "a"},
+		{"a # comment\nb", "a b"},
+		{"a /* x */ b /*!50100 c */ d", "a b c d"},
+		{"a --b", "a - - b"},
+		{"a-1", "a - 1"},
+	}
+	for _, c := range cases {
+		lex := NewLexer(c.sql)
+		var arr []string
+		for {
+			tok, err := lex.Next()
+			if err != nil {
+				t.Fatalf("%q: %v", c.sql, err)
+			}
+			if tok.Kind == TokEOF {
+				break
+			}
+			arr = append(arr, tok.Val)
+		}
+		if got := strings.Join(arr, " "); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.sql, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package dsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TokEOF    = iota // 语句结束
+	TokIdent         // 标识符或者关键字
+	TokQuoted        // `标识符`
+	TokString        // 'str' 或者 "str"
+	TokNumber        // 数字
+	TokPunct         // ( ) , . ; = 等单个字符
+)
+
+type Token struct {
+	Kind int
+	Val  string // TokQuoted 和 TokString 是去掉引号和转义之后的值
+}
+
+// Is 是否是关键字 keyword ，不区分大小写，`keyword` 不是关键字
+func (t Token) Is(keyword string) bool {
+	return t.Kind == TokIdent && strings.EqualFold(t.Val, keyword)
+}
+
+func (t Token) IsPunct(p string) bool {
+	return t.Kind == TokPunct && t.Val == p
+}
+
+// IsName 是否可以作为库名、表名、列名
+func (t Token) IsName() bool {
+	return t.Kind == TokIdent || t.Kind == TokQuoted
+}
+
+// Lexer 按需逐个读取 sql 中的 token ，跳过注释，/*! ... */ 中的内容当作 sql
+type Lexer struct {
+	sql    string
+	pos    int
+	inExec bool // 是否在 /*! ... */ 中
+}
+
+func NewLexer(sql string) *Lexer {
+	return &Lexer{sql: sql}
+}
+
+// Offset 下一个 token 在 sql 中的开始位置，用于切分多条语句
+func (this *Lexer) Offset() int {
+	this.skipSpaceAndComments()
+	return this.pos
+}
+
+func (this *Lexer) Next() (Token, error) {
+	this.skipSpaceAndComments()
+	if this.pos >= len(this.sql) {
+		return Token{Kind: TokEOF}, nil
+	}
+
+	s := this.sql
+	c := s[this.pos]
+	start := this.pos
+	switch {
+	case c == '`':
+		val, err := this.readQuoted('`')
+		return Token{Kind: TokQuoted, Val: val}, err
+	case c == '\'' || c == '"':
+		val, err := this.readQuoted(c)
+		return Token{Kind: TokString, Val: val}, err
+	case c >= '0' && c <= '9' || c == '.' && this.pos+1 < len(s) && s[this.pos+1] >= '0' && s[this.pos+1] <= '9':
+		for this.pos < len(s) && (isIdentChar(s[this.pos]) || s[this.pos] == '.') {
+			this.pos++
+		}
+		return Token{Kind: TokNumber, Val: s[start:this.pos]}, nil
+	case isIdentChar(c):
+		for this.pos < len(s) && isIdentChar(s[this.pos]) {
+			this.pos++
+		}
+		return Token{Kind: TokIdent, Val: s[start:this.pos]}, nil
+	}
+	this.pos++
+	return Token{Kind: TokPunct, Val: s[start:this.pos]}, nil
+}
+
+func (this *Lexer) skipSpaceAndComments() {
+	s := this.sql
+	for this.pos < len(s) {
+		c := s[this.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			this.pos++
+		case c == '#' || isDashComment(s[this.pos:]):
+			if i := strings.IndexByte(s[this.pos:], '\n'); i >= 0 {
+				this.pos += i + 1
+			} else {
+				this.pos = len(s)
+			}
+		case strings.HasPrefix(s[this.pos:], "/*!"):
+			// 可执行注释 /*!50100 ... */ ，去掉版本号，其中的内容照常解析
+			this.pos += 3
+			for this.pos < len(s) && s[this.pos] >= '0' && s[this.pos] <= '9' {
+				this.pos++
+			}
+			this.inExec = true
+		case strings.HasPrefix(s[this.pos:], "/*"):
+			if i := strings.Index(s[this.pos+2:], "*/"); i >= 0 {
+				this.pos += i + 4
+			} else {
+				this.pos = len(s)
+			}
+		case this.inExec && strings.HasPrefix(s[this.pos:], "*/"):
+			this.pos += 2
+			this.inExec = false
+		default:
+			return
+		}
+	}
+}
+
+// isDashComment 是否以 -- 注释开始，mysql 要求 -- 之后是空白字符(空格、tab、换行等)或者 sql 结束
+func isDashComment(s string) bool {
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+	if len(s) == 2 {
+		return true
+	}
+	switch s[2] {
+	case ' ', '\t', '\n', '\r', '\f', '\v':
+		return true
+	}
+	return false
+}
+
+// readQuoted 读取引号 q 中的内容，支持 \ 转义和两个连续的引号
+func (this *Lexer) readQuoted(q byte) (string, error) {
+	var (
+		s   = this.sql
+		buf strings.Builder
+	)
+	start := this.pos
+	this.pos++
+	for this.pos < len(s) {
+		c := s[this.pos]
+		if c == q {
+			if this.pos+1 < len(s) && s[this.pos+1] == q {
+				buf.WriteByte(q)
+				this.pos += 2
+				continue
+			}
+			this.pos++
+			return buf.String(), nil
+		}
+		if c == '\\' && q != '`' && this.pos+1 < len(s) {
+			this.pos++
+			buf.WriteByte(unescapeChar(s[this.pos]))
+			this.pos++
+			continue
+		}
+		buf.WriteByte(c)
+		this.pos++
+	}
+	return "", fmt.Errorf("unterminated quoted string at offset %d", start)
+}
+
+func unescapeChar(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'Z':
+		return 26
+	}
+	return c
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '$' || c >= 0x80
+}
@@ -0,0 +1,96 @@
+package dsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statement sql 文件中的一条语句
+type Statement struct {
+	Sql  string // 包括语句之前的注释
+	Line int    // 语句第一个 token 所在的行，从 1 开始
+}
+
+// SplitStatements 按分隔符切分 sql 文件(如 mysqldump 导出的文件)，忽略注释和空语句。
+// 支持 mysql 客户端的 DELIMITER 命令，mysqldump 导出触发器和存储过程时会用到。
+func SplitStatements(sql string) ([]Statement, error) {
+	var (
+		lex      = NewLexer(sql)
+		delim    = ";"
+		stmts    []Statement
+		start    = 0  // 当前语句的开始位置
+		first    = -1 // 当前语句第一个 token 的位置
+		line     = 1
+		linePos  = 0
+		lineOfAt = func(pos int) int {
+			line += strings.Count(sql[linePos:pos], "\n")
+			linePos = pos
+			return line
+		}
+	)
+	for {
+		off := lex.Offset()
+		if off >= len(sql) {
+			if first >= 0 {
+				stmts = append(stmts, Statement{Sql: strings.TrimSpace(sql[start:]), Line: lineOfAt(first)})
+			}
+			return stmts, nil
+		}
+
+		if strings.HasPrefix(sql[off:], delim) {
+			if first >= 0 {
+				stmts = append(stmts, Statement{Sql: strings.TrimSpace(sql[start:off]), Line: lineOfAt(first)})
+			}
+			lex.pos = off + len(delim)
+			start, first = lex.pos, -1
+			continue
+		}
+
+		// DELIMITER 命令在语句开头，到行尾结束，不以分隔符结束
+		if first < 0 && len(sql)-off > len("DELIMITER") && strings.EqualFold(sql[off:off+len("DELIMITER")], "DELIMITER") &&
+			(sql[off+len("DELIMITER")] == ' ' || sql[off+len("DELIMITER")] == '\t') {
+			end := strings.IndexByte(sql[off:], '\n')
+			if end < 0 {
+				end = len(sql)
+			} else {
+				end += off
+			}
+			delim = strings.TrimSpace(sql[off+len("DELIMITER") : end])
+			if delim == "" {
+				return nil, fmt.Errorf("empty DELIMITER at line %d", lineOfAt(off))
+			}
+			lex.pos = end
+			start = end
+			continue
+		}
+
+		if first < 0 {
+			first = off
+		}
+		tok, err := lex.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%v, line %d", err, lineOfAt(first))
+		}
+		// 分隔符可能和前面的单词连在一起，如 END$$
+		if tok.Kind == TokIdent || tok.Kind == TokNumber {
+			if i := strings.Index(sql[off:lex.pos], delim); i > 0 {
+				stmts = append(stmts, Statement{Sql: strings.TrimSpace(sql[start : off+i]), Line: lineOfAt(first)})
+				lex.pos = off + i + len(delim)
+				start, first = lex.pos, -1
+			}
+		}
+	}
+}
+
+// ParseUse 解析 USE db ，不是 USE 语句时返回 false
+func ParseUse(sql string) (string, bool) {
+	lex := NewLexer(sql)
+	tok, err := lex.Next()
+	if err != nil || !tok.Is("USE") {
+		return "", false
+	}
+	if tok, err = lex.Next(); err != nil || !tok.IsName() {
+		return "", false
+	}
+	return tok.Val, true
+}
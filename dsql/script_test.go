@@ -0,0 +1,104 @@
+package dsql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name string
+		sql  string
+		want []Statement
+	}{
+		{
+			name: "mysqldump without DELIMITER",
+			sql: "-- MySQL dump 10.13\n" +
+				"/*!40101 SET NAMES utf8mb4 */;\n" +
+				"/*!40103 SET TIME_ZONE='+00:00' */;\n" +
+				"\n" +
+				"USE `db1`;\n" +
+				"CREATE TABLE `t1` (\n" +
+				"  `note` varchar(20) DEFAULT 'a;b' COMMENT \"x; -- y\"\n" +
+				") COMMENT='semi;colon';\n" +
+				"/*!40101 SET character_set_client = @saved_cs_client */;\n",
+			want: []Statement{
+				{Sql: "-- MySQL dump 10.13\n/*!40101 SET NAMES utf8mb4 */", Line: 2},
+				{Sql: "/*!40103 SET TIME_ZONE='+00:00' */", Line: 3},
+				{Sql: "USE `db1`", Line: 5},
+				{Sql: "CREATE TABLE `t1` (\n  `note` varchar(20) DEFAULT 'a;b' COMMENT \"x; -- y\"\n) COMMENT='semi;colon'", Line: 6},
+				{Sql: "/*!40101 SET character_set_client = @saved_cs_client */", Line: 9},
+			},
+		},
+		{
+			name: "semicolons in comments and quoted identifiers",
+			sql:  "create table `a;b` (id int) /* x; y */; # c;d\n-- e;f\ndrop table t",
+			want: []Statement{
+				{Sql: "create table `a;b` (id int) /* x; y */", Line: 1},
+				{Sql: "# c;d\n-- e;f\ndrop table t", Line: 3},
+			},
+		},
+		{
+			name: "empty statements",
+			sql:  ";;\n  ;\nselect 1;",
+			want: []Statement{
+				{Sql: "select 1", Line: 3},
+			},
+		},
+		{
+			name: "DELIMITER",
+			sql: "DELIMITER ;;\n" +
+				"CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; END;;\n" +
+				"DELIMITER ;\n" +
+				"CREATE TABLE t2 (id int);",
+			want: []Statement{
+				{Sql: "CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; END", Line: 2},
+				{Sql: "CREATE TABLE t2 (id int)", Line: 4},
+			},
+		},
+		{
+			name: "DELIMITER joined to the last word",
+			sql:  "DELIMITER $$\nCREATE PROCEDURE p() BEGIN SELECT 1; END$$\nDELIMITER ;\nselect 2",
+			want: []Statement{
+				{Sql: "CREATE PROCEDURE p() BEGIN SELECT 1; END", Line: 2},
+				{Sql: "select 2", Line: 4},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := SplitStatements(c.sql)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\n got: %q\nwant: %q", c.name, got, c.want)
+		}
+	}
+
+	if _, err := SplitStatements("select 'unterminated;\nselect 2;"); err == nil {
+		t.Errorf("expect error for unterminated string")
+	}
+}
+
+func TestParseUse(t *testing.T) {
+	cases := []struct {
+		sql   string
+		db    string
+		isUse bool
+	}{
+		{"USE db1", "db1", true},
+		{"use `my;db`", "my;db", true},
+		{"/* x */ USE db2", "db2", true},
+		{"USE", "", false},
+		{"USER db1", "", false},
+		{"CREATE TABLE use (id int)", "", false},
+	}
+	for _, c := range cases {
+		db, ok := ParseUse(c.sql)
+		if db != c.db || ok != c.isUse {
+			t.Errorf("%s: got (%s, %v), want (%s, %v)", c.sql, db, ok, c.db, c.isUse)
+		}
+	}
+}
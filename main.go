@@ -3,13 +3,22 @@ package main
 import (
 	"sync"
 
-	"github.com/go-mysql-org/go-mysql/replication"
 	my "my2sql/base"
 )
 
 func main() {
 	my.GConfCmd.IfSetStopParsPoint = false
 	my.GConfCmd.ParseCmdOptions()
+	// my2sql locate -datetime ... ：只输出该时间点对应的 binlog 位置
+	if my.GConfCmd.Command == my.C_cmdLocate {
+		my.LocateDatetime(my.GConfCmd)
+		return
+	}
+	// my2sql dump-schema -table-def-file ... ：只导出表结构
+	if my.GConfCmd.Command == my.C_cmdDumpSchema {
+		my.DumpSchema(my.GConfCmd)
+		return
+	}
 	defer my.GConfCmd.CloseFH()
 	if my.GConfCmd.WorkType != "stats" {
 		my.G_HandlingBinEventIndex = &my.BinEventHandlingIndx{EventIdx: 1, Finished: false}
@@ -34,17 +43,21 @@ func main() {
 	// repl：伪装成从库从主库获取 binlog 文件
 	if my.GConfCmd.Mode == "repl" {
 		my.ParserAllBinEventsFromRepl(my.GConfCmd)
-	// file：从本地文件系统获取 binlog 文件
-	} else if my.GConfCmd.Mode == "file" {
-		psr := replication.NewBinlogParser()
-		psr.SetParseTime(false)	// do not parse mysql datetime/time column into go time structure, take it as string
-		psr.SetUseDecimal(false)	// sqlbuilder not support decimal type
-		my.BinFileParser{
-			Parser: psr,
-		}.MyParseAllBinlogFiles(my.GConfCmd)
+	// file：从本地文件系统获取 binlog 文件；stdin：从标准输入读取 binlog 流
+	} else if my.GConfCmd.Mode == "file" || my.GConfCmd.Mode == "stdin" {
+		fileParser := my.BinFileParser{
+			Parser: my.NewFileBinlogParser(),
+		}
+		if my.GConfCmd.Mode == "stdin" {
+			fileParser.MyParseStdin(my.GConfCmd)
+		} else {
+			fileParser.MyParseAllBinlogFiles(my.GConfCmd)
+		}
 	}
 
 	wgGenSql.Wait()
+	my.G_HandlingBinEventIndex.FlushPendingInsert(my.GConfCmd)
+	my.G_KeylessTables.WarnSummary()
 	close(my.GConfCmd.SqlChan)
 	wg.Wait() 
 }
@@ -201,6 +201,23 @@ func DoubleColumn(name string, nullable NullableColumn) NonAliasColumn {
 	return ic
 }
 
+type decimalColumn struct {
+	baseColumn
+	isExpression
+}
+
+// Representation of DECIMAL columns, values are exact decimal numbers
+// This function will panic if name is not valid
+func DecimalColumn(name string, nullable NullableColumn) NonAliasColumn {
+	if !validIdentifierName(name) {
+		panic("Invalid column name in decimal column")
+	}
+	dc := &decimalColumn{}
+	dc.name = name
+	dc.nullable = nullable
+	return dc
+}
+
 type booleanColumn struct {
 	baseColumn
 	isExpression
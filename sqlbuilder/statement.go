@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/dropbox/godropbox/errors"
 )
@@ -38,6 +39,15 @@ type InsertStatement interface {
 	AddOnDuplicateKeyUpdate(col NonAliasColumn, expr Expression) InsertStatement
 	Comment(comment string) InsertStatement
 	IgnoreDuplicates(ignore bool) InsertStatement
+	// Use REPLACE INTO instead of INSERT INTO. Rows with the same primary or
+	// unique key are deleted before the new rows are inserted.
+	ReplaceDuplicates(replace bool) InsertStatement
+
+	// Returns the sql in three parts: everything before the rows
+	// ("INSERT INTO t (a,b) VALUES "), each row ("(1,2)") and everything after
+	// the rows (" ON DUPLICATE KEY UPDATE ..."). Rows of statements with the
+	// same head and tail can be joined into one multi-row statement.
+	StringParts(database string) (head string, rows []string, tail string, err error)
 }
 
 // By default, rows selected by a UNION statement are out-of-order
@@ -492,6 +502,7 @@ type insertStatementImpl struct {
 	onDuplicateKeyUpdates []columnAssignment
 	comment               string
 	ignore                bool
+	replace               bool
 }
 
 func (s *insertStatementImpl) Add(
@@ -517,20 +528,42 @@ func (s *insertStatementImpl) IgnoreDuplicates(ignore bool) InsertStatement {
 	return s
 }
 
+func (s *insertStatementImpl) ReplaceDuplicates(replace bool) InsertStatement {
+	s.replace = replace
+	return s
+}
+
 func (s *insertStatementImpl) Comment(comment string) InsertStatement {
 	s.comment = comment
 	return s
 }
 
 func (s *insertStatementImpl) String(database string) (sql string, err error) {
+	head, rows, tail, err := s.StringParts(database)
+	if err != nil {
+		return "", err
+	}
+	return head + strings.Join(rows, ", ") + tail, nil
+}
+
+func (s *insertStatementImpl) StringParts(database string) (
+	head string,
+	rows []string,
+	tail string,
+	err error) {
+
 	// Momo modified. if database empty, not validate it
 	if database != "" && !validIdentifierName(database) {
-		return "", errors.New("Invalid database name specified")
+		return "", nil, "", errors.New("Invalid database name specified")
 	}
 
 	buf := new(bytes.Buffer)
-	_, _ = buf.WriteString("INSERT ")
-	if s.ignore {
+	if s.replace {
+		_, _ = buf.WriteString("REPLACE ")
+	} else {
+		_, _ = buf.WriteString("INSERT ")
+	}
+	if s.ignore && !s.replace {
 		_, _ = buf.WriteString("IGNORE ")
 	}
 	_, _ = buf.WriteString("INTO ")
@@ -540,7 +573,7 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 	}
 
 	if s.table == nil {
-		return "", errors.Newf("nil table.  Generated sql: %s", buf.String())
+		return "", nil, "", errors.Newf("nil table.  Generated sql: %s", buf.String())
 	}
 
 	if err = s.table.SerializeSql(database, buf); err != nil {
@@ -548,7 +581,7 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 	}
 
 	if len(s.columns) == 0 {
-		return "", errors.Newf(
+		return "", nil, "", errors.Newf(
 			"No column specified.  Generated sql: %s",
 			buf.String())
 	}
@@ -560,7 +593,7 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 		}
 
 		if col == nil {
-			return "", errors.Newf(
+			return "", nil, "", errors.Newf(
 				"nil column in columns list.  Generated sql: %s",
 				buf.String())
 		}
@@ -571,19 +604,20 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 	}
 
 	if len(s.rows) == 0 {
-		return "", errors.Newf(
+		return "", nil, "", errors.Newf(
 			"No row specified.  Generated sql: %s",
 			buf.String())
 	}
 
-	_, _ = buf.WriteString(") VALUES (")
+	_, _ = buf.WriteString(") VALUES ")
+	head = buf.String()
+	rows = make([]string, len(s.rows))
 	for row_i, row := range s.rows {
-		if row_i > 0 {
-			_, _ = buf.WriteString(", (")
-		}
+		buf.Reset()
+		_ = buf.WriteByte('(')
 
 		if len(row) != len(s.columns) {
-			return "", errors.Newf(
+			return "", nil, "", errors.Newf(
 				"# of values does not match # of columns.  Generated sql: %s",
 				buf.String())
 		}
@@ -594,7 +628,7 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 			}
 
 			if value == nil {
-				return "", errors.Newf(
+				return "", nil, "", errors.Newf(
 					"nil value in row %d col %d.  Generated sql: %s",
 					row_i,
 					col_i,
@@ -606,8 +640,11 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 			}
 		}
 		_ = buf.WriteByte(')')
+		rows[row_i] = buf.String()
 	}
 
+	buf.Reset()
+
 	if len(s.onDuplicateKeyUpdates) > 0 {
 		_, _ = buf.WriteString(" ON DUPLICATE KEY UPDATE ")
 		for i, colExpr := range s.onDuplicateKeyUpdates {
@@ -616,7 +653,7 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 			}
 
 			if colExpr.col == nil {
-				return "", errors.Newf(
+				return "", nil, "", errors.Newf(
 					("nil column in on duplicate key update list.  " +
 						"Generated sql: %s"),
 					buf.String())
@@ -629,7 +666,7 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 			_ = buf.WriteByte('=')
 
 			if colExpr.expr == nil {
-				return "", errors.Newf(
+				return "", nil, "", errors.Newf(
 					("nil expression in on duplicate key update list.  " +
 						"Generated sql: %s"),
 					buf.String())
@@ -641,7 +678,7 @@ func (s *insertStatementImpl) String(database string) (sql string, err error) {
 		}
 	}
 
-	return buf.String(), nil
+	return head, rows, buf.String(), nil
 }
 
 //
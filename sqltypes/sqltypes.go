@@ -19,6 +19,7 @@ import (
 
 	"github.com/dropbox/godropbox/encoding2"
 	"github.com/dropbox/godropbox/errors"
+	"github.com/shopspring/decimal"
 )
 
 var (
@@ -57,6 +58,55 @@ type String struct {
 	isUtf8 bool
 }
 
+// CharsetString represents a string in the given charset, encoded as an
+// introducer with hex data like _latin1 X'E9'. It is used for values that
+// can not be converted into the charset of the connection. data is kept in a
+// string so that values can be compared with ==.
+type CharsetString struct {
+	charset string
+	data    string
+}
+
+// Bit represents the value of a BIT column, encoded as a bit-value literal
+// like b'101'.
+type Bit uint64
+
+// Json represents the value of a JSON column as canonical JSON text, encoded
+// as a quoted string, or CAST('..' AS JSON) if cast is true. data is kept in
+// a string so that values can be compared with ==.
+type Json struct {
+	data string
+	cast bool
+}
+
+// Geometry represents the value of a GEOMETRY column, encoded as
+// ST_GeomFromWKB(x'..', srid). WKB in the binlog is in long-lat order, if
+// longLat is true and srid is not 0, the axis order is given explicitly as
+// ST_GeomFromWKB(x'..', srid, 'axis-order=long-lat'), since mysql 8.0 reads
+// WKB of a geographic SRS in its own axis order. wkb is kept in a string so
+// that values can be compared with ==.
+type Geometry struct {
+	srid    uint32
+	wkb     string
+	longLat bool
+}
+
+// MakeJson makes a Json value from canonical JSON text.
+func MakeJson(text string, cast bool) Json {
+	return Json{data: text, cast: cast}
+}
+
+// MakeGeometry makes a Geometry value from the SRID and WKB bytes, longLat
+// adds the axis-order option for a non-zero SRID.
+func MakeGeometry(srid uint32, wkb []byte, longLat bool) Geometry {
+	return Geometry{srid: srid, wkb: string(wkb), longLat: longLat}
+}
+
+// MakeCharsetString makes a CharsetString from the raw bytes in charset.
+func MakeCharsetString(charset string, b []byte) CharsetString {
+	return CharsetString{charset: charset, data: string(b)}
+}
+
 // MakeNumeric makes a Numeric from a []byte without validation.
 func MakeNumeric(b []byte) Value {
 	return Value{Numeric(b)}
@@ -252,13 +302,15 @@ func BuildValue(goval interface{}) (v Value, err error) {
 		v = Value{Fractional(strconv.AppendFloat(nil, float64(bindVal), 'f', -1, 64))}
 	case float64:
 		v = Value{Fractional(strconv.AppendFloat(nil, bindVal, 'f', -1, 64))}
+	case decimal.Decimal:
+		v = Value{Fractional(DecimalString(bindVal))}
 	case string:
 		v = Value{String{[]byte(bindVal), true}}
 	case []byte:
 		v = Value{String{bindVal, false}}
 	case time.Time:
 		v = Value{String{[]byte(bindVal.Format("2006-01-02 15:04:05.000000")), true}}
-	case Numeric, Fractional, String:
+	case Numeric, Fractional, String, CharsetString, Bit, Json, Geometry:
 		v = Value{bindVal.(InnerValue)}
 	case Value:
 		v = bindVal
@@ -276,6 +328,15 @@ func BuildValue(goval interface{}) (v Value, err error) {
 	return v, nil
 }
 
+// DecimalString returns the digits of a DECIMAL value as stored, keeping the
+// trailing zeros of the scale, e.g. 1.500000 for DECIMAL(20,6).
+func DecimalString(d decimal.Decimal) string {
+	if d.Exponent() < 0 {
+		return d.StringFixed(-d.Exponent())
+	}
+	return d.String()
+}
+
 func ConvertIntUnsigned(arg interface{}, columnType string) interface{} {
 	if i, ok := arg.(int8); ok {
 		return uint8(i)
@@ -586,6 +647,87 @@ func (s String) MarshalBinary() ([]byte, error) {
 	return writeBinary(StringType, s.raw())
 }
 
+func (s CharsetString) raw() []byte {
+	return []byte(s.data)
+}
+
+func (s CharsetString) encodeSql(b encoding2.BinaryWriter) {
+	b.Write([]byte("_" + s.charset + " X'"))
+	encoding2.HexEncodeToWriter(b, s.raw())
+	writebyte(b, '\'')
+}
+
+func (s CharsetString) encodeAscii(b encoding2.BinaryWriter) {
+	s.encodeSql(b)
+}
+
+func (s CharsetString) MarshalBinary() ([]byte, error) {
+	return writeBinary(StringType, s.raw())
+}
+
+func (bit Bit) raw() []byte {
+	return strconv.AppendUint(nil, uint64(bit), 2)
+}
+
+func (bit Bit) encodeSql(b encoding2.BinaryWriter) {
+	b.Write([]byte("b'"))
+	b.Write(bit.raw())
+	writebyte(b, '\'')
+}
+
+func (bit Bit) encodeAscii(b encoding2.BinaryWriter) {
+	bit.encodeSql(b)
+}
+
+func (bit Bit) MarshalBinary() ([]byte, error) {
+	return writeBinary(NumericType, strconv.AppendUint(nil, uint64(bit), 10))
+}
+
+func (j Json) raw() []byte {
+	return []byte(j.data)
+}
+
+func (j Json) encodeSql(b encoding2.BinaryWriter) {
+	if j.cast {
+		b.Write([]byte("CAST("))
+	}
+	String{j.raw(), true}.encodeSql(b)
+	if j.cast {
+		b.Write([]byte(" AS JSON)"))
+	}
+}
+
+func (j Json) encodeAscii(b encoding2.BinaryWriter) {
+	j.encodeSql(b)
+}
+
+func (j Json) MarshalBinary() ([]byte, error) {
+	return writeBinary(UTF8StringType, j.raw())
+}
+
+func (g Geometry) raw() []byte {
+	return []byte(g.wkb)
+}
+
+func (g Geometry) encodeSql(b encoding2.BinaryWriter) {
+	b.Write([]byte("ST_GeomFromWKB(x'"))
+	encoding2.HexEncodeToWriter(b, g.raw())
+	b.Write([]byte("', "))
+	b.Write(strconv.AppendUint(nil, uint64(g.srid), 10))
+	if g.longLat && g.srid != 0 {
+		b.Write([]byte(", 'axis-order=long-lat'"))
+	}
+	writebyte(b, ')')
+}
+
+func (g Geometry) encodeAscii(b encoding2.BinaryWriter) {
+	g.encodeSql(b)
+}
+
+func (g Geometry) MarshalBinary() ([]byte, error) {
+	return writeBinary(StringType, g.raw())
+}
+
 func writebyte(b encoding2.BinaryWriter, c byte) {
 	if err := b.WriteByte(c); err != nil {
 		panic(err)